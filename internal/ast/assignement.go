@@ -1,10 +1,66 @@
 package ast
 
+// Assignement assigns the value of Rhs to an identifier. It is an expression,
+// evaluating to the value assigned.
 type Assignement struct {
 	LHS Identifier
-	Rhs Expression
+	// = or a compound assignment operator, as +=
+	Operator Token
+	Rhs      Expression
+
+	// The expression Assigned returns, built once by NewAssignement
+	assigned Expression
+}
+
+// NewAssignement returns the assignment of rhs to lhs with operator, building
+// what a compound assignment desugars to once rather than on every
+// evaluation.
+func NewAssignement(lhs Identifier, operator Token, rhs Expression) *Assignement {
+	assignement := &Assignement{LHS: lhs, Operator: operator, Rhs: rhs}
+	assignement.assigned = assignement.desugar()
+	return assignement
+}
+
+// compoundOperators maps the compound assignment operators to the binary
+// operators they apply.
+var compoundOperators = map[TokenType]TokenType{
+	PLUS_EQUAL:  Plus,
+	MINUS_EQUAL: Minus,
+	STAR_EQUAL:  Multiplication,
+	SLASH_EQUAL: Division,
+}
+
+func (this *Assignement) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Assignement) Span() Span {
+	return this.LHS.Span().Join(this.Rhs.Span())
+}
+
+// Assigned returns the expression whose value is assigned: Rhs, or for a
+// compound assignment the operation on the identifier and Rhs, as x + 1 for
+// x += 1.
+func (this *Assignement) Assigned() Expression {
+	if this.assigned != nil {
+		return this.assigned
+	}
+	return this.desugar()
+}
+
+func (this *Assignement) desugar() Expression {
+	operator, compound := compoundOperators[this.Operator.Token]
+	if !compound {
+		return this.Rhs
+	}
+	token := Token{Literal: string(operator), Token: operator, Span: this.Operator.Span}
+	return &BinaryExpression{Lhs: &this.LHS, Operator: token, Rhs: this.Rhs}
 }
 
-func (this *Assignement) accept(visitor Visitor) (string, error) {
-	return visitor.visit(this)
+// operator returns the symbol of the operator, = when it is not set.
+func (this *Assignement) operator() string {
+	if this.Operator.Token == "" {
+		return string(EQUAL)
+	}
+	return string(this.Operator.Token)
 }
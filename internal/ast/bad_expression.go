@@ -0,0 +1,17 @@
+package ast
+
+// BadExpression stands for source that could not be parsed, from the From
+// token to the To token included. It only appears in the partial AST returned
+// along with syntax errors.
+type BadExpression struct {
+	From Token
+	To   Token
+}
+
+func (this *BadExpression) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *BadExpression) Span() Span {
+	return this.From.Span.Join(this.To.Span)
+}
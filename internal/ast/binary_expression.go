@@ -6,6 +6,10 @@ type BinaryExpression struct {
 	Rhs      Expression
 }
 
-func (this *BinaryExpression) accept(visitor Visitor) (string, error) {
-	return visitor.visit(this)
+func (this *BinaryExpression) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *BinaryExpression) Span() Span {
+	return this.Lhs.Span().Join(this.Rhs.Span())
 }
@@ -0,0 +1,17 @@
+package ast
+
+// Block is a list of statements between braces, evaluated in a scope of its
+// own. Its value is the value of the last statement.
+type Block struct {
+	Open       Token
+	Statements []Expression
+	Close      Token
+}
+
+func (this *Block) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Block) Span() Span {
+	return this.Open.Span.Join(this.Close.Span)
+}
@@ -0,0 +1,415 @@
+package ast
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builtin is a function implemented in Go and callable from expressions.
+// MaxArity is -1 for functions taking any number of arguments.
+type Builtin struct {
+	Name     string
+	Doc      string
+	MinArity int
+	MaxArity int
+	Fn       func(ctx context.Context, args []Value) (Value, error)
+}
+
+func (this *Builtin) Type() ValueType {
+	return FunctionType
+}
+
+func (this *Builtin) String() string {
+	return "<builtin " + this.Name + ">"
+}
+
+// CheckArity reports an error unless the builtin accepts count arguments.
+func (this *Builtin) CheckArity(count int) error {
+	if count >= this.MinArity && (this.MaxArity < 0 || count <= this.MaxArity) {
+		return nil
+	}
+	switch {
+	case this.MaxArity < 0:
+		return fmt.Errorf("%s expects at least %s, got %d", this.Name, plural(this.MinArity, "argument"), count)
+	case this.MinArity == this.MaxArity:
+		return fmt.Errorf("%s expects %s, got %d", this.Name, plural(this.MinArity, "argument"), count)
+	default:
+		return fmt.Errorf("%s expects %d to %d arguments, got %d", this.Name, this.MinArity, this.MaxArity, count)
+	}
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+var builtins = map[string]*Builtin{}
+
+var constants = map[string]Value{
+	"pi": Number(math.Pi),
+	"e":  Number(math.E),
+}
+
+// Builtins lists the built-in functions sorted by name.
+func Builtins() []*Builtin {
+	res := make([]*Builtin, 0, len(builtins))
+	for _, builtin := range builtins {
+		res = append(res, builtin)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func LookupBuiltin(name string) (*Builtin, bool) {
+	builtin, exist := builtins[name]
+	return builtin, exist
+}
+
+// Constants returns a copy of the built-in constants.
+func Constants() map[string]Value {
+	res := make(map[string]Value, len(constants))
+	for name, value := range constants {
+		res[name] = value
+	}
+	return res
+}
+
+func LookupConstant(name string) (Value, bool) {
+	value, exist := constants[name]
+	return value, exist
+}
+
+func register(builtin *Builtin) {
+	builtins[builtin.Name] = builtin
+}
+
+func init() {
+	unary := map[string]struct {
+		doc string
+		fn  func(float64) float64
+	}{
+		"sqrt":  {"square root of x", math.Sqrt},
+		"cbrt":  {"cube root of x", math.Cbrt},
+		"abs":   {"absolute value of x", math.Abs},
+		"floor": {"greatest integer less than or equal to x", math.Floor},
+		"ceil":  {"least integer greater than or equal to x", math.Ceil},
+		"trunc": {"integer part of x", math.Trunc},
+		"ln":    {"natural logarithm of x", math.Log},
+		"exp":   {"e raised to the power of x", math.Exp},
+		"sin":   {"sine of x radians", math.Sin},
+		"cos":   {"cosine of x radians", math.Cos},
+		"tan":   {"tangent of x radians", math.Tan},
+		"asin":  {"arcsine of x, in radians", math.Asin},
+		"acos":  {"arccosine of x, in radians", math.Acos},
+		"atan":  {"arctangent of x, in radians", math.Atan},
+		"sinh":  {"hyperbolic sine of x", math.Sinh},
+		"cosh":  {"hyperbolic cosine of x", math.Cosh},
+		"tanh":  {"hyperbolic tangent of x", math.Tanh},
+	}
+	// Functions that are exact on decimals, and on integers and rationals
+	exact := map[string]struct {
+		decimal  func(Decimal) Decimal
+		rational func(*big.Rat) *big.Rat
+	}{
+		"abs":   {Decimal.abs, func(x *big.Rat) *big.Rat { return new(big.Rat).Abs(x) }},
+		"floor": {Decimal.floor, ratFloor},
+		"ceil":  {Decimal.ceil, func(x *big.Rat) *big.Rat { return new(big.Rat).Neg(ratFloor(new(big.Rat).Neg(x))) }},
+		"trunc": {func(x Decimal) Decimal { return x.round(0, RoundDown) }, ratTrunc},
+	}
+	for name, function := range unary {
+		fn := numberFunction(name, func(args []float64) float64 { return function.fn(args[0]) })
+		if exactFn, ok := exact[name]; ok {
+			fn = exactFunction(func(args []*big.Rat) (Value, error) { return exactValue(exactFn.rational(args[0])), nil }, fn)
+			fn = decimalFunction(func(args []Decimal) (Value, error) { return exactFn.decimal(args[0]), nil }, fn)
+		}
+		register(&Builtin{Name: name, Doc: name + "(x): " + function.doc, MinArity: 1, MaxArity: 1, Fn: fn})
+	}
+
+	binary := map[string]struct {
+		doc string
+		fn  func(float64, float64) float64
+	}{
+		"pow":   {"pow(x, y): x raised to the power of y", math.Pow},
+		"hypot": {"hypot(x, y): square root of x*x + y*y", math.Hypot},
+		"atan2": {"atan2(y, x): arctangent of y/x, using the signs of both to pick the quadrant", math.Atan2},
+	}
+	for name, function := range binary {
+		register(&Builtin{Name: name, Doc: function.doc, MinArity: 2, MaxArity: 2,
+			Fn: numberFunction(name, func(args []float64) float64 { return function.fn(args[0], args[1]) })})
+	}
+
+	register(&Builtin{Name: "round", Doc: "round(x, digits?): x rounded half away from zero to digits decimals (0 by default)",
+		MinArity: 1, MaxArity: 2, Fn: decimalFunction(func(args []Decimal) (Value, error) {
+			digits := 0
+			if len(args) > 1 {
+				var ok bool
+				if digits, ok = args[1].integer(); !ok {
+					return nil, fmt.Errorf("round expects an integer as argument 2, got %s", args[1])
+				}
+			}
+			if digits < 0 {
+				return args[0].shift(digits).round(0, RoundHalfUp).shift(-digits), nil
+			}
+			return args[0].round(digits, RoundHalfUp), nil
+		}, exactFunction(func(args []*big.Rat) (Value, error) {
+			if len(args) == 1 {
+				return exactValue(ratRound(args[0])), nil
+			}
+			digits, err := integerArgument("round", []Value{exactValue(args[1])}, 0)
+			if err != nil {
+				return nil, fmt.Errorf("round expects an integer as argument 2, got %s", exactValue(args[1]))
+			}
+			scale := new(big.Rat).SetFrac(pow10(max(digits, 0)), pow10(max(-digits, 0)))
+			rounded := ratRound(new(big.Rat).Mul(args[0], scale))
+			return exactValue(rounded.Quo(rounded, scale)), nil
+		}, numberFunction("round", func(args []float64) float64 {
+			if len(args) == 1 {
+				return math.Round(args[0])
+			}
+			scale := math.Pow(10, math.Trunc(args[1]))
+			return math.Round(args[0]*scale) / scale
+		})))})
+	register(&Builtin{Name: "log", Doc: "log(x, base?): logarithm of x in base (10 by default)",
+		MinArity: 1, MaxArity: 2, Fn: numberFunction("log", func(args []float64) float64 {
+			if len(args) == 1 {
+				return math.Log10(args[0])
+			}
+			return math.Log(args[0]) / math.Log(args[1])
+		})})
+	register(&Builtin{Name: "min", Doc: "min(x, ...): smallest of its arguments",
+		MinArity: 1, MaxArity: -1, Fn: decimalFunction(func(args []Decimal) (Value, error) {
+			res := args[0]
+			for _, arg := range args[1:] {
+				if arg.Cmp(res) < 0 {
+					res = arg
+				}
+			}
+			return res, nil
+		}, exactFunction(func(args []*big.Rat) (Value, error) {
+			res := args[0]
+			for _, arg := range args[1:] {
+				if arg.Cmp(res) < 0 {
+					res = arg
+				}
+			}
+			return exactValue(res), nil
+		}, numberFunction("min", func(args []float64) float64 {
+			res := args[0]
+			for _, arg := range args[1:] {
+				res = math.Min(res, arg)
+			}
+			return res
+		})))})
+	register(&Builtin{Name: "max", Doc: "max(x, ...): largest of its arguments",
+		MinArity: 1, MaxArity: -1, Fn: decimalFunction(func(args []Decimal) (Value, error) {
+			res := args[0]
+			for _, arg := range args[1:] {
+				if arg.Cmp(res) > 0 {
+					res = arg
+				}
+			}
+			return res, nil
+		}, exactFunction(func(args []*big.Rat) (Value, error) {
+			res := args[0]
+			for _, arg := range args[1:] {
+				if arg.Cmp(res) > 0 {
+					res = arg
+				}
+			}
+			return exactValue(res), nil
+		}, numberFunction("max", func(args []float64) float64 {
+			res := args[0]
+			for _, arg := range args[1:] {
+				res = math.Max(res, arg)
+			}
+			return res
+		})))})
+
+	register(&Builtin{Name: "len", Doc: "len(s): number of characters of s",
+		MinArity: 1, MaxArity: 1, Fn: func(ctx context.Context, args []Value) (Value, error) {
+			s, err := stringArgument("len", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return NewInteger(int64(utf8.RuneCountInString(s))), nil
+		}})
+	register(&Builtin{Name: "upper", Doc: "upper(s): s in upper case",
+		MinArity: 1, MaxArity: 1, Fn: stringFunction("upper", strings.ToUpper)})
+	register(&Builtin{Name: "lower", Doc: "lower(s): s in lower case",
+		MinArity: 1, MaxArity: 1, Fn: stringFunction("lower", strings.ToLower)})
+	register(&Builtin{Name: "contains", Doc: "contains(s, substring): whether substring is within s",
+		MinArity: 2, MaxArity: 2, Fn: func(ctx context.Context, args []Value) (Value, error) {
+			s, err := stringArgument("contains", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			substring, err := stringArgument("contains", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return Boolean(strings.Contains(s, substring)), nil
+		}})
+	register(&Builtin{Name: "replace", Doc: "replace(s, old, new): s with every old replaced by new",
+		MinArity: 3, MaxArity: 3, Fn: func(ctx context.Context, args []Value) (Value, error) {
+			strs := make([]string, len(args))
+			for i := range args {
+				var err error
+				if strs[i], err = stringArgument("replace", args, i); err != nil {
+					return nil, err
+				}
+			}
+			return String(strings.ReplaceAll(strs[0], strs[1], strs[2])), nil
+		}})
+	register(&Builtin{Name: "split", Doc: "split(s, separator, index): field index, from 0, of s split around separator",
+		MinArity: 3, MaxArity: 3, Fn: func(ctx context.Context, args []Value) (Value, error) {
+			s, err := stringArgument("split", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			separator, err := stringArgument("split", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			index, err := integerArgument("split", args, 2)
+			if err != nil {
+				return nil, err
+			}
+			fields := strings.Split(s, separator)
+			if index < 0 || index >= len(fields) {
+				return nil, fmt.Errorf("split index %d out of range for %s", index, plural(len(fields), "field"))
+			}
+			return String(fields[index]), nil
+		}})
+	register(&Builtin{Name: "substr", Doc: "substr(s, start, length?): length characters of s from start, from 0, up to the end by default",
+		MinArity: 2, MaxArity: 3, Fn: func(ctx context.Context, args []Value) (Value, error) {
+			s, err := stringArgument("substr", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			characters := []rune(s)
+			start, err := integerArgument("substr", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			if start < 0 || start > len(characters) {
+				return nil, fmt.Errorf("substr start %d out of range for %s", start, plural(len(characters), "character"))
+			}
+			length := len(characters) - start
+			if len(args) > 2 {
+				if length, err = integerArgument("substr", args, 2); err != nil {
+					return nil, err
+				}
+				if length < 0 || start+length > len(characters) {
+					return nil, fmt.Errorf("substr length %d out of range for %s from %d", length, plural(len(characters), "character"), start)
+				}
+			}
+			return String(characters[start : start+length]), nil
+		}})
+	register(&Builtin{Name: "number", Doc: "number(s): the number s spells",
+		MinArity: 1, MaxArity: 1, Fn: func(ctx context.Context, args []Value) (Value, error) {
+			s, err := stringArgument("number", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			if integer, err := parseInteger(strings.TrimSpace(s)); err == nil {
+				return integer, nil
+			}
+			number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot convert %s to number", Quote(s))
+			}
+			return Number(number), nil
+		}})
+	register(&Builtin{Name: "float", Doc: "float(x): the float closest to x",
+		MinArity: 1, MaxArity: 1, Fn: numberFunction("float", func(args []float64) float64 { return args[0] })})
+}
+
+// numberFunction adapts fn to a builtin that only accepts numbers. Other kinds
+// of numbers are converted to floats.
+func numberFunction(name string, fn func(args []float64) float64) func(ctx context.Context, args []Value) (Value, error) {
+	return func(ctx context.Context, args []Value) (Value, error) {
+		numbers := make([]float64, len(args))
+		for i, arg := range args {
+			number, ok := floatValue(arg).(Number)
+			if !ok {
+				return nil, fmt.Errorf("%s expects a number as argument %d, got %s", name, i+1, arg.Type())
+			}
+			numbers[i] = float64(number)
+		}
+		return Number(fn(numbers)), nil
+	}
+}
+
+// decimalFunction adapts a builtin on numbers, fn, to compute exactly with
+// exact when one of its arguments is a Decimal and all of them are numbers.
+func decimalFunction(exact func(args []Decimal) (Value, error), fn func(ctx context.Context, args []Value) (Value, error)) func(ctx context.Context, args []Value) (Value, error) {
+	return func(ctx context.Context, args []Value) (Value, error) {
+		decimals := make([]Decimal, len(args))
+		anyDecimal := false
+		for i, arg := range args {
+			_, isDecimal := arg.(Decimal)
+			anyDecimal = anyDecimal || isDecimal
+			var ok bool
+			if decimals[i], ok = toDecimal(arg); !ok {
+				return fn(ctx, args)
+			}
+		}
+		if !anyDecimal {
+			return fn(ctx, args)
+		}
+		return exact(decimals)
+	}
+}
+
+// exactFunction adapts a builtin on numbers, fn, to compute exactly with exact
+// when all of its arguments are integers or rationals.
+func exactFunction(exact func(args []*big.Rat) (Value, error), fn func(ctx context.Context, args []Value) (Value, error)) func(ctx context.Context, args []Value) (Value, error) {
+	return func(ctx context.Context, args []Value) (Value, error) {
+		rats := make([]*big.Rat, len(args))
+		for i, arg := range args {
+			var ok bool
+			if rats[i], ok = toRat(arg); !ok {
+				return fn(ctx, args)
+			}
+		}
+		return exact(rats)
+	}
+}
+
+// stringFunction adapts fn to a builtin taking a single string.
+func stringFunction(name string, fn func(string) string) func(ctx context.Context, args []Value) (Value, error) {
+	return func(ctx context.Context, args []Value) (Value, error) {
+		s, err := stringArgument(name, args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return String(fn(s)), nil
+	}
+}
+
+func stringArgument(name string, args []Value, i int) (string, error) {
+	s, ok := args[i].(String)
+	if !ok {
+		return "", fmt.Errorf("%s expects a string as argument %d, got %s", name, i+1, args[i].Type())
+	}
+	return string(s), nil
+}
+
+func integerArgument(name string, args []Value, i int) (int, error) {
+	number, ok := floatValue(args[i]).(Number)
+	if !ok {
+		return 0, fmt.Errorf("%s expects an integer as argument %d, got %s", name, i+1, args[i].Type())
+	}
+	if number != Number(math.Trunc(float64(number))) || math.Abs(float64(number)) > math.MaxInt32 {
+		return 0, fmt.Errorf("%s expects an integer as argument %d, got %s", name, i+1, number)
+	}
+	return int(number), nil
+}
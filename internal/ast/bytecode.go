@@ -0,0 +1,652 @@
+package ast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Opcode is an instruction of the virtual machine. Operands follow the opcode
+// in the code of a Chunk, each one encoded on two bytes, big endian.
+type Opcode byte
+
+const (
+	// CONSTANT index: push Constants[index]
+	OpConstant Opcode = iota
+	// POP: discard the top of the stack
+	OpPop
+	// GET_GLOBAL slot: push the value of a global
+	OpGetGlobal
+	// SET_GLOBAL slot: store the top of the stack in a global
+	OpSetGlobal
+	// DEFINE_GLOBAL slot: SET_GLOBAL failing if the global is declared
+	OpDefineGlobal
+	// GET_DECLARED slot: push a global declared by the program or bound by
+	// the caller, nil otherwise
+	OpGetDeclared
+	// GET_LOCAL depth slot: push a slot of the scope depth levels up
+	OpGetLocal
+	// SET_LOCAL depth slot: store the top of the stack in a local
+	OpSetLocal
+	// DEFINE_LOCAL slot: SET_LOCAL 0 slot failing if the local is declared
+	OpDefineLocal
+	// ADD .. SHIFT_RIGHT: pop two operands, push the result
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+	OpIntegerDivide
+	OpModulo
+	OpPower
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	// NEGATE, NOT, BIT_NOT: replace the top of the stack by the result
+	OpNegate
+	OpNot
+	OpBitNot
+	// CHECK_BOOL operand: fail unless the top of the stack is a bool, operand
+	// being 0 for the left operand of a logical expression, 1 for the right
+	OpCheckBool
+	// SHORT_CIRCUIT target: jump to target, keeping the top of the stack, if it
+	// decides the logical expression. Pop it otherwise
+	OpShortCircuit
+	// ENTER_SCOPE size: open a scope of size slots
+	OpEnterScope
+	// EXIT_SCOPE: close the innermost scope
+	OpExitScope
+	// CLOSURE index: push Functions[index] closed over the current scope
+	OpClosure
+	// CALLEE arguments: fail unless the top of the stack can be called with
+	// that many arguments
+	OpCallee
+	// CALL arguments: call the function below the arguments, replacing them
+	// by the result
+	OpCall
+	// RETURN: return the top of the stack to the caller
+	OpReturn
+	// ERROR index: fail with Errors[index]
+	OpError
+	// INTERPOLATE count: replace the count values on top of the stack by the
+	// concatenation of their text
+	OpInterpolate
+	// BRANCH target: pop the condition of a conditional expression or loop,
+	// failing unless it is a bool, and jump to target when it is false
+	OpBranch
+	// JUMP target: continue at target
+	OpJump
+	// JUMP_IF_SET target: jump to target, keeping the top of the stack, if it
+	// is set. Pop it otherwise
+	OpJumpIfSet
+	// ENTER_LOOP: record the height of the stack and the current scope, to
+	// which BREAK and CONTINUE return
+	OpEnterLoop
+	// EXIT_LOOP: discard the record of the innermost loop
+	OpExitLoop
+	// LOOP target: fail if the run is cancelled, jump back to target otherwise
+	OpLoop
+	// RANGE: fail unless the two values on top of the stack, the bounds of a
+	// for loop, are numbers
+	OpRange
+	// FOR_ITERATE target: jump to target if the number below the top of the
+	// stack reached the one on top. Store it in the first slot of the current
+	// scope and increment it otherwise
+	OpForIterate
+	// BREAK target, CONTINUE target: restore the stack and scope recorded by
+	// the innermost loop and jump to target
+	OpBreak
+	OpContinue
+)
+
+var opcodes = [...]struct {
+	name     string
+	operands int
+}{
+	OpConstant:      {"CONSTANT", 1},
+	OpPop:           {"POP", 0},
+	OpGetGlobal:     {"GET_GLOBAL", 1},
+	OpSetGlobal:     {"SET_GLOBAL", 1},
+	OpDefineGlobal:  {"DEFINE_GLOBAL", 1},
+	OpGetDeclared:   {"GET_DECLARED", 1},
+	OpGetLocal:      {"GET_LOCAL", 2},
+	OpSetLocal:      {"SET_LOCAL", 2},
+	OpDefineLocal:   {"DEFINE_LOCAL", 1},
+	OpAdd:           {"ADD", 0},
+	OpSubtract:      {"SUBTRACT", 0},
+	OpMultiply:      {"MULTIPLY", 0},
+	OpDivide:        {"DIVIDE", 0},
+	OpEqual:         {"EQUAL", 0},
+	OpNotEqual:      {"NOT_EQUAL", 0},
+	OpLess:          {"LESS", 0},
+	OpLessEqual:     {"LESS_EQUAL", 0},
+	OpGreater:       {"GREATER", 0},
+	OpGreaterEqual:  {"GREATER_EQUAL", 0},
+	OpIntegerDivide: {"INTEGER_DIVIDE", 0},
+	OpModulo:        {"MODULO", 0},
+	OpPower:         {"POWER", 0},
+	OpBitAnd:        {"BIT_AND", 0},
+	OpBitOr:         {"BIT_OR", 0},
+	OpBitXor:        {"BIT_XOR", 0},
+	OpShiftLeft:     {"SHIFT_LEFT", 0},
+	OpShiftRight:    {"SHIFT_RIGHT", 0},
+	OpNegate:        {"NEGATE", 0},
+	OpNot:           {"NOT", 0},
+	OpBitNot:        {"BIT_NOT", 0},
+	OpCheckBool:     {"CHECK_BOOL", 1},
+	OpShortCircuit:  {"SHORT_CIRCUIT", 1},
+	OpEnterScope:    {"ENTER_SCOPE", 1},
+	OpExitScope:     {"EXIT_SCOPE", 0},
+	OpClosure:       {"CLOSURE", 1},
+	OpCallee:        {"CALLEE", 1},
+	OpCall:          {"CALL", 1},
+	OpReturn:        {"RETURN", 0},
+	OpError:         {"ERROR", 1},
+	OpInterpolate:   {"INTERPOLATE", 1},
+	OpBranch:        {"BRANCH", 1},
+	OpJump:          {"JUMP", 1},
+	OpJumpIfSet:     {"JUMP_IF_SET", 1},
+	OpEnterLoop:     {"ENTER_LOOP", 0},
+	OpExitLoop:      {"EXIT_LOOP", 0},
+	OpLoop:          {"LOOP", 1},
+	OpRange:         {"RANGE", 0},
+	OpForIterate:    {"FOR_ITERATE", 1},
+	OpBreak:         {"BREAK", 1},
+	OpContinue:      {"CONTINUE", 1},
+}
+
+func (this Opcode) String() string {
+	if int(this) < len(opcodes) {
+		return opcodes[this].name
+	}
+	return fmt.Sprintf("OPCODE(%d)", byte(this))
+}
+
+// Operands returns the number of operands following the opcode.
+func (this Opcode) Operands() int {
+	return opcodes[this].operands
+}
+
+var binaryOpcodes = map[TokenType]Opcode{
+	Plus:            OpAdd,
+	Minus:           OpSubtract,
+	Multiplication:  OpMultiply,
+	Division:        OpDivide,
+	EQUAL_EQUAL:     OpEqual,
+	BANG_EQUAL:      OpNotEqual,
+	LESS:            OpLess,
+	LESS_EQUAL:      OpLessEqual,
+	GREATER:         OpGreater,
+	GREATER_EQUAL:   OpGreaterEqual,
+	IntegerDivision: OpIntegerDivide,
+	Modulo:          OpModulo,
+	Power:           OpPower,
+	BIT_AND:         OpBitAnd,
+	BIT_OR:          OpBitOr,
+	BIT_XOR:         OpBitXor,
+	LEFT_SHIFT:      OpShiftLeft,
+	RIGHT_SHIFT:     OpShiftRight,
+}
+
+// maxOperand is the largest value an operand can encode.
+const maxOperand = 1<<16 - 1
+
+// Chunk is the bytecode of a program or of a function.
+type Chunk struct {
+	Name      string
+	Code      []byte
+	Constants []Value
+	Functions []*FunctionChunk
+	Errors    []error
+	// Expression each instruction was compiled from, indexed by the offset of
+	// its opcode. Used to report errors.
+	nodes []Expression
+}
+
+// FunctionChunk is the bytecode of a function, before it is closed over a
+// scope.
+type FunctionChunk struct {
+	Chunk
+	Parameters int
+	// Slots of the scope of a call, parameters included
+	Size int
+}
+
+func (this *FunctionChunk) String() string {
+	if this.Name == "" {
+		return "<fn>"
+	}
+	return "<fn " + this.Name + ">"
+}
+
+// BytecodeFunction is a function created by a Bytecode program.
+type BytecodeFunction struct {
+	Function *FunctionChunk
+	closure  *frame
+}
+
+func (this *BytecodeFunction) Type() ValueType {
+	return FunctionType
+}
+
+func (this *BytecodeFunction) String() string {
+	return this.Function.String()
+}
+
+// Bytecode is an AST compiled to instructions for the virtual machine. Its
+// identifiers are resolved to slots as for a CompiledProgram, and it is run
+// the same way. It is safe for concurrent use.
+type Bytecode struct {
+	Chunk   *Chunk
+	globals []string
+	slots   map[string]int
+	runs    sync.Pool
+}
+
+type bytecodeCompiler struct {
+	resolver
+	chunk *Chunk
+	// Loops enclosing the code being compiled, innermost last
+	loops []*loopJumps
+	err   error
+}
+
+// loopJumps are the offsets of the BREAK and CONTINUE instructions of a loop,
+// patched once their targets are known.
+type loopJumps struct {
+	breaks    []int
+	continues []int
+}
+
+// CompileBytecode compiles exp to bytecode. It fails when exp does not fit the
+// limits of the instruction set.
+func CompileBytecode(exp Expression) (*Bytecode, error) {
+	compiler := &bytecodeCompiler{resolver: newResolver(exp), chunk: &Chunk{Name: "<program>"}}
+	compiler.compile(exp)
+	compiler.emit(exp, OpReturn)
+	if compiler.err != nil {
+		return nil, compiler.err
+	}
+	return &Bytecode{Chunk: compiler.chunk, globals: compiler.globals, slots: compiler.slots}, nil
+}
+
+// Globals returns the names of the global slots, indexed by slot.
+func (this *Bytecode) Globals() []string {
+	return this.globals
+}
+
+func (this *Bytecode) Slot(name string) (int, bool) {
+	slot, exist := this.slots[name]
+	return slot, exist
+}
+
+func (this *bytecodeCompiler) compile(exp Expression) {
+	switch e := exp.(type) {
+	case *Program:
+		this.compileStatements(e, e.Statements)
+	case *Block:
+		enter := this.emit(e, OpEnterScope, 0)
+		scope := this.enterScope()
+		this.compileStatements(e, e.Statements)
+		this.scope = scope.parent
+		this.patch(enter, *scope.size)
+		this.emit(e, OpExitScope)
+	case *VarDeclaration:
+		this.compileDeclaration(e, e.Operand.TokenLiteral.Literal, func() {
+			this.emitConstant(e, NewInteger(0))
+		})
+	case *FunctionDeclaration:
+		name := e.Name.TokenLiteral.Literal
+		this.compileDeclaration(e, name, func() {
+			this.compileFunction(e, name, e.Parameters, e.Body)
+		})
+	case *Lambda:
+		this.compileFunction(e, "", e.Parameters, e.Body)
+	case *Assignement:
+		this.compile(e.Assigned())
+		this.compileAssignement(e)
+	case *Call:
+		this.compile(e.Callee)
+		this.emit(e, OpCallee, len(e.Arguments))
+		for _, argument := range e.Arguments {
+			this.compile(argument)
+		}
+		this.emit(e, OpCall, len(e.Arguments))
+	case *BinaryExpression:
+		this.compile(e.Lhs)
+		this.compile(e.Rhs)
+		opcode, exist := binaryOpcodes[e.Operator.Token]
+		if !exist {
+			this.emitError(e, fmt.Errorf("unsupported operator %s", e.Operator.Literal))
+			return
+		}
+		this.emit(e, opcode)
+	case *LogicalExpression:
+		this.compile(e.Lhs)
+		this.emit(e, OpCheckBool, 0)
+		jump := this.emit(e, OpShortCircuit, 0)
+		this.compile(e.Rhs)
+		this.emit(e, OpCheckBool, 1)
+		this.patch(jump, len(this.chunk.Code))
+	case *ConditionalExpression:
+		this.compile(e.Condition)
+		branch := this.emit(e.Condition, OpBranch, 0)
+		this.compile(e.Then)
+		jump := this.emit(e, OpJump, 0)
+		this.patch(branch, len(this.chunk.Code))
+		if e.Else != nil {
+			this.compile(e.Else)
+		} else {
+			this.emitConstant(e, Nil{})
+		}
+		this.patch(jump, len(this.chunk.Code))
+	case *While:
+		this.emit(e, OpEnterLoop)
+		start := len(this.chunk.Code)
+		this.compile(e.Condition)
+		branch := this.emit(e.Condition, OpBranch, 0)
+		this.patch(branch, this.compileLoopBody(e, e.Body, start))
+		this.emitConstant(e, Nil{})
+	case *For:
+		this.compileFor(e)
+	case *Break:
+		if len(this.loops) == 0 {
+			this.emitError(e, diagnosticFor(e, errBreak))
+			return
+		}
+		loop := this.loops[len(this.loops)-1]
+		loop.breaks = append(loop.breaks, this.emit(e, OpBreak, 0))
+	case *Continue:
+		if len(this.loops) == 0 {
+			this.emitError(e, diagnosticFor(e, errContinue))
+			return
+		}
+		loop := this.loops[len(this.loops)-1]
+		loop.continues = append(loop.continues, this.emit(e, OpContinue, 0))
+	case *UnaryExpression:
+		this.compile(e.Operand)
+		switch e.Operator.Token {
+		case Minus:
+			this.emit(e, OpNegate)
+		case BANG:
+			this.emit(e, OpNot)
+		case BIT_NOT:
+			this.emit(e, OpBitNot)
+		default:
+			this.emitError(e, fmt.Errorf("unsupported unary operator %s", e.Operator.Literal))
+		}
+	case *Interpolation:
+		texts, err := e.Strings()
+		if err != nil {
+			this.emitError(e, diagnosticFor(e, err))
+			return
+		}
+		count := 0
+		for i, text := range texts {
+			if text != "" {
+				this.emitConstant(e, String(text))
+				count++
+			}
+			if i < len(e.Parts) {
+				this.compile(e.Parts[i])
+				count++
+			}
+		}
+		this.emit(e, OpInterpolate, count)
+	case *CONSTANT:
+		value, err := constantValue(e.TokenLiteral)
+		if err != nil {
+			this.emitError(e, diagnosticFor(e, err))
+			return
+		}
+		this.emitConstant(e, value)
+	case *Identifier:
+		this.compileIdentifier(e)
+	case *BadExpression:
+		this.emitError(e, diagnosticFor(e, fmt.Errorf("cannot evaluate source with syntax errors")))
+	default:
+		this.emitError(exp, fmt.Errorf("unsupported expression %T", exp))
+	}
+}
+
+// compileStatements leaves the value of the last statement on the stack, nil
+// when there is none.
+func (this *bytecodeCompiler) compileStatements(exp Expression, statements []Expression) {
+	this.hoist(statements)
+	if len(statements) == 0 {
+		this.emitConstant(exp, Nil{})
+		return
+	}
+	for i, statement := range statements {
+		if i > 0 {
+			this.emit(statement, OpPop)
+		}
+		this.compile(statement)
+	}
+}
+
+// compileFor keeps the next value of the variable and the end of the range on
+// the stack, and the variable in a scope of its own in which the body is
+// nested.
+func (this *bytecodeCompiler) compileFor(loop *For) {
+	this.compile(loop.Start)
+	this.compile(loop.End)
+	this.emit(loop, OpRange)
+	this.emit(loop, OpEnterScope, 1)
+	scope := this.enterScope()
+	this.declareLocal(loop.Variable.TokenLiteral.Literal)
+	this.emit(loop, OpEnterLoop)
+	start := this.emit(loop, OpForIterate, 0)
+	this.patch(start, this.compileLoopBody(loop, loop.Body, start))
+	this.scope = scope.parent
+	this.emit(loop, OpExitScope)
+	this.emit(loop, OpPop)
+	this.emit(loop, OpPop)
+	this.emitConstant(loop, Nil{})
+}
+
+// compileLoopBody compiles body, discarding its value, and the end of the
+// loop: jumping back to start, which must follow its ENTER_LOOP, then
+// EXIT_LOOP, the target of BREAK. It returns the offset of EXIT_LOOP.
+func (this *bytecodeCompiler) compileLoopBody(loop Expression, body *Block, start int) int {
+	jumps := &loopJumps{}
+	this.loops = append(this.loops, jumps)
+	this.compile(body)
+	this.loops = this.loops[:len(this.loops)-1]
+
+	this.emit(body, OpPop)
+	next := this.emit(loop, OpLoop, start)
+	exit := this.emit(loop, OpExitLoop)
+	for _, offset := range jumps.continues {
+		this.patch(offset, next)
+	}
+	for _, offset := range jumps.breaks {
+		this.patch(offset, exit)
+	}
+	return exit
+}
+
+// compileDeclaration binds name in the current scope to the value pushed by
+// value. Declaring a name twice in the same scope fails when the second
+// declaration is executed, as it does with the Evaluator.
+func (this *bytecodeCompiler) compileDeclaration(exp Expression, name string, value func()) {
+	if this.scope == nil {
+		slot := this.globalSlot(name)
+		value()
+		this.emit(exp, OpDefineGlobal, slot)
+		return
+	}
+	slot, exist := this.scope.names[name]
+	if !exist {
+		slot = this.declareLocal(name)
+	}
+	// The code compiled after a declaration runs once it is executed
+	delete(this.scope.unset, slot)
+	value()
+	this.emit(exp, OpDefineLocal, slot)
+}
+
+// compileAssignement stores the top of the stack in the closest binding of
+// the identifier assigned by exp, or declares it in the current scope when
+// there is none, as Environment.Assign does.
+func (this *bytecodeCompiler) compileAssignement(exp *Assignement) {
+	name := exp.LHS.TokenLiteral.Literal
+	if this.scope == nil {
+		this.emit(exp, OpSetGlobal, this.globalSlot(name))
+		return
+	}
+	slots, bound := this.resolveSlots(name)
+	if bound && len(slots) == 1 {
+		this.emit(exp, OpSetLocal, slots[0].depth, slots[0].slot)
+		return
+	}
+
+	// Each slot that may be unset, then the global, is pushed and checked in
+	// turn, the first one that is set being assigned
+	checked := slots
+	if bound {
+		checked = slots[:len(slots)-1]
+	}
+	jumps := make([]int, 0, len(checked)+1)
+	for _, local := range checked {
+		this.emit(exp, OpGetLocal, local.depth, local.slot)
+		jumps = append(jumps, this.emit(exp, OpJumpIfSet, 0))
+	}
+	global := -1
+	if !bound && this.global[name] {
+		global = this.globalSlot(name)
+		this.emit(exp, OpGetDeclared, global)
+		jumps = append(jumps, this.emit(exp, OpJumpIfSet, 0))
+	}
+	if bound {
+		last := slots[len(slots)-1]
+		this.emit(exp, OpSetLocal, last.depth, last.slot)
+	} else {
+		own, exist := this.scope.names[name]
+		if !exist {
+			own = this.declareLocal(name)
+			this.scope.unset[own] = true
+		}
+		this.emit(exp, OpSetLocal, 0, own)
+	}
+
+	ends := []int{this.emit(exp, OpJump, 0)}
+	for i, local := range checked {
+		this.patch(jumps[i], len(this.chunk.Code))
+		this.emit(exp, OpPop)
+		this.emit(exp, OpSetLocal, local.depth, local.slot)
+		ends = append(ends, this.emit(exp, OpJump, 0))
+	}
+	if global >= 0 {
+		this.patch(jumps[len(jumps)-1], len(this.chunk.Code))
+		this.emit(exp, OpPop)
+		this.emit(exp, OpSetGlobal, global)
+	}
+	for _, end := range ends {
+		this.patch(end, len(this.chunk.Code))
+	}
+}
+
+// compileIdentifier pushes the closest binding of identifier, skipping the
+// slots still unset as Environment.Lookup skips the scopes in which it is not
+// defined yet.
+func (this *bytecodeCompiler) compileIdentifier(identifier *Identifier) {
+	slots, bound := this.resolveSlots(identifier.TokenLiteral.Literal)
+	jumps := make([]int, 0, len(slots))
+	for i, local := range slots {
+		this.emit(identifier, OpGetLocal, local.depth, local.slot)
+		if bound && i == len(slots)-1 {
+			break
+		}
+		jumps = append(jumps, this.emit(identifier, OpJumpIfSet, 0))
+	}
+	if !bound {
+		this.emit(identifier, OpGetGlobal, this.globalSlot(identifier.TokenLiteral.Literal))
+	}
+	for _, jump := range jumps {
+		this.patch(jump, len(this.chunk.Code))
+	}
+}
+
+// compileFunction compiles a function to a chunk of its own and pushes it,
+// closed over the current scope.
+func (this *bytecodeCompiler) compileFunction(exp Expression, name string, parameters []Identifier, body Expression) {
+	function := &FunctionChunk{Chunk: Chunk{Name: name}, Parameters: len(parameters)}
+	enclosing, loops := this.chunk, this.loops
+	this.chunk, this.loops = &function.Chunk, nil
+
+	scope := this.enterScope()
+	for _, parameter := range parameters {
+		this.declareLocal(parameter.TokenLiteral.Literal)
+	}
+	if block, ok := body.(*Block); ok {
+		this.compileStatements(block, block.Statements)
+	} else {
+		this.compile(body)
+	}
+	this.emit(body, OpReturn)
+	this.scope = scope.parent
+	function.Size = *scope.size
+
+	this.chunk, this.loops = enclosing, loops
+	if len(this.chunk.Functions) > maxOperand {
+		this.fail(exp, "too many functions")
+		return
+	}
+	this.chunk.Functions = append(this.chunk.Functions, function)
+	this.emit(exp, OpClosure, len(this.chunk.Functions)-1)
+}
+
+func (this *bytecodeCompiler) emitConstant(exp Expression, value Value) {
+	if len(this.chunk.Constants) > maxOperand {
+		this.fail(exp, "too many constants")
+		return
+	}
+	this.chunk.Constants = append(this.chunk.Constants, value)
+	this.emit(exp, OpConstant, len(this.chunk.Constants)-1)
+}
+
+func (this *bytecodeCompiler) emitError(exp Expression, err error) {
+	if len(this.chunk.Errors) > maxOperand {
+		this.fail(exp, "too many errors")
+		return
+	}
+	this.chunk.Errors = append(this.chunk.Errors, err)
+	this.emit(exp, OpError, len(this.chunk.Errors)-1)
+}
+
+// emit appends an instruction compiled from exp, returning its offset.
+func (this *bytecodeCompiler) emit(exp Expression, opcode Opcode, operands ...int) int {
+	offset := len(this.chunk.Code)
+	this.chunk.Code = append(this.chunk.Code, byte(opcode))
+	this.chunk.nodes = append(this.chunk.nodes, exp)
+	for _, operand := range operands {
+		if operand > maxOperand {
+			this.fail(exp, "operand of "+opcode.String()+" out of range")
+		}
+		this.chunk.Code = binary.BigEndian.AppendUint16(this.chunk.Code, uint16(operand))
+		this.chunk.nodes = append(this.chunk.nodes, nil, nil)
+	}
+	return offset
+}
+
+// patch sets the first operand of the instruction at offset.
+func (this *bytecodeCompiler) patch(offset int, operand int) {
+	if operand > maxOperand {
+		this.fail(this.chunk.nodes[offset], "program too large")
+	}
+	binary.BigEndian.PutUint16(this.chunk.Code[offset+1:], uint16(operand))
+}
+
+func (this *bytecodeCompiler) fail(exp Expression, message string) {
+	if this.err == nil {
+		this.err = diagnosticFor(exp, fmt.Errorf("cannot compile to bytecode: %s", message))
+	}
+}
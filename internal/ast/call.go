@@ -0,0 +1,15 @@
+package ast
+
+type Call struct {
+	Callee    Expression
+	Arguments []Expression
+	Close     Token
+}
+
+func (this *Call) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Call) Span() Span {
+	return this.Callee.Span().Join(this.Close.Span)
+}
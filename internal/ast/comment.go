@@ -0,0 +1,26 @@
+package ast
+
+import "strings"
+
+// Comment is a comment of the source: a line comment, from '#' to the end of
+// the line, or a block comment, between '/*' and '*/'. Text includes the
+// delimiters.
+type Comment struct {
+	Text string
+	Span Span
+}
+
+func (this Comment) IsBlock() bool {
+	return strings.HasPrefix(this.Text, "/*")
+}
+
+// Trivia are the comments surrounding a token, kept by lexers asked to keep
+// comments.
+type Trivia struct {
+	// Comments between the previous token and this one, not on the line of
+	// the previous token
+	Leading []Comment
+	// Comments after the token on the same line, and at the end of the input
+	// for the last token
+	Trailing []Comment
+}
@@ -0,0 +1,721 @@
+package ast
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// CompiledProgram is an AST turned into a tree of Go closures, with every
+// identifier resolved ahead of time to a slot: either a slot in the frame of
+// the function or block that declares it, or a global slot. Global slots hold
+// the top level declarations and every identifier that is not declared in the
+// program, which are bound by the caller of Run.
+//
+// A CompiledProgram evaluates like the Evaluator, without walking the
+// AST or looking names up in maps. It is safe for concurrent use.
+type CompiledProgram struct {
+	code    compiledCode
+	globals []string
+	slots   map[string]int
+	runs    sync.Pool
+}
+
+// compiledCode evaluates a compiled expression in the frame of its innermost
+// scope, nil at the top level.
+type compiledCode func(run *compiledRun, frame *frame) (Value, error)
+
+// frame holds the values declared by one evaluation of a function or block.
+type frame struct {
+	slots  []Value
+	parent *frame
+}
+
+// compiledRun is the state of one run of a CompiledProgram.
+type compiledRun struct {
+	globals []Value
+	// Globals bound to a constant or builtin because the caller left them
+	// unbound. Declaring them at the top level is not a double declaration.
+	fallback  []bool
+	context   context.Context
+	osEnv     bool
+	callDepth int
+}
+
+// CompiledFunction is a function created by a CompiledProgram.
+type CompiledFunction struct {
+	Name       string
+	parameters int
+	size       *int
+	body       compiledCode
+	closure    *frame
+}
+
+func (this *CompiledFunction) Type() ValueType {
+	return FunctionType
+}
+
+func (this *CompiledFunction) String() string {
+	if this.Name == "" {
+		return "<fn>"
+	}
+	return "<fn " + this.Name + ">"
+}
+
+type compilerScope struct {
+	names map[string]int
+	// Slots that may still be unset when the code being compiled runs: the
+	// ones of declarations not compiled yet, and the ones declared by an
+	// assignment
+	unset  map[int]bool
+	size   *int
+	parent *compilerScope
+}
+
+// localSlot is a slot of the frame depth levels up from the current one.
+type localSlot struct {
+	depth int
+	slot  int
+}
+
+// resolver assigns slots to the identifiers of a program as it is compiled.
+type resolver struct {
+	scope   *compilerScope
+	globals []string
+	slots   map[string]int
+	// Names used as globals anywhere in the program, collected by a first
+	// pass. Assigning one of them in a nested scope updates the global when
+	// it is declared, instead of declaring a local.
+	global map[string]bool
+}
+
+type compiler struct {
+	resolver
+}
+
+// newResolver returns a resolver for exp, running the first pass that
+// collects its globals.
+func newResolver(exp Expression) resolver {
+	first := &compiler{resolver{slots: make(map[string]int)}}
+	first.compile(exp)
+
+	global := make(map[string]bool)
+	for _, name := range first.globals {
+		global[name] = true
+	}
+	return resolver{slots: make(map[string]int), global: global}
+}
+
+// Compile turns exp into a CompiledProgram.
+func Compile(exp Expression) *CompiledProgram {
+	compiler := &compiler{newResolver(exp)}
+	code := compiler.compile(exp)
+	return &CompiledProgram{code: code, globals: compiler.globals, slots: compiler.slots}
+}
+
+// Globals returns the names of the global slots, indexed by slot.
+func (this *CompiledProgram) Globals() []string {
+	return this.globals
+}
+
+func (this *CompiledProgram) Slot(name string) (int, bool) {
+	slot, exist := this.slots[name]
+	return slot, exist
+}
+
+// Run evaluates the program with globals as the values of the global slots,
+// nil for the unbound ones, or nil when none is bound. globals is not
+// modified. Unbound globals fall back to the built-in constants and functions
+// and, when osEnv is set, to the environment variables of the process.
+func (this *CompiledProgram) Run(ctx context.Context, globals []Value, osEnv bool) (Value, error) {
+	if globals != nil && len(globals) != len(this.globals) {
+		return nil, fmt.Errorf("expected %d globals, got %d", len(this.globals), len(globals))
+	}
+	run, ok := this.runs.Get().(*compiledRun)
+	if !ok {
+		run = &compiledRun{globals: make([]Value, len(this.globals)), fallback: make([]bool, len(this.globals))}
+	}
+	defer this.runs.Put(run)
+
+	run.context = ctx
+	run.osEnv = osEnv
+	run.callDepth = 0
+	bindGlobals(this.globals, globals, run.globals, run.fallback)
+	return this.code(run, nil)
+}
+
+// bindGlobals copies globals, which may be nil, to values, binding the unbound
+// names to the constant or builtin of the same name and flagging them in
+// fallback.
+func bindGlobals(names []string, globals []Value, values []Value, fallback []bool) {
+	for i, name := range names {
+		var value Value
+		if globals != nil {
+			value = globals[i]
+		}
+		fallback[i] = false
+		if value == nil {
+			if constant, exist := LookupConstant(name); exist {
+				value, fallback[i] = constant, true
+			} else if builtin, exist := LookupBuiltin(name); exist {
+				value, fallback[i] = builtin, true
+			}
+		}
+		values[i] = value
+	}
+}
+
+// unboundGlobal is the value of an identifier whose global slot is unbound.
+func unboundGlobal(identifier *Identifier, osEnv bool) (Value, error) {
+	name := identifier.TokenLiteral.Literal
+	if value, exist := os.LookupEnv(name); exist && osEnv {
+		return environmentValue(value), nil
+	}
+	return nil, diagnosticFor(identifier, fmt.Errorf("undeclared identifier %s", name))
+}
+
+func (this *compiler) compile(exp Expression) compiledCode {
+	switch e := exp.(type) {
+	case *Program:
+		return this.compileStatements(e.Statements)
+	case *Block:
+		return this.compileBlock(e)
+	case *VarDeclaration:
+		return this.compileDeclaration(e, e.Operand.TokenLiteral.Literal, func(run *compiledRun, frame *frame) (Value, error) {
+			return NewInteger(0), nil
+		})
+	case *FunctionDeclaration:
+		name := e.Name.TokenLiteral.Literal
+		var function compiledCode
+		declaration := this.compileDeclaration(e, name, func(run *compiledRun, frame *frame) (Value, error) {
+			return function(run, frame)
+		})
+		function = this.compileFunction(name, e.Parameters, e.Body)
+		return declaration
+	case *Lambda:
+		return this.compileFunction("", e.Parameters, e.Body)
+	case *Assignement:
+		return this.compileAssignement(e)
+	case *Call:
+		return this.compileCall(e)
+	case *BinaryExpression:
+		lhs := this.compile(e.Lhs)
+		rhs := this.compile(e.Rhs)
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			lhsValue, err := lhs(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			rhsValue, err := rhs(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			res, err := BinaryOperation(e.Operator, lhsValue, rhsValue)
+			if err != nil {
+				return nil, diagnosticFor(e, err)
+			}
+			return res, nil
+		}
+	case *LogicalExpression:
+		lhs := this.compile(e.Lhs)
+		rhs := this.compile(e.Rhs)
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			lhsValue, err := lhs(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			lhsBoolean, err := logicalOperand(e, e.Lhs, lhsValue)
+			if err != nil {
+				return nil, err
+			}
+			if shortCircuits(e.Operator, lhsBoolean) {
+				return lhsBoolean, nil
+			}
+			rhsValue, err := rhs(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			return logicalOperand(e, e.Rhs, rhsValue)
+		}
+	case *ConditionalExpression:
+		test := this.compile(e.Condition)
+		then := this.compile(e.Then)
+		otherwise := func(run *compiledRun, frame *frame) (Value, error) {
+			return Nil{}, nil
+		}
+		if e.Else != nil {
+			otherwise = this.compile(e.Else)
+		}
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			value, err := test(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			boolean, err := condition(e.Condition, value)
+			if err != nil {
+				return nil, err
+			}
+			if boolean {
+				return then(run, frame)
+			}
+			return otherwise(run, frame)
+		}
+	case *While:
+		return this.compileWhile(e)
+	case *For:
+		return this.compileFor(e)
+	case *Break:
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			return nil, errBreak
+		}
+	case *Continue:
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			return nil, errContinue
+		}
+	case *UnaryExpression:
+		operand := this.compile(e.Operand)
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			value, err := operand(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			res, err := UnaryOperation(e.Operator, value)
+			if err != nil {
+				return nil, diagnosticFor(e, err)
+			}
+			return res, nil
+		}
+	case *Interpolation:
+		return this.compileInterpolation(e)
+	case *CONSTANT:
+		value, err := constantValue(e.TokenLiteral)
+		if err != nil {
+			err = diagnosticFor(e, err)
+		}
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			return value, err
+		}
+	case *Identifier:
+		return this.compileIdentifier(e)
+	case *BadExpression:
+		err := diagnosticFor(e, fmt.Errorf("cannot evaluate source with syntax errors"))
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			return nil, err
+		}
+	}
+	err := fmt.Errorf("unsupported expression %T", exp)
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		return nil, err
+	}
+}
+
+func (this *compiler) compileStatements(statements []Expression) compiledCode {
+	this.hoist(statements)
+	codes := make([]compiledCode, len(statements))
+	for i, statement := range statements {
+		codes[i] = this.compile(statement)
+	}
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		var value Value = Nil{}
+		for _, code := range codes {
+			var err error
+			value, err = code(run, frame)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return value, nil
+	}
+}
+
+// compileInterpolation compiles the texts of an interpolation once, and its
+// parts to code run for every evaluation.
+func (this *compiler) compileInterpolation(exp *Interpolation) compiledCode {
+	texts, err := exp.Strings()
+	if err != nil {
+		err = diagnosticFor(exp, err)
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			return nil, err
+		}
+	}
+	parts := make([]compiledCode, len(exp.Parts))
+	for i, part := range exp.Parts {
+		parts[i] = this.compile(part)
+	}
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		values := make([]Value, 0, len(texts)+len(parts))
+		for i, text := range texts {
+			values = append(values, String(text))
+			if i < len(parts) {
+				value, err := parts[i](run, frame)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+		}
+		return interpolate(values), nil
+	}
+}
+
+func (this *compiler) compileBlock(block *Block) compiledCode {
+	scope := this.enterScope()
+	statements := this.compileStatements(block.Statements)
+	this.scope = scope.parent
+	return func(run *compiledRun, parent *frame) (Value, error) {
+		return statements(run, &frame{slots: make([]Value, *scope.size), parent: parent})
+	}
+}
+
+func (this *compiler) compileWhile(loop *While) compiledCode {
+	test := this.compile(loop.Condition)
+	body := this.compileBlock(loop.Body)
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		for {
+			if err := run.context.Err(); err != nil {
+				return nil, diagnosticFor(loop, err)
+			}
+			value, err := test(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			boolean, err := condition(loop.Condition, value)
+			if err != nil {
+				return nil, err
+			}
+			if !boolean {
+				return Nil{}, nil
+			}
+			_, err = body(run, frame)
+			if stop, err := loopControl(err); stop {
+				if err != nil {
+					return nil, err
+				}
+				return Nil{}, nil
+			}
+		}
+	}
+}
+
+// compileFor compiles the variable of loop to the only slot of a scope of its
+// own, in which the body is nested.
+func (this *compiler) compileFor(loop *For) compiledCode {
+	start := this.compile(loop.Start)
+	end := this.compile(loop.End)
+	scope := this.enterScope()
+	this.declareLocal(loop.Variable.TokenLiteral.Literal)
+	body := this.compileBlock(loop.Body)
+	this.scope = scope.parent
+	return func(run *compiledRun, parent *frame) (Value, error) {
+		startValue, err := start(run, parent)
+		if err != nil {
+			return nil, err
+		}
+		endValue, err := end(run, parent)
+		if err != nil {
+			return nil, err
+		}
+		if err := rangeBounds(loop, startValue, endValue); err != nil {
+			return nil, err
+		}
+		loopFrame := &frame{slots: make([]Value, *scope.size), parent: parent}
+		for i := startValue; inRange(i, endValue); i = rangeNext(i) {
+			if err := run.context.Err(); err != nil {
+				return nil, diagnosticFor(loop, err)
+			}
+			loopFrame.slots[0] = i
+			_, err := body(run, loopFrame)
+			if stop, err := loopControl(err); stop {
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		return Nil{}, nil
+	}
+}
+
+// compileFunction compiles the parameters and body of a function in a scope
+// of their own, nested in the current one.
+func (this *compiler) compileFunction(name string, parameters []Identifier, body Expression) compiledCode {
+	scope := this.enterScope()
+	for _, parameter := range parameters {
+		this.declareLocal(parameter.TokenLiteral.Literal)
+	}
+	var code compiledCode
+	if block, ok := body.(*Block); ok {
+		code = this.compileStatements(block.Statements)
+	} else {
+		code = this.compile(body)
+	}
+	this.scope = scope.parent
+
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		return &CompiledFunction{Name: name, parameters: len(parameters), size: scope.size, body: code, closure: frame}, nil
+	}
+}
+
+// compileDeclaration binds name in the current scope to the value of value.
+// Declaring a name twice in the same scope fails when the second declaration
+// is evaluated, as it does with the Evaluator.
+func (this *compiler) compileDeclaration(exp Expression, name string, value compiledCode) compiledCode {
+	doubleDeclaration := diagnosticFor(exp, fmt.Errorf("double declaration of %s", name))
+	if this.scope == nil {
+		slot := this.globalSlot(name)
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			if run.globals[slot] != nil && !run.fallback[slot] {
+				return nil, doubleDeclaration
+			}
+			res, err := value(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			run.globals[slot] = res
+			run.fallback[slot] = false
+			return res, nil
+		}
+	}
+	slot, exist := this.scope.names[name]
+	if !exist {
+		slot = this.declareLocal(name)
+	}
+	// The code compiled after a declaration runs once it is evaluated
+	delete(this.scope.unset, slot)
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		if frame.slots[slot] != nil {
+			return nil, doubleDeclaration
+		}
+		res, err := value(run, frame)
+		if err != nil {
+			return nil, err
+		}
+		frame.slots[slot] = res
+		return res, nil
+	}
+}
+
+// compileAssignement compiles an assignment updating the closest binding of
+// its identifier, or declaring it in the current scope when there is none, as
+// Environment.Assign does.
+func (this *compiler) compileAssignement(assignement *Assignement) compiledCode {
+	name := assignement.LHS.TokenLiteral.Literal
+	rhs := this.compile(assignement.Assigned())
+
+	if this.scope == nil {
+		slot := this.globalSlot(name)
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			value, err := rhs(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			run.globals[slot] = value
+			run.fallback[slot] = false
+			return value, nil
+		}
+	}
+
+	slots, bound := this.resolveSlots(name)
+	if bound && len(slots) == 1 {
+		depth, slot := slots[0].depth, slots[0].slot
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			value, err := rhs(run, frame)
+			if err != nil {
+				return nil, err
+			}
+			frame.up(depth).slots[slot] = value
+			return value, nil
+		}
+	}
+	global, own := -1, -1
+	if !bound {
+		if this.global[name] {
+			global = this.globalSlot(name)
+		}
+		var exist bool
+		if own, exist = this.scope.names[name]; !exist {
+			own = this.declareLocal(name)
+			this.scope.unset[own] = true
+		}
+	}
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		value, err := rhs(run, frame)
+		if err != nil {
+			return nil, err
+		}
+		for _, local := range slots {
+			if target := frame.up(local.depth); target.slots[local.slot] != nil {
+				target.slots[local.slot] = value
+				return value, nil
+			}
+		}
+		if global >= 0 && run.globals[global] != nil && !run.fallback[global] {
+			run.globals[global] = value
+			return value, nil
+		}
+		frame.slots[own] = value
+		return value, nil
+	}
+}
+
+// compileIdentifier compiles a read of the closest binding of identifier,
+// skipping the slots still unset as Environment.Lookup skips the scopes in
+// which it is not defined yet.
+func (this *compiler) compileIdentifier(identifier *Identifier) compiledCode {
+	name := identifier.TokenLiteral.Literal
+	slots, bound := this.resolveSlots(name)
+	if bound && len(slots) == 1 {
+		depth, slot := slots[0].depth, slots[0].slot
+		if depth == 0 {
+			return func(run *compiledRun, frame *frame) (Value, error) {
+				return frame.slots[slot], nil
+			}
+		}
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			return frame.up(depth).slots[slot], nil
+		}
+	}
+	if bound {
+		return func(run *compiledRun, frame *frame) (Value, error) {
+			return lookup(slots, frame), nil
+		}
+	}
+
+	slot := this.globalSlot(name)
+	return func(run *compiledRun, frame *frame) (Value, error) {
+		if value := lookup(slots, frame); value != nil {
+			return value, nil
+		}
+		if value := run.globals[slot]; value != nil {
+			return value, nil
+		}
+		return unboundGlobal(identifier, run.osEnv)
+	}
+}
+
+// lookup returns the value of the first of slots that is set, nil when none
+// is.
+func lookup(slots []localSlot, frame *frame) Value {
+	for _, local := range slots {
+		if value := frame.up(local.depth).slots[local.slot]; value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+func (this *compiler) compileCall(call *Call) compiledCode {
+	callee := this.compile(call.Callee)
+	arguments := make([]compiledCode, len(call.Arguments))
+	for i, argument := range call.Arguments {
+		arguments[i] = this.compile(argument)
+	}
+
+	return func(run *compiledRun, current *frame) (Value, error) {
+		calleeValue, err := callee(run, current)
+		if err != nil {
+			return nil, err
+		}
+		if err := run.context.Err(); err != nil {
+			return nil, diagnosticFor(call, err)
+		}
+
+		switch function := calleeValue.(type) {
+		case *Builtin:
+			if err := function.CheckArity(len(arguments)); err != nil {
+				return nil, diagnosticFor(call, err)
+			}
+			args := make([]Value, len(arguments))
+			for i, argument := range arguments {
+				if args[i], err = argument(run, current); err != nil {
+					return nil, err
+				}
+			}
+			res, err := function.Fn(run.context, args)
+			if err != nil {
+				return nil, diagnosticFor(call, err)
+			}
+			return res, nil
+		case *CompiledFunction:
+			if len(arguments) != function.parameters {
+				return nil, diagnosticFor(call, fmt.Errorf("%s expects %d arguments, got %d", function, function.parameters, len(arguments)))
+			}
+			callFrame := &frame{slots: make([]Value, *function.size), parent: function.closure}
+			for i, argument := range arguments {
+				if callFrame.slots[i], err = argument(run, current); err != nil {
+					return nil, err
+				}
+			}
+			if run.callDepth >= maxCallDepth {
+				return nil, diagnosticFor(call, fmt.Errorf("maximum call depth of %d exceeded", maxCallDepth))
+			}
+			run.callDepth++
+			defer func() { run.callDepth-- }()
+			return function.body(run, callFrame)
+		}
+		return nil, diagnosticFor(call.Callee, fmt.Errorf("cannot call %s", calleeValue.Type()))
+	}
+}
+
+func (this *resolver) enterScope() *compilerScope {
+	this.scope = &compilerScope{names: make(map[string]int), unset: make(map[int]bool), size: new(int), parent: this.scope}
+	return this.scope
+}
+
+func (this *resolver) declareLocal(name string) int {
+	slot := *this.scope.size
+	this.scope.names[name] = slot
+	*this.scope.size++
+	return slot
+}
+
+// hoist declares the names declared by statements in the current scope, unset
+// until their declaration is evaluated, so that the functions declared before
+// them can refer to them.
+func (this *resolver) hoist(statements []Expression) {
+	if this.scope == nil {
+		return
+	}
+	for _, statement := range statements {
+		var name string
+		switch e := statement.(type) {
+		case *VarDeclaration:
+			name = e.Operand.TokenLiteral.Literal
+		case *FunctionDeclaration:
+			name = e.Name.TokenLiteral.Literal
+		default:
+			continue
+		}
+		if _, exist := this.scope.names[name]; !exist {
+			this.scope.unset[this.declareLocal(name)] = true
+		}
+	}
+}
+
+// resolveSlots finds the slots of name in the enclosing scopes, innermost
+// first, up to the first one that is always set. bound is false when all of
+// them may be unset, name then falling back to the global of the same name.
+func (this *resolver) resolveSlots(name string) (slots []localSlot, bound bool) {
+	depth := 0
+	for scope := this.scope; scope != nil; scope = scope.parent {
+		if slot, exist := scope.names[name]; exist {
+			slots = append(slots, localSlot{depth, slot})
+			if !scope.unset[slot] {
+				return slots, true
+			}
+		}
+		depth++
+	}
+	return slots, false
+}
+
+func (this *resolver) globalSlot(name string) int {
+	if slot, exist := this.slots[name]; exist {
+		return slot
+	}
+	slot := len(this.globals)
+	this.slots[name] = slot
+	this.globals = append(this.globals, name)
+	return slot
+}
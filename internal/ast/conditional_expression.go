@@ -0,0 +1,37 @@
+package ast
+
+// ConditionalExpression evaluates to Then when Condition is true and to Else
+// when it is false, evaluating only that branch. It is written either
+// `if condition then a else b` or, with blocks as branches,
+// `if condition { a } else { b }`, Keyword being the `if` token, or
+// `condition ? a : b`, Keyword being the `?` token. Else is nil for an if
+// with blocks and no else, which evaluates to nil when Condition is false.
+//
+// Conditions are strict: they must evaluate to a bool, anything else is a
+// type error rather than being truthy or falsy.
+type ConditionalExpression struct {
+	Keyword   Token
+	Condition Expression
+	Then      Expression
+	Else      Expression
+}
+
+func (this *ConditionalExpression) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *ConditionalExpression) Span() Span {
+	last := this.Else
+	if last == nil {
+		last = this.Then
+	}
+	if this.Keyword.Token == IF {
+		return this.Keyword.Span.Join(last.Span())
+	}
+	return this.Condition.Span().Join(last.Span())
+}
+
+// IsTernary reports whether the expression is written with `?` and `:`.
+func (this *ConditionalExpression) IsTernary() bool {
+	return this.Keyword.Token == QUESTION
+}
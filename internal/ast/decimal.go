@@ -0,0 +1,311 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Rounding is how a Decimal loses the digits it has no room for.
+type Rounding int
+
+const (
+	// To the nearest, ties to the even neighbour: 2.345 is 2.34, 2.355 is 2.36
+	RoundHalfEven Rounding = iota
+	// To the nearest, ties away from zero: 2.345 is 2.35, -2.345 is -2.35
+	RoundHalfUp
+	// Towards zero: 2.349 is 2.34, -2.349 is -2.34
+	RoundDown
+)
+
+var roundings = [...]string{
+	RoundHalfEven: "half-even",
+	RoundHalfUp:   "half-up",
+	RoundDown:     "down",
+}
+
+func (this Rounding) String() string {
+	if this >= 0 && int(this) < len(roundings) {
+		return roundings[this]
+	}
+	return fmt.Sprintf("Rounding(%d)", int(this))
+}
+
+// ParseRounding returns the rounding called name.
+func ParseRounding(name string) (Rounding, error) {
+	for rounding, roundingName := range roundings {
+		if roundingName == name {
+			return Rounding(rounding), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rounding %q, expected one of %s", name, strings.Join(roundings[:], ", "))
+}
+
+// DecimalMode makes the Evaluator compute with exact decimals instead of
+// binary floats: number literals evaluate to Decimal values, on which
+// addition, subtraction, multiplication and comparisons are exact, so that
+// 0.1 + 0.2 == 0.3.
+//
+// The quotient of a division, and the results the built-in functions compute
+// with floats, such as sqrt, are rounded, with Rounding, to Scale digits
+// after the decimal point. The built-in constants are converted to decimals
+// from their shortest representation.
+//
+// Only the Evaluator has the mode: compiled programs and bytecode compute
+// with floats, and so does Optimize when folding constants. The eval package
+// does not expose it.
+type DecimalMode struct {
+	// Digits after the decimal point kept by division and by the built-in
+	// functions computing with floats, at least 0
+	Scale    int
+	Rounding Rounding
+}
+
+// Decimal is an exact base-10 number, the number type of the DecimalMode.
+// Decimals are made by ParseDecimal: the zero Decimal is not valid.
+type Decimal struct {
+	// The value is unscaled * 10^-scale, scale being at least 0
+	unscaled *big.Int
+	scale    int
+}
+
+var decimalOne = Decimal{unscaled: big.NewInt(1)}
+
+// maxDecimalExponent bounds the exponent of the literals ParseDecimal
+// converts, as their digits are all kept.
+const maxDecimalExponent = 1 << 12
+
+// ParseDecimal converts a number literal, with an optional sign, fraction and
+// exponent, to the exact Decimal it spells.
+func ParseDecimal(text string) (Decimal, error) {
+	invalid := fmt.Errorf("couldn't convert %s to number", text)
+	mantissa, exponent := text, 0
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		var err error
+		if exponent, err = strconv.Atoi(text[i+1:]); err != nil || exponent > maxDecimalExponent || exponent < -maxDecimalExponent {
+			return Decimal{}, invalid
+		}
+		mantissa = text[:i]
+	}
+	integer, fraction, _ := strings.Cut(mantissa, ".")
+	digits := integer + fraction
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	if digits == "" || strings.Trim(digits, "0123456789") != "" || strings.ContainsAny(fraction, "+-") {
+		return Decimal{}, invalid
+	}
+	unscaled, ok := new(big.Int).SetString(integer+fraction, 10)
+	if !ok {
+		return Decimal{}, invalid
+	}
+	return Decimal{unscaled: unscaled, scale: len(fraction)}.shift(exponent), nil
+}
+
+// literalDecimal returns the exact value of an integer or float literal.
+func literalDecimal(token Token) (Decimal, error) {
+	if token.Token == INTEGER_LITERAL {
+		integer, err := parseIntegerLiteral(token.Literal)
+		return Decimal{unscaled: integer.value}, err
+	}
+	return ParseDecimal(strings.ReplaceAll(token.Literal, "_", ""))
+}
+
+// decimalFromNumber converts number to a Decimal from its shortest
+// representation. Infinities and NaN have none.
+func decimalFromNumber(number Number) (Decimal, error) {
+	if math.IsInf(float64(number), 0) || math.IsNaN(float64(number)) {
+		return Decimal{}, fmt.Errorf("cannot convert %s to a decimal", number)
+	}
+	return ParseDecimal(strconv.FormatFloat(float64(number), 'g', -1, 64))
+}
+
+// inexact converts value, a number a builtin computed, to a Decimal rounded
+// as a quotient is when it was computed with floats.
+func (this *DecimalMode) inexact(value Value) (Decimal, bool) {
+	decimal, ok := toDecimal(value)
+	if !ok {
+		return Decimal{}, false
+	}
+	return decimal.round(this.Scale, this.Rounding), true
+}
+
+// toDecimal returns value as a Decimal when it is a number that has one.
+func toDecimal(value Value) (Decimal, bool) {
+	switch v := value.(type) {
+	case Decimal:
+		return v, true
+	case Integer:
+		return Decimal{unscaled: v.value}, true
+	case Number, Rational:
+		decimal, err := decimalFromNumber(floatValue(v).(Number))
+		return decimal, err == nil
+	}
+	return Decimal{}, false
+}
+
+func (this Decimal) Type() ValueType {
+	return NumberType
+}
+
+// String returns the decimal in plain notation, without trailing zeros after
+// the decimal point.
+func (this Decimal) String() string {
+	digits := new(big.Int).Abs(this.unscaled).String()
+	if this.scale > 0 {
+		if len(digits) <= this.scale {
+			digits = strings.Repeat("0", this.scale-len(digits)+1) + digits
+		}
+		point := len(digits) - this.scale
+		digits = strings.TrimRight(strings.TrimRight(digits[:point]+"."+digits[point:], "0"), ".")
+	}
+	if this.unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// Number returns the float closest to the decimal.
+func (this Decimal) Number() Number {
+	number, _ := strconv.ParseFloat(this.String(), 64)
+	return Number(number)
+}
+
+// Cmp returns -1, 0 or 1 when the decimal is less than, equal to or greater
+// than other.
+func (this Decimal) Cmp(other Decimal) int {
+	lhs, rhs := align(this, other)
+	return lhs.Cmp(rhs)
+}
+
+// shift multiplies the decimal by 10^exponent.
+func (this Decimal) shift(exponent int) Decimal {
+	if exponent <= this.scale {
+		return Decimal{unscaled: this.unscaled, scale: this.scale - exponent}
+	}
+	unscaled := new(big.Int).Mul(this.unscaled, pow10(exponent-this.scale))
+	return Decimal{unscaled: unscaled}
+}
+
+func (this Decimal) add(other Decimal) Decimal {
+	lhs, rhs := align(this, other)
+	return Decimal{unscaled: lhs.Add(lhs, rhs), scale: max(this.scale, other.scale)}
+}
+
+func (this Decimal) sub(other Decimal) Decimal {
+	return this.add(other.neg())
+}
+
+func (this Decimal) mul(other Decimal) Decimal {
+	return Decimal{unscaled: new(big.Int).Mul(this.unscaled, other.unscaled), scale: this.scale + other.scale}
+}
+
+func (this Decimal) neg() Decimal {
+	return Decimal{unscaled: new(big.Int).Neg(this.unscaled), scale: this.scale}
+}
+
+func (this Decimal) abs() Decimal {
+	if this.unscaled.Sign() < 0 {
+		return this.neg()
+	}
+	return this
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+// quo returns the quotient rounded to scale digits after the decimal point.
+func (this Decimal) quo(other Decimal, scale int, rounding Rounding) (Decimal, error) {
+	if other.unscaled.Sign() == 0 {
+		return Decimal{}, errDivisionByZero
+	}
+	// this / other = numerator / denominator * 10^-scale
+	numerator := new(big.Int).Set(this.unscaled)
+	denominator := new(big.Int).Set(other.unscaled)
+	if exponent := scale + other.scale - this.scale; exponent >= 0 {
+		numerator.Mul(numerator, pow10(exponent))
+	} else {
+		denominator.Mul(denominator, pow10(-exponent))
+	}
+	quotient, remainder := numerator.QuoRem(numerator, denominator, new(big.Int))
+	if remainder.Sign() != 0 && rounding != RoundDown {
+		// The quotient was truncated: compare what was dropped with a half
+		twice := remainder.Lsh(remainder.Abs(remainder), 1)
+		half := twice.Cmp(denominator.Abs(denominator))
+		if half > 0 || half == 0 && (rounding == RoundHalfUp || quotient.Bit(0) == 1) {
+			sign := this.unscaled.Sign() * other.unscaled.Sign()
+			quotient.Add(quotient, big.NewInt(int64(sign)))
+		}
+	}
+	return Decimal{unscaled: quotient, scale: scale}, nil
+}
+
+// floorQuo returns the greatest whole number less than or equal to the
+// quotient.
+func (this Decimal) floorQuo(other Decimal) (Decimal, error) {
+	quotient, err := this.quo(other, 0, RoundDown)
+	if err != nil {
+		return Decimal{}, err
+	}
+	if quotient.mul(other).Cmp(this) != 0 && this.unscaled.Sign()*other.unscaled.Sign() < 0 {
+		quotient = quotient.sub(decimalOne)
+	}
+	return quotient, nil
+}
+
+// round returns the decimal rounded to scale digits after the decimal point,
+// unchanged when it has no more.
+func (this Decimal) round(scale int, rounding Rounding) Decimal {
+	if this.scale <= scale {
+		return this
+	}
+	res, _ := this.quo(decimalOne, scale, rounding)
+	return res
+}
+
+// floor returns the greatest whole number less than or equal to the decimal.
+func (this Decimal) floor() Decimal {
+	res := this.round(0, RoundDown)
+	if res.Cmp(this) > 0 {
+		res = res.sub(decimalOne)
+	}
+	return res
+}
+
+// ceil returns the least whole number greater than or equal to the decimal.
+func (this Decimal) ceil() Decimal {
+	res := this.round(0, RoundDown)
+	if res.Cmp(this) < 0 {
+		res = res.add(decimalOne)
+	}
+	return res
+}
+
+// integer returns the decimal as an int when it is a whole number that fits.
+func (this Decimal) integer() (int, bool) {
+	if this.round(0, RoundDown).Cmp(this) != 0 {
+		return 0, false
+	}
+	whole := this.round(0, RoundDown).unscaled
+	if !whole.IsInt64() || whole.Int64() > math.MaxInt32 || whole.Int64() < math.MinInt32 {
+		return 0, false
+	}
+	return int(whole.Int64()), true
+}
+
+// align returns the unscaled values of lhs and rhs brought to the same scale.
+func align(lhs Decimal, rhs Decimal) (*big.Int, *big.Int) {
+	switch {
+	case lhs.scale < rhs.scale:
+		return new(big.Int).Mul(lhs.unscaled, pow10(rhs.scale-lhs.scale)), new(big.Int).Set(rhs.unscaled)
+	case lhs.scale > rhs.scale:
+		return new(big.Int).Set(lhs.unscaled), new(big.Int).Mul(rhs.unscaled, pow10(lhs.scale-rhs.scale))
+	}
+	return new(big.Int).Set(lhs.unscaled), new(big.Int).Set(rhs.unscaled)
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
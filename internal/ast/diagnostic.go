@@ -0,0 +1,80 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Diagnostic is an error tied to the part of the source that caused it.
+type Diagnostic struct {
+	Span    Span
+	Message string
+}
+
+func (this *Diagnostic) Error() string {
+	if this.Span.Start.Line == 0 {
+		return this.Message
+	}
+	return fmt.Sprintf("%s: %s", this.Span, this.Message)
+}
+
+// Diagnostics collects every error found in one pass over the source.
+type Diagnostics []*Diagnostic
+
+func (this Diagnostics) Error() string {
+	messages := make([]string, 0, len(this))
+	for _, diagnostic := range this {
+		messages = append(messages, diagnostic.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Render prints the source line of the diagnostic with a caret under the
+// offending part, in the style of rustc or clang.
+func (this *Diagnostic) Render(source string) string {
+	builder := strings.Builder{}
+	builder.WriteString(this.Message + "\n")
+
+	start := this.Span.Start
+	lines := strings.Split(source, "\n")
+	if start.Line < 1 || start.Line > len(lines) {
+		return builder.String()
+	}
+	line := strings.TrimSuffix(lines[start.Line-1], "\r")
+	column := min(max(start.Column-1, 0), len(line))
+
+	// The underline stops at the end of the line for spans that cover more
+	// than one line.
+	end := len(line)
+	if this.Span.End.Line == start.Line {
+		end = min(max(this.Span.End.Column-1, column), len(line))
+	}
+	width := max(utf8.RuneCountInString(line[column:end]), 1)
+
+	// Keep the tabs of the source line so the caret lines up with it.
+	padding := strings.Builder{}
+	for _, char := range line[:column] {
+		if char == '\t' {
+			padding.WriteRune('\t')
+		} else {
+			padding.WriteRune(' ')
+		}
+	}
+
+	gutter := strings.Repeat(" ", len(fmt.Sprint(start.Line)))
+	builder.WriteString(fmt.Sprintf("%s--> %s\n", gutter, start))
+	builder.WriteString(fmt.Sprintf("%s |\n", gutter))
+	builder.WriteString(fmt.Sprintf("%d | %s\n", start.Line, line))
+	builder.WriteString(fmt.Sprintf("%s | %s%s\n", gutter, padding.String(), strings.Repeat("^", width)))
+	return builder.String()
+}
+
+// diagnosticFor wraps err with the span of exp, unless it already points to a
+// more precise location.
+func diagnosticFor(exp Expression, err error) error {
+	if _, ok := err.(*Diagnostic); ok {
+		return err
+	}
+	return &Diagnostic{Span: exp.Span(), Message: err.Error()}
+}
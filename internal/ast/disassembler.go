@@ -0,0 +1,84 @@
+package ast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Disassemble returns a listing of the instructions of the program, followed
+// by those of the functions it declares.
+func (this *Bytecode) Disassemble() string {
+	var builder strings.Builder
+	disassembleChunk(&builder, this.Chunk.Name, this.Chunk, this.globals)
+	return builder.String()
+}
+
+// disassembleChunk writes one line per instruction: its offset, the position
+// of the expression it comes from, its opcode and operands, and what the
+// operands refer to.
+func disassembleChunk(builder *strings.Builder, name string, chunk *Chunk, globals []string) {
+	fmt.Fprintf(builder, "== %s ==\n", name)
+	for offset := 0; offset < len(chunk.Code); {
+		opcode := Opcode(chunk.Code[offset])
+		operands := make([]int, opcode.Operands())
+		for i := range operands {
+			operands[i] = int(binary.BigEndian.Uint16(chunk.Code[offset+1+2*i:]))
+		}
+
+		position := ""
+		if node := chunk.nodes[offset]; node != nil && node.Span().Start.Line > 0 {
+			position = node.Span().Start.String()
+		}
+		line := fmt.Sprintf("%04d %6s  %-14s", offset, position, opcode)
+		for _, operand := range operands {
+			line += fmt.Sprintf(" %4d", operand)
+		}
+		if comment := operandComment(chunk, offset, opcode, operands, globals); comment != "" {
+			line = fmt.Sprintf("%-38s ; %s", line, comment)
+		}
+		builder.WriteString(strings.TrimRight(line, " ") + "\n")
+		offset += 1 + 2*len(operands)
+	}
+	for _, function := range chunk.Functions {
+		builder.WriteString("\n")
+		disassembleChunk(builder, function.String(), &function.Chunk, globals)
+	}
+}
+
+func operandComment(chunk *Chunk, offset int, opcode Opcode, operands []int, globals []string) string {
+	switch opcode {
+	case OpConstant:
+		value := chunk.Constants[operands[0]]
+		if str, ok := value.(String); ok {
+			return fmt.Sprintf("%q", string(str))
+		}
+		return value.String()
+	case OpGetGlobal, OpSetGlobal, OpDefineGlobal, OpGetDeclared:
+		return globals[operands[0]]
+	case OpGetLocal, OpSetLocal, OpDefineLocal:
+		return localName(chunk.nodes[offset])
+	case OpClosure:
+		return chunk.Functions[operands[0]].String()
+	case OpError:
+		return chunk.Errors[operands[0]].Error()
+	case OpShortCircuit, OpBranch, OpJump, OpJumpIfSet, OpLoop, OpForIterate, OpBreak, OpContinue:
+		return fmt.Sprintf("-> %04d", operands[0])
+	}
+	return ""
+}
+
+// localName returns the name of the local accessed by exp.
+func localName(exp Expression) string {
+	switch e := exp.(type) {
+	case *Identifier:
+		return e.TokenLiteral.Literal
+	case *Assignement:
+		return e.LHS.TokenLiteral.Literal
+	case *VarDeclaration:
+		return e.Operand.TokenLiteral.Literal
+	case *FunctionDeclaration:
+		return e.Name.TokenLiteral.Literal
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package ast
+
+// Environment maps names to values for one scope. Lookups and assignments
+// fall back to the enclosing scopes.
+type Environment struct {
+	values map[string]Value
+	parent *Environment
+}
+
+func NewEnvironment(parent *Environment) *Environment {
+	return &Environment{values: make(map[string]Value), parent: parent}
+}
+
+// Define binds name in this scope, shadowing any binding of the enclosing
+// scopes.
+func (this *Environment) Define(name string, value Value) {
+	this.values[name] = value
+}
+
+// IsDefined reports whether name is bound in this scope, ignoring the
+// enclosing ones.
+func (this *Environment) IsDefined(name string) bool {
+	_, exist := this.values[name]
+	return exist
+}
+
+func (this *Environment) Lookup(name string) (Value, bool) {
+	for env := this; env != nil; env = env.parent {
+		if value, exist := env.values[name]; exist {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// Assign updates the closest binding of name. It returns false when name is
+// not bound in any scope.
+func (this *Environment) Assign(name string, value Value) bool {
+	for env := this; env != nil; env = env.parent {
+		if _, exist := env.values[name]; exist {
+			env.values[name] = value
+			return true
+		}
+	}
+	return false
+}
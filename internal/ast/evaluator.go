@@ -1,94 +1,428 @@
 package ast
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
+// Calls nested deeper than this fail instead of exhausting the Go stack.
+const maxCallDepth = 1000
+
 type Evaluator struct {
-	identifiers map[string]string
+	// DisableOSEnv stops undeclared identifiers from being looked up in the
+	// environment variables of the process.
+	DisableOSEnv bool
+	// Decimal, when set, evaluates numbers as exact decimals rather than
+	// binary floats.
+	Decimal *DecimalMode
+
+	environment *Environment
+	callDepth   int
+	context     context.Context
 }
 
-func (this *Evaluator) visit(exp Expression) (string, error) {
+func (this *Evaluator) Visit(exp Expression) (Value, error) {
 	switch e := exp.(type) {
+	case *Program:
+		return this.evaluateStatements(e.Statements)
+	case *Block:
+		return this.evaluateBlock(e, NewEnvironment(this.environment))
 	case *VarDeclaration:
 		operand := e.Operand.TokenLiteral.Literal
-		if _, exist := this.identifiers[operand]; exist {
-			return "", fmt.Errorf("double declaration of %s", operand)
+		if this.environment.IsDefined(operand) {
+			return nil, diagnosticFor(e, fmt.Errorf("double declaration of %s", operand))
 		}
-		this.identifiers[operand] = "0"
-		return "0", nil
+		this.environment.Define(operand, NewInteger(0))
+		return NewInteger(0), nil
 	case *Assignement:
 		operand := e.LHS.TokenLiteral.Literal
-		rhs, err := e.Rhs.accept(this)
+		rhs, err := e.Assigned().Accept(this)
 		if err != nil {
-			return "", fmt.Errorf("error for assignement: %v", err)
+			return nil, err
+		}
+		// Assigning an undeclared identifier declares it in the current scope
+		if !this.environment.Assign(operand, rhs) {
+			this.environment.Define(operand, rhs)
 		}
-		this.identifiers[operand] = rhs
 		return rhs, nil
+	case *FunctionDeclaration:
+		name := e.Name.TokenLiteral.Literal
+		if this.environment.IsDefined(name) {
+			return nil, diagnosticFor(e, fmt.Errorf("double declaration of %s", name))
+		}
+		function := &Function{Name: name, Parameters: e.Parameters, Body: e.Body, Closure: this.environment}
+		this.environment.Define(name, function)
+		return function, nil
+	case *Lambda:
+		return &Function{Parameters: e.Parameters, Body: e.Body, Closure: this.environment}, nil
+	case *Call:
+		return this.evaluateCall(e)
 	case *BinaryExpression:
-		lhs, err := e.Lhs.accept(this)
+		lhs, err := e.Lhs.Accept(this)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		rhs, err := e.Rhs.accept(this)
+		rhs, err := e.Rhs.Accept(this)
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+		var res Value
+		if this.Decimal != nil {
+			res, err = this.Decimal.BinaryOperation(e.Operator, lhs, rhs)
+		} else {
+			res, err = BinaryOperation(e.Operator, lhs, rhs)
 		}
-		res, err := this.evaluateBinaryExpression(lhs, e.Operator, rhs)
 		if err != nil {
-			return "", err
+			return nil, diagnosticFor(e, err)
 		}
 		return res, nil
+	case *LogicalExpression:
+		return this.evaluateLogicalExpression(e)
+	case *ConditionalExpression:
+		value, err := e.Condition.Accept(this)
+		if err != nil {
+			return nil, err
+		}
+		boolean, err := condition(e.Condition, value)
+		if err != nil {
+			return nil, err
+		}
+		if boolean {
+			return e.Then.Accept(this)
+		}
+		if e.Else == nil {
+			return Nil{}, nil
+		}
+		return e.Else.Accept(this)
+	case *While:
+		return this.evaluateWhile(e)
+	case *For:
+		return this.evaluateFor(e)
+	case *Break:
+		return nil, errBreak
+	case *Continue:
+		return nil, errContinue
 	case *UnaryExpression:
-		res, err := e.Operand.accept(this)
+		operand, err := e.Operand.Accept(this)
+		if err != nil {
+			return nil, err
+		}
+		res, err := UnaryOperation(e.Operator, operand)
 		if err != nil {
-			return "", err
+			return nil, diagnosticFor(e, err)
 		}
-		return "-" + res, nil
+		return res, nil
+	case *Interpolation:
+		return this.evaluateInterpolation(e)
 	case *CONSTANT:
-		return e.TokenLiteral.Literal, nil
+		var res Value
+		var err error
+		if this.Decimal != nil && isNumberLiteral(e.TokenLiteral) {
+			res, err = literalDecimal(e.TokenLiteral)
+		} else {
+			res, err = constantValue(e.TokenLiteral)
+		}
+		if err != nil {
+			return nil, diagnosticFor(e, err)
+		}
+		return res, nil
+	case *BadExpression:
+		return nil, diagnosticFor(e, fmt.Errorf("cannot evaluate source with syntax errors"))
 	case *Identifier:
 		operand := e.TokenLiteral.Literal
 
-		if _, exist := this.identifiers[operand]; exist {
-			return this.identifiers[operand] , nil
+		if value, exist := this.environment.Lookup(operand); exist {
+			return value, nil
+		}
+		if value, exist := LookupConstant(operand); exist {
+			return value, nil
+		}
+		if builtin, exist := LookupBuiltin(operand); exist {
+			return builtin, nil
+		}
+		if value, exist := os.LookupEnv(operand); exist && !this.DisableOSEnv {
+			return environmentValue(value), nil
+		}
+		return nil, diagnosticFor(e, fmt.Errorf("undeclared identifier %s", operand))
+	}
+	return nil, fmt.Errorf("unsupported expression %T", exp)
+}
+
+func (this *Evaluator) evaluateStatements(statements []Expression) (Value, error) {
+	var value Value = Nil{}
+	for _, statement := range statements {
+		var err error
+		value, err = statement.Accept(this)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// evaluateBlock evaluates the statements of block in environment, restoring
+// the current environment afterwards.
+func (this *Evaluator) evaluateBlock(block *Block, environment *Environment) (Value, error) {
+	previous := this.environment
+	this.environment = environment
+	defer func() { this.environment = previous }()
+	return this.evaluateStatements(block.Statements)
+}
+
+func (this *Evaluator) evaluateWhile(loop *While) (Value, error) {
+	for {
+		if err := this.context.Err(); err != nil {
+			return nil, diagnosticFor(loop, err)
+		}
+		value, err := loop.Condition.Accept(this)
+		if err != nil {
+			return nil, err
+		}
+		boolean, err := condition(loop.Condition, value)
+		if err != nil {
+			return nil, err
+		}
+		if !boolean {
+			return Nil{}, nil
+		}
+		_, err = this.evaluateBlock(loop.Body, NewEnvironment(this.environment))
+		if stop, err := loopControl(err); stop {
+			if err != nil {
+				return nil, err
+			}
+			return Nil{}, nil
+		}
+	}
+}
+
+// evaluateFor binds the variable of loop in an environment of its own, in
+// which the body is evaluated.
+func (this *Evaluator) evaluateFor(loop *For) (Value, error) {
+	start, err := loop.Start.Accept(this)
+	if err != nil {
+		return nil, err
+	}
+	end, err := loop.End.Accept(this)
+	if err != nil {
+		return nil, err
+	}
+	next, err := this.rangeValues(loop, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	environment := NewEnvironment(this.environment)
+	previous := this.environment
+	this.environment = environment
+	defer func() { this.environment = previous }()
+	for i, ok := next(); ok; i, ok = next() {
+		if err := this.context.Err(); err != nil {
+			return nil, diagnosticFor(loop, err)
+		}
+		environment.Define(loop.Variable.TokenLiteral.Literal, i)
+		_, err := this.evaluateBlock(loop.Body, NewEnvironment(environment))
+		if stop, err := loopControl(err); stop {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return Nil{}, nil
+}
+
+// rangeValues returns a function returning the successive values of the
+// variable of loop, from start up to end, and false once there are no more.
+// They are decimals in the DecimalMode.
+func (this *Evaluator) rangeValues(loop *For, start Value, end Value) (func() (Value, bool), error) {
+	if this.Decimal != nil {
+		from, fromOk := toDecimal(start)
+		to, toOk := toDecimal(end)
+		if fromOk && toOk {
+			return func() (Value, bool) {
+				if from.Cmp(to) >= 0 {
+					return nil, false
+				}
+				value := from
+				from = from.add(decimalOne)
+				return value, true
+			}, nil
+		}
+	}
+	if err := rangeBounds(loop, start, end); err != nil {
+		return nil, err
+	}
+	return func() (Value, bool) {
+		if !inRange(start, end) {
+			return nil, false
+		}
+		value := start
+		start = rangeNext(start)
+		return value, true
+	}, nil
+}
+
+func (this *Evaluator) evaluateCall(call *Call) (Value, error) {
+	callee, err := call.Callee.Accept(this)
+	if err != nil {
+		return nil, err
+	}
+	if err := this.context.Err(); err != nil {
+		return nil, diagnosticFor(call, err)
+	}
+	if builtin, ok := callee.(*Builtin); ok {
+		return this.callBuiltin(call, builtin)
+	}
+	function, ok := callee.(*Function)
+	if !ok {
+		return nil, diagnosticFor(call.Callee, fmt.Errorf("cannot call %s", callee.Type()))
+	}
+	if len(call.Arguments) != len(function.Parameters) {
+		return nil, diagnosticFor(call, fmt.Errorf("%s expects %d arguments, got %d", function, len(function.Parameters), len(call.Arguments)))
+	}
+
+	environment := NewEnvironment(function.Closure)
+	for i, argument := range call.Arguments {
+		value, err := argument.Accept(this)
+		if err != nil {
+			return nil, err
+		}
+		environment.Define(function.Parameters[i].TokenLiteral.Literal, value)
+	}
+
+	if this.callDepth >= maxCallDepth {
+		return nil, diagnosticFor(call, fmt.Errorf("maximum call depth of %d exceeded", maxCallDepth))
+	}
+	this.callDepth++
+	defer func() { this.callDepth-- }()
+
+	if body, ok := function.Body.(*Block); ok {
+		return this.evaluateBlock(body, environment)
+	}
+	previous := this.environment
+	this.environment = environment
+	defer func() { this.environment = previous }()
+	return function.Body.Accept(this)
+}
+
+func (this *Evaluator) callBuiltin(call *Call, builtin *Builtin) (Value, error) {
+	if err := builtin.CheckArity(len(call.Arguments)); err != nil {
+		return nil, diagnosticFor(call, err)
+	}
+	args := make([]Value, len(call.Arguments))
+	for i, argument := range call.Arguments {
+		value, err := argument.Accept(this)
+		if err != nil {
+			return nil, err
 		}
-		if value , exist := os.LookupEnv(operand) ; exist {
-			return value , nil;
+		args[i] = value
+	}
+	res, err := builtin.Fn(this.context, args)
+	if err != nil {
+		return nil, diagnosticFor(call, err)
+	}
+	if _, ok := res.(Decimal); !ok && res.Type() == NumberType && this.Decimal != nil {
+		if decimal, ok := this.Decimal.inexact(res); ok {
+			return decimal, nil
 		}
-		return "", fmt.Errorf("undeclared identifier %s", operand)
 	}
-	return "", nil
+	return res, nil
 }
 
-func (this *Evaluator) evaluateBinaryExpression(lhs string, operator Token, rhs string) (string, error) { //TODO
-	lhs_casted, err := strconv.ParseFloat(lhs, 64)
+// evaluateLogicalExpression only evaluates the right operand when the left one
+// does not already decide the result. Both operands must be booleans.
+func (this *Evaluator) evaluateLogicalExpression(exp *LogicalExpression) (Value, error) {
+	lhs, err := exp.Lhs.Accept(this)
 	if err != nil {
-		return "", fmt.Errorf("couldn't convert %s\n to float", lhs)
+		return nil, err
 	}
-	rhs_casted, err := strconv.ParseFloat(rhs, 64)
+	lhsBoolean, err := logicalOperand(exp, exp.Lhs, lhs)
 	if err != nil {
-		return "", fmt.Errorf("couldn't convert %s\n to float", rhs)
+		return nil, err
+	}
+	if shortCircuits(exp.Operator, lhsBoolean) {
+		return lhsBoolean, nil
 	}
-	switch operator.Token {
-	case Plus:
-		return strconv.FormatFloat(lhs_casted+rhs_casted, 'f', -1, 64), nil
-	case Minus:
-		return strconv.FormatFloat(lhs_casted-rhs_casted, 'f', -1, 64), nil
-	case Multiplication:
-		return strconv.FormatFloat(lhs_casted*rhs_casted, 'f', -1, 64), nil
-	case Division:
-		return strconv.FormatFloat(lhs_casted/rhs_casted, 'f', -1, 64), nil
-	default:
-		return "", fmt.Errorf("unexpected token %s\n", operator.Literal)
+	rhs, err := exp.Rhs.Accept(this)
+	if err != nil {
+		return nil, err
+	}
+	return logicalOperand(exp, exp.Rhs, rhs)
+}
+
+func (this *Evaluator) evaluateInterpolation(exp *Interpolation) (Value, error) {
+	texts, err := exp.Strings()
+	if err != nil {
+		return nil, diagnosticFor(exp, err)
+	}
+	values := make([]Value, 0, len(texts)+len(exp.Parts))
+	for i, text := range texts {
+		values = append(values, String(text))
+		if i < len(exp.Parts) {
+			value, err := exp.Parts[i].Accept(this)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+	}
+	return interpolate(values), nil
+}
+
+func constantValue(token Token) (Value, error) {
+	switch token.Token {
+	case TRUE:
+		return Boolean(true), nil
+	case FALSE:
+		return Boolean(false), nil
+	case INTEGER_LITERAL:
+		return parseIntegerLiteral(token.Literal)
+	case NUMBER_LITERAL:
+		number, err := strconv.ParseFloat(strings.ReplaceAll(token.Literal, "_", ""), 64)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't convert %s to number", token.Literal)
+		}
+		return Number(number), nil
+	case STRING_LITERAL:
+		return stringValue(token.Literal)
 	}
+	return nil, fmt.Errorf("unexpected constant %s", token.Literal)
+}
+
+// isNumberLiteral reports whether token is an integer or float literal.
+func isNumberLiteral(token Token) bool {
+	return token.Token == INTEGER_LITERAL || token.Token == NUMBER_LITERAL
+}
+
+// environmentValue is the value of an environment variable: its raw text,
+// which expressions convert explicitly, with number() for instance.
+func environmentValue(value string) Value {
+	return String(value)
+}
+
+func (this *Evaluator) Evaluate(exp Expression) (Value, error) {
+	return this.EvaluateContext(context.Background(), exp)
+}
+
+// EvaluateContext evaluates exp, handing ctx to the builtins it calls. Calls
+// fail once ctx is done.
+func (this *Evaluator) EvaluateContext(ctx context.Context, exp Expression) (Value, error) {
+	this.init()
+	this.context = ctx
+	return exp.Accept(this)
+}
+
+// Define binds name in the global scope of the evaluator.
+func (this *Evaluator) Define(name string, value Value) {
+	this.init()
+	this.environment.Define(name, value)
 }
 
-func (this *Evaluator) Evaluate(exp Expression) (string, error) {
-	if this.identifiers == nil {
-		this.identifiers = make(map[string]string)
+func (this *Evaluator) init() {
+	if this.environment == nil {
+		this.environment = NewEnvironment(nil)
 	}
-	return exp.accept(this)
 }
@@ -0,0 +1,277 @@
+package ast
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Integer is an exact whole number of any size. Integer literals evaluate to
+// integers, and so do the sums, differences and products of integers.
+// Integers are made by NewInteger or by evaluation: the zero Integer is not
+// valid.
+type Integer struct {
+	value *big.Int
+}
+
+// NewInteger returns the Integer i.
+func NewInteger(i int64) Integer {
+	return Integer{value: big.NewInt(i)}
+}
+
+// NewIntegerFromBig returns the Integer i, which the caller must not modify
+// anymore.
+func NewIntegerFromBig(i *big.Int) Integer {
+	return Integer{value: i}
+}
+
+// Int returns the integer as a big.Int the caller may modify.
+func (this Integer) Int() *big.Int {
+	return new(big.Int).Set(this.value)
+}
+
+func (this Integer) Type() ValueType {
+	return NumberType
+}
+
+func (this Integer) String() string {
+	return this.value.String()
+}
+
+// Rational is an exact fraction that is not a whole number, as the quotient
+// 1/3 of two integers. Operations on integers and rationals stay exact until
+// a float is involved, which makes them compute with floats.
+type Rational struct {
+	value *big.Rat
+}
+
+// NewRational returns numerator / denominator, an Integer when it is whole.
+// denominator must not be 0.
+func NewRational(numerator int64, denominator int64) Value {
+	return exactValue(big.NewRat(numerator, denominator))
+}
+
+// Rat returns the rational as a big.Rat the caller may modify.
+func (this Rational) Rat() *big.Rat {
+	return new(big.Rat).Set(this.value)
+}
+
+func (this Rational) Type() ValueType {
+	return NumberType
+}
+
+// String returns the rational as a fraction in lowest terms, as -2/3.
+func (this Rational) String() string {
+	return this.value.String()
+}
+
+// exactValue returns r as an Integer when it is whole, a Rational otherwise.
+func exactValue(r *big.Rat) Value {
+	if r.IsInt() {
+		return Integer{value: new(big.Int).Set(r.Num())}
+	}
+	return Rational{value: r}
+}
+
+// toRat returns value as a fraction when it is an Integer or a Rational.
+func toRat(value Value) (*big.Rat, bool) {
+	switch v := value.(type) {
+	case Integer:
+		return new(big.Rat).SetInt(v.value), true
+	case Rational:
+		return v.value, true
+	}
+	return nil, false
+}
+
+// floatValue returns value with the other kinds of numbers converted to the
+// closest Number, for the operations that only compute with floats.
+func floatValue(value Value) Value {
+	switch v := value.(type) {
+	case Decimal:
+		return v.Number()
+	case Integer:
+		number, _ := new(big.Float).SetInt(v.value).Float64()
+		return Number(number)
+	case Rational:
+		number, _ := v.value.Float64()
+		return Number(number)
+	}
+	return value
+}
+
+// ToFloat returns the float closest to value, when it is a number of any kind.
+func ToFloat(value Value) (Number, bool) {
+	number, ok := floatValue(value).(Number)
+	return number, ok
+}
+
+// exactOperation applies operator to the exact numbers lhs and rhs.
+func exactOperation(operator Token, lhs *big.Rat, rhs *big.Rat) (Value, error) {
+	switch operator.Token {
+	case Plus:
+		return exactValue(new(big.Rat).Add(lhs, rhs)), nil
+	case Minus:
+		return exactValue(new(big.Rat).Sub(lhs, rhs)), nil
+	case Multiplication:
+		return exactValue(new(big.Rat).Mul(lhs, rhs)), nil
+	case Division:
+		if rhs.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		return exactValue(new(big.Rat).Quo(lhs, rhs)), nil
+	case IntegerDivision:
+		if rhs.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		return exactValue(ratFloor(new(big.Rat).Quo(lhs, rhs))), nil
+	case Modulo:
+		if rhs.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		quotient := ratFloor(new(big.Rat).Quo(lhs, rhs))
+		return exactValue(new(big.Rat).Sub(lhs, quotient.Mul(quotient, rhs))), nil
+	case Power:
+		return exactPower(lhs, rhs)
+	}
+	return comparison(operator, lhs.Cmp(rhs))
+}
+
+// maxExactBits bounds the size of the exact results of ** and <<, which would
+// take any amount of memory otherwise.
+const maxExactBits = 1 << 20
+
+// exactPower returns lhs ** rhs, exactly when rhs is whole. A fractional
+// exponent computes with floats.
+func exactPower(lhs *big.Rat, rhs *big.Rat) (Value, error) {
+	if !rhs.IsInt() {
+		base, _ := lhs.Float64()
+		exponent, _ := rhs.Float64()
+		return Number(math.Pow(base, exponent)), nil
+	}
+	if rhs.Sign() < 0 && lhs.Sign() == 0 {
+		return nil, errDivisionByZero
+	}
+	exponent := new(big.Int).Abs(rhs.Num())
+	// Powers of 0, 1 and -1 stay small
+	size := max(lhs.Num().BitLen(), lhs.Denom().BitLen())
+	if size > 1 && (!exponent.IsInt64() || exponent.Int64() > maxExactBits/int64(size)) {
+		return nil, fmt.Errorf("%s ** %s is too large", lhs.RatString(), rhs.RatString())
+	}
+	numerator := new(big.Int).Exp(lhs.Num(), exponent, nil)
+	denominator := new(big.Int).Exp(lhs.Denom(), exponent, nil)
+	if rhs.Sign() < 0 {
+		numerator, denominator = denominator, numerator
+	}
+	return exactValue(new(big.Rat).SetFrac(numerator, denominator)), nil
+}
+
+// comparison returns the result of the comparison operator for lhs and rhs
+// such that lhs.Cmp(rhs) is cmp.
+func comparison(operator Token, cmp int) (Value, error) {
+	switch operator.Token {
+	case LESS:
+		return Boolean(cmp < 0), nil
+	case LESS_EQUAL:
+		return Boolean(cmp <= 0), nil
+	case GREATER:
+		return Boolean(cmp > 0), nil
+	case GREATER_EQUAL:
+		return Boolean(cmp >= 0), nil
+	}
+	return nil, fmt.Errorf("unexpected token %s", operator.Literal)
+}
+
+// compareNumbers compares two numbers exactly, whatever their kinds, as Cmp
+// does. It returns false when one of them is NaN.
+func compareNumbers(lhs Value, rhs Value) (int, bool) {
+	lhsRat, lhsOk := ratOf(lhs)
+	rhsRat, rhsOk := ratOf(rhs)
+	if lhsOk && rhsOk {
+		return lhsRat.Cmp(rhsRat), true
+	}
+	// An infinity, or NaN, is involved
+	lhsNumber, rhsNumber := floatValue(lhs).(Number), floatValue(rhs).(Number)
+	switch {
+	case lhsNumber < rhsNumber:
+		return -1, true
+	case lhsNumber > rhsNumber:
+		return 1, true
+	case lhsNumber == rhsNumber:
+		return 0, true
+	}
+	return 0, false
+}
+
+// ratOf returns the exact value of a number that is finite.
+func ratOf(value Value) (*big.Rat, bool) {
+	if r, ok := toRat(value); ok {
+		return r, true
+	}
+	number, ok := floatValue(value).(Number)
+	if !ok || math.IsInf(float64(number), 0) || math.IsNaN(float64(number)) {
+		return nil, false
+	}
+	return new(big.Rat).SetFloat64(float64(number)), true
+}
+
+// ratFloor returns the greatest whole number less than or equal to r.
+func ratFloor(r *big.Rat) *big.Rat {
+	// Euclidean division rounds down for the positive denominator
+	return new(big.Rat).SetInt(new(big.Int).Div(r.Num(), r.Denom()))
+}
+
+// ratTrunc returns the whole part of r.
+func ratTrunc(r *big.Rat) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Quo(r.Num(), r.Denom()))
+}
+
+// ratRound returns r rounded to the nearest whole number, ties away from zero.
+func ratRound(r *big.Rat) *big.Rat {
+	half := big.NewRat(int64(r.Sign()), 2)
+	return ratTrunc(half.Add(half, r))
+}
+
+// isExact reports whether value is an Integer or a Rational.
+func isExact(value Value) bool {
+	switch value.(type) {
+	case Integer, Rational:
+		return true
+	}
+	return false
+}
+
+// parseInteger converts decimal digits, with an optional sign, to the Integer
+// they spell.
+func parseInteger(text string) (Integer, error) {
+	value, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return Integer{}, fmt.Errorf("couldn't convert %s to number", text)
+	}
+	return Integer{value: value}, nil
+}
+
+// parseIntegerLiteral converts an integer literal, with an optional base
+// prefix and underscores between its digits, to the Integer it spells.
+func parseIntegerLiteral(literal string) (Integer, error) {
+	digits, base := strings.ReplaceAll(literal, "_", ""), 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+	}
+	if base != 10 {
+		digits = digits[2:]
+	}
+	value, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return Integer{}, fmt.Errorf("couldn't convert %s to number", literal)
+	}
+	return Integer{value: value}, nil
+}
@@ -1,5 +1,6 @@
 package ast
 
 type Expression interface {
-	accept(visitor Visitor) (string, error)
+	Accept(visitor Visitor) (Value, error)
+	Span() Span
 }
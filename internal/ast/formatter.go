@@ -0,0 +1,344 @@
+package ast
+
+import (
+	"math"
+	"strings"
+)
+
+// Precedence levels of grammar.txt, from the loosest to the tightest binding.
+// An operand needs parentheses when its level is lower than the one its
+// position requires.
+const (
+	lambdaPrecedence = iota
+	conditionalPrecedence
+	orPrecedence
+	andPrecedence
+	equalityPrecedence
+	comparisonPrecedence
+	bitOrPrecedence
+	bitXorPrecedence
+	bitAndPrecedence
+	shiftPrecedence
+	termPrecedence
+	factorPrecedence
+	unaryPrecedence
+	powerPrecedence
+	callPrecedence
+	primaryPrecedence
+)
+
+var binaryPrecedences = map[TokenType]int{
+	OR:              orPrecedence,
+	AND:             andPrecedence,
+	EQUAL_EQUAL:     equalityPrecedence,
+	BANG_EQUAL:      equalityPrecedence,
+	LESS:            comparisonPrecedence,
+	LESS_EQUAL:      comparisonPrecedence,
+	GREATER:         comparisonPrecedence,
+	GREATER_EQUAL:   comparisonPrecedence,
+	Plus:            termPrecedence,
+	Minus:           termPrecedence,
+	BIT_OR:          bitOrPrecedence,
+	BIT_XOR:         bitXorPrecedence,
+	BIT_AND:         bitAndPrecedence,
+	LEFT_SHIFT:      shiftPrecedence,
+	RIGHT_SHIFT:     shiftPrecedence,
+	Multiplication:  factorPrecedence,
+	Division:        factorPrecedence,
+	IntegerDivision: factorPrecedence,
+	Modulo:          factorPrecedence,
+	Power:           powerPrecedence,
+}
+
+// Source returns exp as source in canonical form: one statement per line,
+// blocks indented with tabs, single spaces around binary operators, the
+// symbols of the operators rather than their keywords, and only the
+// parentheses precedence requires.
+//
+// comments, in source order, are kept: those before a statement on lines of
+// their own, those after it on the same line. Block comments inside a
+// statement stay next to the token they follow or precede, while line
+// comments inside a statement are moved after it.
+func Source(exp Expression, comments ...Comment) string {
+	formatter := &formatter{comments: comments}
+	if program, ok := exp.(*Program); ok {
+		formatter.statements(program.Statements, math.MaxInt)
+	} else {
+		formatter.statements([]Expression{exp}, math.MaxInt)
+	}
+	formatter.leadingComments(math.MaxInt)
+	return formatter.builder.String()
+}
+
+type formatter struct {
+	builder  strings.Builder
+	indent   int
+	comments []Comment
+	// Index of the first comment not written yet
+	next int
+}
+
+// statements writes each statement on a line of its own. limit is the offset
+// where the enclosing block ends.
+func (this *formatter) statements(statements []Expression, limit int) {
+	for i, statement := range statements {
+		span := statement.Span()
+		this.leadingComments(span.Start.Offset)
+		this.builder.WriteString(strings.Repeat("\t", this.indent))
+		this.format(statement)
+
+		end := limit
+		if i+1 < len(statements) {
+			end = min(end, statements[i+1].Span().Start.Offset)
+		}
+		this.trailingComments(span, end)
+		this.builder.WriteString("\n")
+	}
+}
+
+// leadingComments writes the comments before offset on lines of their own.
+func (this *formatter) leadingComments(offset int) {
+	for ; this.next < len(this.comments) && this.comments[this.next].Span.Start.Offset < offset; this.next++ {
+		this.builder.WriteString(strings.Repeat("\t", this.indent) + this.comments[this.next].Text + "\n")
+	}
+}
+
+// trailingComments writes, after the statement spanning span, the comments
+// inside it and those following it on its last line, before end. Block
+// comments come first, so that a line comment ends the line.
+func (this *formatter) trailingComments(span Span, end int) {
+	var block, line []Comment
+	for ; this.next < len(this.comments); this.next++ {
+		comment := this.comments[this.next]
+		start := comment.Span.Start
+		if start.Offset >= span.End.Offset && (start.Offset >= end || start.Line != span.End.Line) {
+			break
+		}
+		if comment.IsBlock() {
+			block = append(block, comment)
+		} else {
+			line = append(line, comment)
+		}
+	}
+	for _, comment := range block {
+		this.builder.WriteString(" " + comment.Text)
+	}
+	for i, comment := range line {
+		if i > 0 {
+			this.builder.WriteString("\n" + strings.Repeat("\t", this.indent) + comment.Text)
+		} else {
+			this.builder.WriteString(" " + comment.Text)
+		}
+	}
+}
+
+// inlineComments writes the block comments before offset, inside the
+// statement being written, next to the token they follow when trailing and
+// next to the one they precede otherwise. It stops at a line comment, left
+// with the following ones for trailingComments.
+func (this *formatter) inlineComments(offset int, trailing bool) {
+	for ; this.hasComments(offset) && this.comments[this.next].IsBlock(); this.next++ {
+		if trailing {
+			this.builder.WriteString(" " + this.comments[this.next].Text)
+		} else {
+			this.builder.WriteString(this.comments[this.next].Text + " ")
+		}
+	}
+}
+
+// hasComments reports whether comments remain to be written before offset.
+func (this *formatter) hasComments(offset int) bool {
+	return this.next < len(this.comments) && this.comments[this.next].Span.Start.Offset < offset
+}
+
+func (this *formatter) format(exp Expression) {
+	this.inlineComments(exp.Span().Start.Offset, false)
+	switch e := exp.(type) {
+	case *Program:
+		this.statements(e.Statements, math.MaxInt)
+	case *Block:
+		this.block(e)
+	case *VarDeclaration:
+		this.builder.WriteString("var " + e.Operand.TokenLiteral.Literal)
+	case *Assignement:
+		this.builder.WriteString(e.LHS.TokenLiteral.Literal)
+		this.inlineComments(e.Operator.Span.Start.Offset, true)
+		this.builder.WriteString(" " + e.operator() + " ")
+		this.operand(e.Rhs, lambdaPrecedence)
+	case *FunctionDeclaration:
+		this.builder.WriteString("fn " + e.Name.TokenLiteral.Literal)
+		this.parameters(e.Parameters)
+		this.builder.WriteString(" ")
+		this.block(e.Body)
+	case *Lambda:
+		this.builder.WriteString("fn")
+		this.parameters(e.Parameters)
+		if body, ok := e.Body.(*Block); ok {
+			this.builder.WriteString(" ")
+			this.block(body)
+		} else {
+			this.builder.WriteString(" => ")
+			this.operand(e.Body, lambdaPrecedence)
+		}
+	case *Call:
+		this.operand(e.Callee, callPrecedence)
+		this.builder.WriteString("(")
+		for i, argument := range e.Arguments {
+			if i > 0 {
+				this.builder.WriteString(", ")
+			}
+			this.operand(argument, lambdaPrecedence)
+		}
+		this.inlineComments(e.Span().End.Offset, true)
+		this.builder.WriteString(")")
+	case *BinaryExpression:
+		this.binary(e.Lhs, e.Operator, e.Rhs)
+	case *LogicalExpression:
+		this.binary(e.Lhs, e.Operator, e.Rhs)
+	case *UnaryExpression:
+		this.builder.WriteString(string(e.Operator.Token))
+		this.operand(e.Operand, unaryPrecedence)
+	case *ConditionalExpression:
+		if e.IsTernary() {
+			this.operand(e.Condition, orPrecedence)
+			this.builder.WriteString(" ? ")
+			this.operand(e.Then, lambdaPrecedence)
+			this.builder.WriteString(" : ")
+			this.operand(e.Else, lambdaPrecedence)
+		} else if then, ok := e.Then.(*Block); ok {
+			this.ifBlock(e.Condition, then, e.Else)
+		} else {
+			this.builder.WriteString("if ")
+			this.operand(e.Condition, lambdaPrecedence)
+			this.builder.WriteString(" then ")
+			this.operand(e.Then, lambdaPrecedence)
+			this.builder.WriteString(" else ")
+			this.operand(e.Else, lambdaPrecedence)
+		}
+	case *While:
+		this.builder.WriteString("while ")
+		this.operand(e.Condition, lambdaPrecedence)
+		this.builder.WriteString(" ")
+		this.block(e.Body)
+	case *For:
+		this.builder.WriteString("for " + e.Variable.TokenLiteral.Literal + " in range(")
+		this.operand(e.Start, lambdaPrecedence)
+		this.builder.WriteString(", ")
+		this.operand(e.End, lambdaPrecedence)
+		this.builder.WriteString(") ")
+		this.block(e.Body)
+	case *Break:
+		this.builder.WriteString("break")
+	case *Continue:
+		this.builder.WriteString("continue")
+	case *Interpolation:
+		for i, text := range e.Texts {
+			this.builder.WriteString(text.Literal)
+			if i < len(e.Parts) {
+				this.operand(e.Parts[i], lambdaPrecedence)
+			}
+		}
+	case *CONSTANT:
+		this.builder.WriteString(e.TokenLiteral.Literal)
+	case *Identifier:
+		this.builder.WriteString(e.TokenLiteral.Literal)
+	}
+}
+
+// binary writes a left associative operation: an operand of the same level
+// needs parentheses on the right only. The right-associative ** is the
+// reverse.
+func (this *formatter) binary(lhs Expression, operator Token, rhs Expression) {
+	precedence := binaryPrecedences[operator.Token]
+	lhsPrecedence, rhsPrecedence := precedence, precedence+1
+	if operator.Token == Power {
+		// ** is right-associative, and its exponent may be a unary
+		lhsPrecedence, rhsPrecedence = precedence+1, unaryPrecedence
+	}
+	this.operand(lhs, lhsPrecedence)
+	this.inlineComments(operator.Span.Start.Offset, true)
+	this.builder.WriteString(" " + string(operator.Token) + " ")
+	this.operand(rhs, rhsPrecedence)
+}
+
+// operand writes exp, in parentheses when it binds looser than precedence.
+func (this *formatter) operand(exp Expression, precedence int) {
+	if precedenceOf(exp) >= precedence {
+		this.format(exp)
+		return
+	}
+	this.builder.WriteString("(")
+	this.format(exp)
+	this.builder.WriteString(")")
+}
+
+// block writes a block holding a single expression on one line, other blocks
+// over several lines.
+func (this *formatter) block(block *Block) {
+	comments := this.hasComments(block.Close.Span.Start.Offset)
+	if len(block.Statements) == 0 && !comments {
+		this.builder.WriteString("{}")
+		return
+	}
+	if len(block.Statements) == 1 && !isStatement(block.Statements[0]) && !comments {
+		inline := &formatter{}
+		inline.format(block.Statements[0])
+		if !strings.Contains(inline.builder.String(), "\n") {
+			this.builder.WriteString("{ " + inline.builder.String() + " }")
+			return
+		}
+	}
+	this.builder.WriteString("{\n")
+	this.indent++
+	this.statements(block.Statements, block.Close.Span.Start.Offset)
+	this.leadingComments(block.Close.Span.Start.Offset)
+	this.indent--
+	this.builder.WriteString(strings.Repeat("\t", this.indent) + "}")
+}
+
+// ifBlock writes an if with blocks as branches, otherwise being nil, a block
+// or the if following `else`.
+func (this *formatter) ifBlock(condition Expression, then *Block, otherwise Expression) {
+	this.builder.WriteString("if ")
+	this.operand(condition, lambdaPrecedence)
+	this.builder.WriteString(" ")
+	this.block(then)
+	if otherwise != nil {
+		this.builder.WriteString(" else ")
+		this.format(otherwise)
+	}
+}
+
+func (this *formatter) parameters(parameters []Identifier) {
+	this.builder.WriteString("(" + strings.Join(parameterNames(parameters), ", ") + ")")
+}
+
+// isStatement reports whether exp can only appear as a statement, or is an
+// assignment, which a block does not hold on a single line either.
+func isStatement(exp Expression) bool {
+	switch exp.(type) {
+	case *VarDeclaration, *Assignement, *FunctionDeclaration, *Block, *Program, *While, *For, *Break, *Continue:
+		return true
+	}
+	return false
+}
+
+func precedenceOf(exp Expression) int {
+	switch e := exp.(type) {
+	case *BinaryExpression:
+		return binaryPrecedences[e.Operator.Token]
+	case *LogicalExpression:
+		return binaryPrecedences[e.Operator.Token]
+	case *ConditionalExpression:
+		if e.IsTernary() {
+			return conditionalPrecedence
+		}
+	case *UnaryExpression:
+		return unaryPrecedence
+	case *Call:
+		return callPrecedence
+	case *CONSTANT, *Identifier, *Interpolation:
+		return primaryPrecedence
+	}
+	return lambdaPrecedence
+}
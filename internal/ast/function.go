@@ -0,0 +1,21 @@
+package ast
+
+// Function is a user-defined function together with the scope it was defined
+// in.
+type Function struct {
+	Name       string
+	Parameters []Identifier
+	Body       Expression
+	Closure    *Environment
+}
+
+func (this *Function) Type() ValueType {
+	return FunctionType
+}
+
+func (this *Function) String() string {
+	if this.Name == "" {
+		return "<fn>"
+	}
+	return "<fn " + this.Name + ">"
+}
@@ -0,0 +1,17 @@
+package ast
+
+// FunctionDeclaration binds a named function in the current scope.
+type FunctionDeclaration struct {
+	Keyword    Token
+	Name       Identifier
+	Parameters []Identifier
+	Body       *Block
+}
+
+func (this *FunctionDeclaration) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *FunctionDeclaration) Span() Span {
+	return this.Keyword.Span.Join(this.Body.Span())
+}
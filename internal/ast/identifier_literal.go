@@ -4,6 +4,10 @@ type Identifier struct {
 	TokenLiteral Token
 }
 
-func (this *Identifier) accept(visitor Visitor) (string, error) {
-	return visitor.visit(this)
+func (this *Identifier) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Identifier) Span() Span {
+	return this.TokenLiteral.Span
 }
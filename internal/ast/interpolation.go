@@ -0,0 +1,37 @@
+package ast
+
+// Interpolation is a string literal holding expressions between "${" and
+// "}", as in "total: ${price * count}". Texts are the tokens of the literal
+// around the expressions, so there is one more text than there are parts.
+type Interpolation struct {
+	Texts []Token
+	Parts []Expression
+}
+
+func (this *Interpolation) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Interpolation) Span() Span {
+	return this.Texts[0].Span.Join(this.Texts[len(this.Texts)-1].Span)
+}
+
+// Strings returns the texts around the parts, with their delimiters removed
+// and their escape sequences replaced.
+func (this *Interpolation) Strings() ([]string, error) {
+	res := make([]string, len(this.Texts))
+	for i, text := range this.Texts {
+		literal := text.Literal
+		// A text starts with a quote or "}" and ends with "${" or a quote
+		end := len(literal) - 1
+		if i < len(this.Texts)-1 {
+			end = len(literal) - 2
+		}
+		value, _, err := Unescape(literal[1:end])
+		if err != nil {
+			return nil, err
+		}
+		res[i] = value
+	}
+	return res, nil
+}
@@ -0,0 +1,17 @@
+package ast
+
+// Lambda is an anonymous function. Its body is either a single expression
+// (`fn(x) => x * 2`) or a *Block.
+type Lambda struct {
+	Keyword    Token
+	Parameters []Identifier
+	Body       Expression
+}
+
+func (this *Lambda) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Lambda) Span() Span {
+	return this.Keyword.Span.Join(this.Body.Span())
+}
@@ -0,0 +1,17 @@
+package ast
+
+// LogicalExpression is a binary expression whose right operand is only
+// evaluated when the left one does not decide the result.
+type LogicalExpression struct {
+	Lhs      Expression
+	Operator Token
+	Rhs      Expression
+}
+
+func (this *LogicalExpression) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *LogicalExpression) Span() Span {
+	return this.Lhs.Span().Join(this.Rhs.Span())
+}
@@ -0,0 +1,63 @@
+package ast
+
+// While evaluates Body as long as Condition, which must evaluate to a bool as
+// the condition of a ConditionalExpression, is true. It evaluates to nil.
+type While struct {
+	Keyword   Token
+	Condition Expression
+	Body      *Block
+}
+
+func (this *While) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *While) Span() Span {
+	return this.Keyword.Span.Join(this.Body.Span())
+}
+
+// For evaluates Body for each number of `range(Start, End)`, from Start up to
+// but excluding End by steps of 1, with Variable bound to it in a scope of the
+// loop. Both bounds must be numbers and are evaluated once, before the first
+// iteration. It evaluates to nil.
+type For struct {
+	Keyword  Token
+	Variable Identifier
+	Start    Expression
+	End      Expression
+	Body     *Block
+}
+
+func (this *For) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *For) Span() Span {
+	return this.Keyword.Span.Join(this.Body.Span())
+}
+
+// Break ends the innermost loop.
+type Break struct {
+	Keyword Token
+}
+
+func (this *Break) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Break) Span() Span {
+	return this.Keyword.Span
+}
+
+// Continue ends the current iteration of the innermost loop.
+type Continue struct {
+	Keyword Token
+}
+
+func (this *Continue) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Continue) Span() Span {
+	return this.Keyword.Span
+}
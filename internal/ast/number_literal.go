@@ -4,6 +4,10 @@ type CONSTANT struct {
 	TokenLiteral Token
 }
 
-func (this *CONSTANT) accept(visitor Visitor) (string, error) {
-	return visitor.visit(this)
+func (this *CONSTANT) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *CONSTANT) Span() Span {
+	return this.TokenLiteral.Span
 }
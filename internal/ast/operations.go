@@ -0,0 +1,388 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+var operationNames = map[TokenType]string{
+	Plus:           "add",
+	Minus:          "subtract",
+	Multiplication: "multiply",
+	Division:       "divide",
+	LESS:           "compare",
+	LESS_EQUAL:     "compare",
+	GREATER:        "compare",
+	GREATER_EQUAL:  "compare",
+}
+
+// BinaryOperation applies operator to lhs and rhs. Operations on integers and
+// rationals are exact, the others compute with floats: a Decimal operand is
+// converted to the closest Number first. Comparisons of numbers are exact.
+// Bitwise operators only apply to integers.
+func BinaryOperation(operator Token, lhs Value, rhs Value) (Value, error) {
+	switch operator.Token {
+	case EQUAL_EQUAL:
+		return Boolean(Equal(lhs, rhs)), nil
+	case BANG_EQUAL:
+		return Boolean(!Equal(lhs, rhs)), nil
+	}
+	if operator.IsBitwiseOperator() {
+		return bitwiseOperation(operator, lhs, rhs)
+	}
+	if lhsString, ok := lhs.(String); ok {
+		if rhsString, ok := rhs.(String); ok {
+			return stringOperation(operator, lhsString, rhsString)
+		}
+	}
+	lhsNumber, lhsOk := floatValue(lhs).(Number)
+	rhsNumber, rhsOk := floatValue(rhs).(Number)
+	if !lhsOk || !rhsOk {
+		return nil, typeError(operator, lhs, rhs)
+	}
+	lhsRat, lhsExact := toRat(lhs)
+	rhsRat, rhsExact := toRat(rhs)
+	if lhsExact && rhsExact {
+		return exactOperation(operator, lhsRat, rhsRat)
+	}
+	if lhsExact || rhsExact {
+		switch operator.Token {
+		case LESS, LESS_EQUAL, GREATER, GREATER_EQUAL:
+			cmp, ok := compareNumbers(lhs, rhs)
+			if !ok {
+				// NaN is neither less nor greater than anything
+				return Boolean(false), nil
+			}
+			return comparison(operator, cmp)
+		}
+	}
+	switch operator.Token {
+	case Plus:
+		return lhsNumber + rhsNumber, nil
+	case Minus:
+		return lhsNumber - rhsNumber, nil
+	case Multiplication:
+		return lhsNumber * rhsNumber, nil
+	case Division:
+		return lhsNumber / rhsNumber, nil
+	case IntegerDivision:
+		return Number(math.Floor(float64(lhsNumber / rhsNumber))), nil
+	case Modulo:
+		return floatModulo(lhsNumber, rhsNumber), nil
+	case Power:
+		return Number(math.Pow(float64(lhsNumber), float64(rhsNumber))), nil
+	case LESS:
+		return Boolean(lhsNumber < rhsNumber), nil
+	case LESS_EQUAL:
+		return Boolean(lhsNumber <= rhsNumber), nil
+	case GREATER:
+		return Boolean(lhsNumber > rhsNumber), nil
+	case GREATER_EQUAL:
+		return Boolean(lhsNumber >= rhsNumber), nil
+	default:
+		return nil, fmt.Errorf("unexpected token %s", operator.Literal)
+	}
+}
+
+// BinaryOperation applies operator to lhs and rhs as BinaryOperation does,
+// computing with decimals when both operands are numbers.
+func (this *DecimalMode) BinaryOperation(operator Token, lhs Value, rhs Value) (Value, error) {
+	lhsDecimal, lhsOk := toDecimal(lhs)
+	rhsDecimal, rhsOk := toDecimal(rhs)
+	if !lhsOk || !rhsOk {
+		return BinaryOperation(operator, lhs, rhs)
+	}
+	switch operator.Token {
+	case Plus:
+		return lhsDecimal.add(rhsDecimal), nil
+	case Minus:
+		return lhsDecimal.sub(rhsDecimal), nil
+	case Multiplication:
+		return lhsDecimal.mul(rhsDecimal), nil
+	case Division:
+		return lhsDecimal.quo(rhsDecimal, this.Scale, this.Rounding)
+	case IntegerDivision:
+		return lhsDecimal.floorQuo(rhsDecimal)
+	case Modulo:
+		quotient, err := lhsDecimal.floorQuo(rhsDecimal)
+		if err != nil {
+			return nil, err
+		}
+		return lhsDecimal.sub(quotient.mul(rhsDecimal)), nil
+	case Power:
+		return this.power(lhsDecimal, rhsDecimal)
+	case EQUAL_EQUAL:
+		return Boolean(lhsDecimal.Cmp(rhsDecimal) == 0), nil
+	case BANG_EQUAL:
+		return Boolean(lhsDecimal.Cmp(rhsDecimal) != 0), nil
+	case LESS:
+		return Boolean(lhsDecimal.Cmp(rhsDecimal) < 0), nil
+	case LESS_EQUAL:
+		return Boolean(lhsDecimal.Cmp(rhsDecimal) <= 0), nil
+	case GREATER:
+		return Boolean(lhsDecimal.Cmp(rhsDecimal) > 0), nil
+	case GREATER_EQUAL:
+		return Boolean(lhsDecimal.Cmp(rhsDecimal) >= 0), nil
+	}
+	return BinaryOperation(operator, lhs, rhs)
+}
+
+// Equal reports whether two values are the same. Values of different types
+// are never equal. Numbers of different kinds are equal when their values
+// are, a Decimal equaling the numbers that convert to it.
+func Equal(lhs Value, rhs Value) bool {
+	if lhs.Type() != rhs.Type() {
+		return false
+	}
+	_, lhsDecimal := lhs.(Decimal)
+	_, rhsDecimal := rhs.(Decimal)
+	if lhsDecimal || rhsDecimal {
+		lhs, lhsOk := toDecimal(lhs)
+		rhs, rhsOk := toDecimal(rhs)
+		return lhsOk && rhsOk && lhs.Cmp(rhs) == 0
+	}
+	if isExact(lhs) || isExact(rhs) {
+		cmp, ok := compareNumbers(lhs, rhs)
+		return ok && cmp == 0
+	}
+	return lhs == rhs
+}
+
+// power returns lhs ** rhs, exactly when rhs is a whole number: a negative
+// exponent divides as Division does. A fractional exponent computes with
+// floats, the result being rounded as a quotient is.
+func (this *DecimalMode) power(lhs Decimal, rhs Decimal) (Value, error) {
+	if rhs.round(0, RoundDown).Cmp(rhs) != 0 {
+		res, err := decimalFromNumber(Number(math.Pow(float64(lhs.Number()), float64(rhs.Number()))))
+		if err != nil {
+			return nil, err
+		}
+		return res.round(this.Scale, this.Rounding), nil
+	}
+	exponent := new(big.Int).Abs(rhs.round(0, RoundDown).unscaled)
+	// Bits of the result per unit of the exponent, roughly: powers of 0, 1
+	// and -1 stay small
+	size := int64(lhs.unscaled.BitLen() + 4*lhs.scale)
+	if size > 1 && (!exponent.IsInt64() || exponent.Int64() > maxExactBits/size) {
+		return nil, fmt.Errorf("%s ** %s is too large", lhs, rhs)
+	}
+	res := Decimal{unscaled: new(big.Int).Exp(lhs.unscaled, exponent, nil)}
+	if lhs.scale > 0 {
+		res.scale = lhs.scale * int(exponent.Int64())
+	}
+	if rhs.unscaled.Sign() < 0 {
+		return decimalOne.quo(res, this.Scale, this.Rounding)
+	}
+	return res, nil
+}
+
+func UnaryOperation(operator Token, operand Value) (Value, error) {
+	switch operator.Token {
+	case Minus:
+		switch v := operand.(type) {
+		case Decimal:
+			return v.neg(), nil
+		case Integer:
+			return Integer{value: new(big.Int).Neg(v.value)}, nil
+		case Rational:
+			return Rational{value: new(big.Rat).Neg(v.value)}, nil
+		}
+		number, ok := operand.(Number)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", operand.Type())
+		}
+		return -number, nil
+	case BANG:
+		boolean, ok := operand.(Boolean)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply '%s' to %s", operator.Literal, operand.Type())
+		}
+		return !boolean, nil
+	case BIT_NOT:
+		integer, ok := toBigInt(operand)
+		if !ok {
+			return nil, fmt.Errorf("operand of '%s' must be an integer, got %s", operator.Literal, describeNumber(operand))
+		}
+		return Integer{value: new(big.Int).Not(integer)}, nil
+	default:
+		return nil, fmt.Errorf("unexpected token %s", operator.Literal)
+	}
+}
+
+// bitwiseOperation applies the bitwise operator to lhs and rhs, which must be
+// integers.
+func bitwiseOperation(operator Token, lhs Value, rhs Value) (Value, error) {
+	lhsInt, lhsOk := toBigInt(lhs)
+	rhsInt, rhsOk := toBigInt(rhs)
+	if !lhsOk || !rhsOk {
+		operand := lhs
+		if lhsOk {
+			operand = rhs
+		}
+		return nil, fmt.Errorf("operands of '%s' must be integers, got %s", operator.Literal, describeNumber(operand))
+	}
+	res := new(big.Int)
+	switch operator.Token {
+	case BIT_AND:
+		res.And(lhsInt, rhsInt)
+	case BIT_OR:
+		res.Or(lhsInt, rhsInt)
+	case BIT_XOR:
+		res.Xor(lhsInt, rhsInt)
+	case LEFT_SHIFT, RIGHT_SHIFT:
+		if rhsInt.Sign() < 0 {
+			return nil, fmt.Errorf("negative shift count %s", rhsInt)
+		}
+		if operator.Token == RIGHT_SHIFT {
+			// Shifting past the last bit gives 0, or -1 for a negative lhs
+			count := uint(lhsInt.BitLen() + 1)
+			if rhsInt.IsInt64() {
+				count = min(count, uint(rhsInt.Int64()))
+			}
+			res.Rsh(lhsInt, count)
+		} else if lhsInt.Sign() != 0 {
+			if !rhsInt.IsInt64() || int64(lhsInt.BitLen())+rhsInt.Int64() > maxExactBits {
+				return nil, fmt.Errorf("%s << %s is too large", lhsInt, rhsInt)
+			}
+			res.Lsh(lhsInt, uint(rhsInt.Int64()))
+		}
+	}
+	return Integer{value: res}, nil
+}
+
+// toBigInt returns value as a big.Int the caller must not modify when it is
+// an Integer, or a Decimal written without a fraction as integer literals are.
+func toBigInt(value Value) (*big.Int, bool) {
+	switch v := value.(type) {
+	case Integer:
+		return v.value, true
+	case Decimal:
+		if v.scale == 0 {
+			return v.unscaled, true
+		}
+	}
+	return nil, false
+}
+
+// describeNumber describes value in errors: numbers by their kind and value,
+// written with a fraction when they are floats or decimals, other values by
+// their type.
+func describeNumber(value Value) string {
+	switch v := value.(type) {
+	case Number:
+		return "float " + withFraction(v.String())
+	case Decimal:
+		return "decimal " + withFraction(v.String())
+	case Rational:
+		return "rational " + v.String()
+	}
+	return string(value.Type())
+}
+
+// withFraction appends ".0" to number when it has no fraction.
+func withFraction(number string) string {
+	if strings.ContainsAny(number, ".IN") {
+		return number
+	}
+	return number + ".0"
+}
+
+// floatModulo returns the remainder of lhs divided by rhs rounded down, which
+// has the sign of rhs, as the remainder of exact numbers does.
+func floatModulo(lhs Number, rhs Number) Number {
+	res := Number(math.Mod(float64(lhs), float64(rhs)))
+	if res != 0 && (res < 0) != (rhs < 0) {
+		res += rhs
+	}
+	return res
+}
+
+func typeError(operator Token, lhs Value, rhs Value) error {
+	name, exist := operationNames[operator.Token]
+	if !exist {
+		name = "apply '" + operator.Literal + "' to"
+	}
+	return fmt.Errorf("cannot %s %s and %s", name, lhs.Type(), rhs.Type())
+}
+
+// condition checks that value, the value of the condition exp of a
+// conditional expression or loop, is a bool.
+func condition(exp Expression, value Value) (Boolean, error) {
+	boolean, ok := value.(Boolean)
+	if !ok {
+		return false, diagnosticFor(exp, fmt.Errorf("condition must be bool, got %s", value.Type()))
+	}
+	return boolean, nil
+}
+
+// rangeBounds checks that start and end, the values of the bounds of loop,
+// are numbers.
+func rangeBounds(loop *For, start Value, end Value) error {
+	if start.Type() != NumberType {
+		return diagnosticFor(loop.Start, fmt.Errorf("range bounds must be numbers, got %s", start.Type()))
+	}
+	if end.Type() != NumberType {
+		return diagnosticFor(loop.End, fmt.Errorf("range bounds must be numbers, got %s", end.Type()))
+	}
+	return nil
+}
+
+// inRange reports whether value, a value of the variable of a range loop, is
+// before end.
+func inRange(value Value, end Value) bool {
+	if valueNumber, ok := value.(Number); ok {
+		if endNumber, ok := end.(Number); ok {
+			return valueNumber < endNumber
+		}
+	}
+	res, _ := BinaryOperation(Token{Literal: "<", Token: LESS}, value, end)
+	return res == Boolean(true)
+}
+
+// rangeNext returns the value of the variable of a range loop following value.
+func rangeNext(value Value) Value {
+	if number, ok := value.(Number); ok {
+		return number + 1
+	}
+	res, _ := BinaryOperation(Token{Literal: "+", Token: Plus}, value, NewInteger(1))
+	return res
+}
+
+// errBreak and errContinue unwind the evaluation of the body of a loop, for
+// the Evaluator and the CompiledProgram. The parser rejects break and
+// continue outside of loops.
+var (
+	errBreak    = errors.New("break outside of a loop")
+	errContinue = errors.New("continue outside of a loop")
+)
+
+// loopControl handles err, returned by an iteration of the body of a loop. It
+// reports whether the loop stops, and the error it fails with if any.
+func loopControl(err error) (bool, error) {
+	switch err {
+	case nil, errContinue:
+		return false, nil
+	case errBreak:
+		return true, nil
+	}
+	return true, err
+}
+
+// shortCircuits reports whether the left operand of a logical operator
+// already decides the result.
+func shortCircuits(operator Token, lhs Boolean) bool {
+	return (operator.Token == OR && bool(lhs)) || (operator.Token == AND && !bool(lhs))
+}
+
+// logicalOperand checks that value, the value of operand, is a valid operand
+// for the logical expression exp.
+func logicalOperand(exp *LogicalExpression, operand Expression, value Value) (Boolean, error) {
+	boolean, ok := value.(Boolean)
+	if !ok {
+		return false, diagnosticFor(operand, fmt.Errorf("operands of '%s' must be bool, got %s", exp.Operator.Literal, value.Type()))
+	}
+	return boolean, nil
+}
@@ -0,0 +1,394 @@
+package ast
+
+import (
+	"math"
+	"strconv"
+)
+
+// Pass is an optimization of the AST. It returns the optimized expression,
+// sharing the nodes it leaves unchanged with exp, which is never modified.
+// A pass returns exp itself when it has nothing to optimize.
+//
+// Passes never change the result of a program that evaluates without error,
+// nor hide the type errors it would report.
+type Pass interface {
+	Name() string
+	Optimize(exp Expression) Expression
+}
+
+// Passes returns every optimization pass, in the order Optimize runs them.
+func Passes() []Pass {
+	return []Pass{ConstantFolding{}, AlgebraicSimplification{}, DeadBranchElimination{}}
+}
+
+// maxOptimizationRounds bounds the number of times Optimize runs the passes.
+const maxOptimizationRounds = 8
+
+// Optimize runs passes, or every pass when none is given, until they no
+// longer change the AST.
+func Optimize(exp Expression, passes ...Pass) Expression {
+	if len(passes) == 0 {
+		passes = Passes()
+	}
+	for range maxOptimizationRounds {
+		optimized := exp
+		for _, pass := range passes {
+			optimized = pass.Optimize(optimized)
+		}
+		if optimized == exp {
+			break
+		}
+		exp = optimized
+	}
+	return exp
+}
+
+// ConstantFolding evaluates the operations whose operands are constants, as
+// in 2 * (3 + 4) or "a" + "b", and the interpolations of constants.
+// Operations that would fail are left for the evaluator to report.
+type ConstantFolding struct{}
+
+func (this ConstantFolding) Name() string {
+	return "constant folding"
+}
+
+func (this ConstantFolding) Optimize(exp Expression) Expression {
+	return this.visit(exp)
+}
+
+func (this ConstantFolding) visit(exp Expression) Expression {
+	exp = rewriteChildren(exp, this.visit)
+	switch e := exp.(type) {
+	case *BinaryExpression:
+		lhs, lhsOk := constantOf(e.Lhs)
+		rhs, rhsOk := constantOf(e.Rhs)
+		if !lhsOk || !rhsOk {
+			break
+		}
+		if value, err := BinaryOperation(e.Operator, lhs, rhs); err == nil {
+			if constant, ok := constantExpression(value, e.Span()); ok {
+				return constant
+			}
+		}
+	case *UnaryExpression:
+		operand, ok := constantOf(e.Operand)
+		if !ok {
+			break
+		}
+		if value, err := UnaryOperation(e.Operator, operand); err == nil {
+			if constant, ok := constantExpression(value, e.Span()); ok {
+				return constant
+			}
+		}
+	case *LogicalExpression:
+		lhs, ok := constantOf(e.Lhs)
+		lhsBoolean, isBoolean := lhs.(Boolean)
+		if !ok || !isBoolean {
+			break
+		}
+		if shortCircuits(e.Operator, lhsBoolean) {
+			constant, _ := constantExpression(lhsBoolean, e.Span())
+			return constant
+		}
+		if rhs, ok := constantOf(e.Rhs); ok {
+			if rhsBoolean, ok := rhs.(Boolean); ok {
+				constant, _ := constantExpression(rhsBoolean, e.Span())
+				return constant
+			}
+		}
+	case *Interpolation:
+		texts, err := e.Strings()
+		if err != nil {
+			break
+		}
+		values := make([]Value, 0, len(texts)+len(e.Parts))
+		for i, text := range texts {
+			values = append(values, String(text))
+			if i < len(e.Parts) {
+				value, ok := constantOf(e.Parts[i])
+				if !ok {
+					return exp
+				}
+				values = append(values, value)
+			}
+		}
+		constant, _ := constantExpression(interpolate(values), e.Span())
+		return constant
+	}
+	return exp
+}
+
+// AlgebraicSimplification removes the operations that leave their operand
+// unchanged: x * 1, 1 * x, x / 1, x + 0, 0 + x, x - 0, --x and !!x, 0 and 1
+// being integers. As they would fail for operands of the wrong type, x is
+// only simplified when it always evaluates to a number, or to a bool for !!x.
+// Identifiers and calls are left alone.
+type AlgebraicSimplification struct{}
+
+func (this AlgebraicSimplification) Name() string {
+	return "algebraic simplification"
+}
+
+func (this AlgebraicSimplification) Optimize(exp Expression) Expression {
+	return this.visit(exp)
+}
+
+func (this AlgebraicSimplification) visit(exp Expression) Expression {
+	exp = rewriteChildren(exp, this.visit)
+	switch e := exp.(type) {
+	case *BinaryExpression:
+		switch e.Operator.Token {
+		case Plus:
+			if isIntegerConstant(e.Rhs, 0) && isNumeric(e.Lhs) {
+				return e.Lhs
+			}
+			if isIntegerConstant(e.Lhs, 0) && isNumeric(e.Rhs) {
+				return e.Rhs
+			}
+		case Minus:
+			if isIntegerConstant(e.Rhs, 0) && isNumeric(e.Lhs) {
+				return e.Lhs
+			}
+		case Multiplication:
+			if isIntegerConstant(e.Rhs, 1) && isNumeric(e.Lhs) {
+				return e.Lhs
+			}
+			if isIntegerConstant(e.Lhs, 1) && isNumeric(e.Rhs) {
+				return e.Rhs
+			}
+		case Division:
+			if isIntegerConstant(e.Rhs, 1) && isNumeric(e.Lhs) {
+				return e.Lhs
+			}
+		}
+	case *UnaryExpression:
+		inner, ok := e.Operand.(*UnaryExpression)
+		if !ok || inner.Operator.Token != e.Operator.Token {
+			break
+		}
+		if e.Operator.Token == Minus && isNumeric(inner.Operand) {
+			return inner.Operand
+		}
+		if e.Operator.Token == BANG && isBoolean(inner.Operand) {
+			return inner.Operand
+		}
+	}
+	return exp
+}
+
+// DeadBranchElimination replaces the conditional expressions whose condition
+// is a constant bool by the branch it selects, as in if true then a else b.
+// A constant branch takes the span of the whole expression, as folded
+// constants do. Conditions of another type are left for the evaluator to
+// report.
+type DeadBranchElimination struct{}
+
+func (this DeadBranchElimination) Name() string {
+	return "dead branch elimination"
+}
+
+func (this DeadBranchElimination) Optimize(exp Expression) Expression {
+	return this.visit(exp)
+}
+
+func (this DeadBranchElimination) visit(exp Expression) Expression {
+	exp = rewriteChildren(exp, this.visit)
+	if e, ok := exp.(*ConditionalExpression); ok {
+		value, ok := constantOf(e.Condition)
+		boolean, isBoolean := value.(Boolean)
+		if !ok || !isBoolean {
+			return exp
+		}
+		branch := e.Else
+		if boolean {
+			branch = e.Then
+		}
+		if branch == nil {
+			// An if without else evaluating to nil
+			return exp
+		}
+		if value, ok := constantOf(branch); ok {
+			if constant, ok := constantExpression(value, e.Span()); ok {
+				return constant
+			}
+		}
+		return branch
+	}
+	return exp
+}
+
+// rewriteChildren applies visit to the direct sub-expressions of exp. It
+// returns a copy of exp holding the results when one of them changed, exp
+// otherwise.
+func rewriteChildren(exp Expression, visit func(Expression) Expression) Expression {
+	switch e := exp.(type) {
+	case *Program:
+		if statements, changed := rewriteAll(e.Statements, visit); changed {
+			return &Program{Statements: statements}
+		}
+	case *Block:
+		if statements, changed := rewriteAll(e.Statements, visit); changed {
+			return &Block{Open: e.Open, Statements: statements, Close: e.Close}
+		}
+	case *Assignement:
+		if rhs := visit(e.Rhs); rhs != e.Rhs {
+			return NewAssignement(e.LHS, e.Operator, rhs)
+		}
+	case *FunctionDeclaration:
+		if body, ok := visit(e.Body).(*Block); ok && body != e.Body {
+			return &FunctionDeclaration{Keyword: e.Keyword, Name: e.Name, Parameters: e.Parameters, Body: body}
+		}
+	case *Lambda:
+		if body := visit(e.Body); body != e.Body {
+			return &Lambda{Keyword: e.Keyword, Parameters: e.Parameters, Body: body}
+		}
+	case *Call:
+		callee := visit(e.Callee)
+		arguments, changed := rewriteAll(e.Arguments, visit)
+		if changed || callee != e.Callee {
+			return &Call{Callee: callee, Arguments: arguments, Close: e.Close}
+		}
+	case *BinaryExpression:
+		lhs, rhs := visit(e.Lhs), visit(e.Rhs)
+		if lhs != e.Lhs || rhs != e.Rhs {
+			return &BinaryExpression{Lhs: lhs, Operator: e.Operator, Rhs: rhs}
+		}
+	case *LogicalExpression:
+		lhs, rhs := visit(e.Lhs), visit(e.Rhs)
+		if lhs != e.Lhs || rhs != e.Rhs {
+			return &LogicalExpression{Lhs: lhs, Operator: e.Operator, Rhs: rhs}
+		}
+	case *UnaryExpression:
+		if operand := visit(e.Operand); operand != e.Operand {
+			return &UnaryExpression{Operator: e.Operator, Operand: operand}
+		}
+	case *ConditionalExpression:
+		condition, then, otherwise := visit(e.Condition), visit(e.Then), e.Else
+		if otherwise != nil {
+			otherwise = visit(e.Else)
+		}
+		if condition != e.Condition || then != e.Then || otherwise != e.Else {
+			return &ConditionalExpression{Keyword: e.Keyword, Condition: condition, Then: then, Else: otherwise}
+		}
+	case *Interpolation:
+		if parts, changed := rewriteAll(e.Parts, visit); changed {
+			return &Interpolation{Texts: e.Texts, Parts: parts}
+		}
+	case *While:
+		condition := visit(e.Condition)
+		body, ok := visit(e.Body).(*Block)
+		if condition != e.Condition || ok && body != e.Body {
+			if !ok {
+				body = e.Body
+			}
+			return &While{Keyword: e.Keyword, Condition: condition, Body: body}
+		}
+	case *For:
+		start, end := visit(e.Start), visit(e.End)
+		body, ok := visit(e.Body).(*Block)
+		if start != e.Start || end != e.End || ok && body != e.Body {
+			if !ok {
+				body = e.Body
+			}
+			return &For{Keyword: e.Keyword, Variable: e.Variable, Start: start, End: end, Body: body}
+		}
+	}
+	return exp
+}
+
+func rewriteAll(expressions []Expression, visit func(Expression) Expression) ([]Expression, bool) {
+	var res []Expression
+	for i, exp := range expressions {
+		rewritten := visit(exp)
+		if rewritten != exp && res == nil {
+			res = append(make([]Expression, 0, len(expressions)), expressions[:i]...)
+		}
+		if res != nil {
+			res = append(res, rewritten)
+		}
+	}
+	if res == nil {
+		return expressions, false
+	}
+	return res, true
+}
+
+// constantOf returns the value of exp when it is a constant.
+func constantOf(exp Expression) (Value, bool) {
+	constant, ok := exp.(*CONSTANT)
+	if !ok {
+		return nil, false
+	}
+	value, err := constantValue(constant.TokenLiteral)
+	return value, err == nil
+}
+
+// constantExpression returns a constant evaluating to value, spanning span.
+// Numbers that have no literal, rationals, infinities and NaN, cannot be
+// constants.
+func constantExpression(value Value, span Span) (*CONSTANT, bool) {
+	switch v := value.(type) {
+	case Integer:
+		return &CONSTANT{TokenLiteral: Token{Literal: v.String(), Token: INTEGER_LITERAL, Span: span}}, true
+	case Number:
+		if math.IsInf(float64(v), 0) || math.IsNaN(float64(v)) {
+			return nil, false
+		}
+		literal := strconv.FormatFloat(float64(v), 'g', -1, 64)
+		return &CONSTANT{TokenLiteral: Token{Literal: literal, Token: NUMBER_LITERAL, Span: span}}, true
+	case Boolean:
+		if v {
+			return &CONSTANT{TokenLiteral: Token{Literal: "true", Token: TRUE, Span: span}}, true
+		}
+		return &CONSTANT{TokenLiteral: Token{Literal: "false", Token: FALSE, Span: span}}, true
+	case String:
+		return &CONSTANT{TokenLiteral: Token{Literal: Quote(string(v)), Token: STRING_LITERAL, Span: span}}, true
+	}
+	return nil, false
+}
+
+// isIntegerConstant reports whether exp is the integer constant n. Float
+// constants are not, as x * 1.0 is a float even when x is an integer.
+func isIntegerConstant(exp Expression, n int64) bool {
+	value, ok := constantOf(exp)
+	integer, isInteger := value.(Integer)
+	return ok && isInteger && integer.value.IsInt64() && integer.value.Int64() == n
+}
+
+// isNumeric reports whether exp evaluates to a number whenever it evaluates
+// without error.
+func isNumeric(exp Expression) bool {
+	switch e := exp.(type) {
+	case *CONSTANT:
+		return isNumberLiteral(e.TokenLiteral)
+	case *BinaryExpression:
+		// Strings can be added too, but not to numbers
+		if e.Operator.Token == Plus {
+			return isNumeric(e.Lhs) || isNumeric(e.Rhs)
+		}
+		return e.Operator.IsArithmeticOperator()
+	case *UnaryExpression:
+		return e.Operator.Token == Minus || e.Operator.Token == BIT_NOT
+	case *ConditionalExpression:
+		return isNumeric(e.Then) && isNumeric(e.Else)
+	}
+	return false
+}
+
+// isBoolean reports whether exp evaluates to a bool whenever it evaluates
+// without error.
+func isBoolean(exp Expression) bool {
+	switch e := exp.(type) {
+	case *CONSTANT:
+		return e.TokenLiteral.Token == TRUE || e.TokenLiteral.Token == FALSE
+	case *BinaryExpression:
+		return !e.Operator.IsArithmeticOperator()
+	case *LogicalExpression:
+		return true
+	case *UnaryExpression:
+		return e.Operator.Token == BANG
+	case *ConditionalExpression:
+		return isBoolean(e.Then) && isBoolean(e.Else)
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package ast
+
+import "fmt"
+
+// Position is a location in the source. Offset counts bytes from the start of
+// the input, Line and Column start at 1 and Column counts bytes from the start
+// of the line.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (this Position) String() string {
+	return fmt.Sprintf("%d:%d", this.Line, this.Column)
+}
+
+// Span is the part of the source between Start (inclusive) and End
+// (exclusive).
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (this Span) String() string {
+	return this.Start.String()
+}
+
+// Join returns the smallest span covering both spans.
+func (this Span) Join(other Span) Span {
+	if this.Start.Line == 0 {
+		return other
+	}
+	if other.Start.Line == 0 {
+		return this
+	}
+	res := this
+	if other.Start.Offset < res.Start.Offset {
+		res.Start = other.Start
+	}
+	if other.End.Offset > res.End.Offset {
+		res.End = other.End
+	}
+	return res
+}
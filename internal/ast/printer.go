@@ -1,13 +1,47 @@
 package ast
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+)
+
+// Format is an output format of the Printer.
+type Format string
 
+const (
+	// Indented tree, one node per line
+	FormatTree Format = "tree"
+	// JSON object per node, children nested under their role
+	FormatJSON Format = "json"
+	// S-expression, operators first
+	FormatSExpr Format = "sexpr"
+	// Graphviz DOT digraph
+	FormatDOT Format = "dot"
 )
 
+var formats = []Format{FormatTree, FormatJSON, FormatSExpr, FormatDOT}
+
+// ParseFormat returns the format called name.
+func ParseFormat(name string) (Format, error) {
+	for _, format := range formats {
+		if string(format) == name {
+			return format, nil
+		}
+	}
+	names := make([]string, len(formats))
+	for i, format := range formats {
+		names[i] = string(format)
+	}
+	return "", fmt.Errorf("unknown AST format %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
 type Printer struct {
+	Format  Format
 	builder strings.Builder
+	// Number of nodes written to the DOT output so far
+	nodes int
 }
 
 func (this *Printer) visit(exp Expression, prefix string, isLast bool) {
@@ -23,30 +57,337 @@ func (this *Printer) visit(exp Expression, prefix string, isLast bool) {
 		childPrefix += "│   "
 	}
 
-	switch e := exp.(type) {
-	case *BinaryExpression:
-		this.builder.WriteString(prefix + connector + "BinaryExpr (" + e.Operator.Literal + ")\n")
-		this.visit(e.Lhs, childPrefix, false)
-		this.visit(e.Rhs, childPrefix, true)
-	case *UnaryExpression:
-		this.builder.WriteString(prefix + connector + "UnaryExpr (" + e.Operator.Literal + ")\n")
-		this.visit(e.Operand, childPrefix, true)
-	case *CONSTANT:
-		this.builder.WriteString(prefix + connector + "Number: " + e.TokenLiteral.Literal + "\n")
+	this.builder.WriteString(prefix + connector + label(exp) + "\n")
+	children := namedChildren(exp)
+	for i, child := range children {
+		this.visit(child.exp, childPrefix, i == len(children)-1)
 	}
 }
 
-func (this *Printer) print(exp Expression) string {
+// Sprint returns exp in the format of the printer, the tree when unset.
+func (this *Printer) Sprint(exp Expression) string {
 	this.builder.Reset()
-	this.builder.WriteString("Expression\n")
-	this.visit(exp, "", true)
+	switch this.Format {
+	case FormatJSON:
+		encoder := json.NewEncoder(&this.builder)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(toJSON(exp)); err != nil {
+			return err.Error()
+		}
+	case FormatSExpr:
+		this.sexpr(exp)
+		this.builder.WriteString("\n")
+	case FormatDOT:
+		this.nodes = 0
+		this.builder.WriteString("digraph AST {\n\tnode [shape=box, fontname=monospace];\n")
+		this.dot(exp)
+		this.builder.WriteString("}\n")
+	default:
+		this.builder.WriteString("Expression\n")
+		this.visit(exp, "", true)
+	}
 	return this.builder.String()
 }
 
 func (this *Printer) PrintAST(exp Expression) {
-	fmt.Print(this.print(exp))
+	fmt.Print(this.Sprint(exp))
 }
 
 func CreatePrinter() *Printer {
-	return &Printer{}
+	return &Printer{Format: FormatTree}
+}
+
+// label describes exp itself, without its children.
+func label(exp Expression) string {
+	switch e := exp.(type) {
+	case *Program:
+		return "Program"
+	case *Block:
+		return "Block"
+	case *VarDeclaration:
+		return "VarDeclaration: " + e.Operand.TokenLiteral.Literal
+	case *Assignement:
+		if operator := e.operator(); operator != string(EQUAL) {
+			return "Assignement (" + operator + "): " + e.LHS.TokenLiteral.Literal
+		}
+		return "Assignement: " + e.LHS.TokenLiteral.Literal
+	case *FunctionDeclaration:
+		return "FunctionDeclaration: " + e.Name.TokenLiteral.Literal + "(" + strings.Join(parameterNames(e.Parameters), ", ") + ")"
+	case *Lambda:
+		return "Lambda(" + strings.Join(parameterNames(e.Parameters), ", ") + ")"
+	case *Call:
+		return "Call"
+	case *BinaryExpression:
+		return "BinaryExpr (" + e.Operator.Literal + ")"
+	case *LogicalExpression:
+		return "LogicalExpr (" + e.Operator.Literal + ")"
+	case *UnaryExpression:
+		return "UnaryExpr (" + e.Operator.Literal + ")"
+	case *Interpolation:
+		return "Interpolation"
+	case *ConditionalExpression:
+		return "Conditional"
+	case *While:
+		return "While"
+	case *For:
+		return "For: " + e.Variable.TokenLiteral.Literal
+	case *Break:
+		return "Break"
+	case *Continue:
+		return "Continue"
+	case *CONSTANT:
+		return constantKind(e) + ": " + e.TokenLiteral.Literal
+	case *Identifier:
+		return "Identifier: " + e.TokenLiteral.Literal
+	case *BadExpression:
+		return "BadExpression"
+	}
+	return fmt.Sprintf("%T", exp)
+}
+
+// constantKind names the type of the value of a constant.
+func constantKind(constant *CONSTANT) string {
+	switch constant.TokenLiteral.Token {
+	case TRUE, FALSE:
+		return "Boolean"
+	case INTEGER_LITERAL, NUMBER_LITERAL:
+		return "Number"
+	case STRING_LITERAL:
+		return "String"
+	}
+	return "Constant"
+}
+
+type namedChild struct {
+	// Role of the child in its parent, empty for the elements of a list
+	name string
+	exp  Expression
+}
+
+// namedChildren returns the sub-expressions of exp that are printed as nodes,
+// in source order. Names that are part of the label of exp are left out.
+func namedChildren(exp Expression) []namedChild {
+	switch e := exp.(type) {
+	case *Program:
+		return listChildren("", e.Statements)
+	case *Block:
+		return listChildren("", e.Statements)
+	case *Assignement:
+		return []namedChild{{"rhs", e.Rhs}}
+	case *FunctionDeclaration:
+		return []namedChild{{"body", e.Body}}
+	case *Lambda:
+		return []namedChild{{"body", e.Body}}
+	case *Call:
+		return append([]namedChild{{"callee", e.Callee}}, listChildren("argument", e.Arguments)...)
+	case *BinaryExpression:
+		return []namedChild{{"lhs", e.Lhs}, {"rhs", e.Rhs}}
+	case *LogicalExpression:
+		return []namedChild{{"lhs", e.Lhs}, {"rhs", e.Rhs}}
+	case *UnaryExpression:
+		return []namedChild{{"operand", e.Operand}}
+	case *Interpolation:
+		return listChildren("part", e.Parts)
+	case *ConditionalExpression:
+		if e.Else == nil {
+			return []namedChild{{"condition", e.Condition}, {"then", e.Then}}
+		}
+		return []namedChild{{"condition", e.Condition}, {"then", e.Then}, {"else", e.Else}}
+	case *While:
+		return []namedChild{{"condition", e.Condition}, {"body", e.Body}}
+	case *For:
+		return []namedChild{{"start", e.Start}, {"end", e.End}, {"body", e.Body}}
+	}
+	return nil
+}
+
+func listChildren(name string, expressions []Expression) []namedChild {
+	res := make([]namedChild, len(expressions))
+	for i, exp := range expressions {
+		res[i] = namedChild{name, exp}
+	}
+	return res
+}
+
+func parameterNames(parameters []Identifier) []string {
+	names := make([]string, len(parameters))
+	for i, parameter := range parameters {
+		names[i] = parameter.TokenLiteral.Literal
+	}
+	return names
+}
+
+type jsonNode struct {
+	Node       string      `json:"node"`
+	Span       string      `json:"span,omitempty"`
+	Operator   string      `json:"operator,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	Value      string      `json:"value,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	Parameters []string    `json:"parameters,omitempty"`
+	Lhs        *jsonNode   `json:"lhs,omitempty"`
+	Rhs        *jsonNode   `json:"rhs,omitempty"`
+	Operand    *jsonNode   `json:"operand,omitempty"`
+	Callee     *jsonNode   `json:"callee,omitempty"`
+	Arguments  []*jsonNode `json:"arguments,omitempty"`
+	Body       *jsonNode   `json:"body,omitempty"`
+	Statements []*jsonNode `json:"statements,omitempty"`
+	Condition  *jsonNode   `json:"condition,omitempty"`
+	Then       *jsonNode   `json:"then,omitempty"`
+	Else       *jsonNode   `json:"else,omitempty"`
+	Texts      []string    `json:"texts,omitempty"`
+	Parts      []*jsonNode `json:"parts,omitempty"`
+	Start      *jsonNode   `json:"start,omitempty"`
+	End        *jsonNode   `json:"end,omitempty"`
+}
+
+func toJSON(exp Expression) *jsonNode {
+	node := &jsonNode{Node: strings.TrimPrefix(fmt.Sprintf("%T", exp), "*ast.")}
+	if span := exp.Span(); span.Start.Line > 0 {
+		node.Span = span.Start.String() + "-" + span.End.String()
+	}
+	switch e := exp.(type) {
+	case *Program:
+		node.Statements = toJSONList(e.Statements)
+	case *Block:
+		node.Statements = toJSONList(e.Statements)
+	case *VarDeclaration:
+		node.Name = e.Operand.TokenLiteral.Literal
+	case *Assignement:
+		node.Name = e.LHS.TokenLiteral.Literal
+		if operator := e.operator(); operator != string(EQUAL) {
+			node.Operator = operator
+		}
+		node.Rhs = toJSON(e.Rhs)
+	case *FunctionDeclaration:
+		node.Name = e.Name.TokenLiteral.Literal
+		node.Parameters = parameterNames(e.Parameters)
+		node.Body = toJSON(e.Body)
+	case *Lambda:
+		node.Parameters = parameterNames(e.Parameters)
+		node.Body = toJSON(e.Body)
+	case *Call:
+		node.Callee = toJSON(e.Callee)
+		node.Arguments = toJSONList(e.Arguments)
+	case *BinaryExpression:
+		node.Operator = e.Operator.Literal
+		node.Lhs, node.Rhs = toJSON(e.Lhs), toJSON(e.Rhs)
+	case *LogicalExpression:
+		node.Operator = e.Operator.Literal
+		node.Lhs, node.Rhs = toJSON(e.Lhs), toJSON(e.Rhs)
+	case *UnaryExpression:
+		node.Operator = e.Operator.Literal
+		node.Operand = toJSON(e.Operand)
+	case *ConditionalExpression:
+		node.Condition, node.Then = toJSON(e.Condition), toJSON(e.Then)
+		if e.Else != nil {
+			node.Else = toJSON(e.Else)
+		}
+	case *While:
+		node.Condition, node.Body = toJSON(e.Condition), toJSON(e.Body)
+	case *For:
+		node.Name = e.Variable.TokenLiteral.Literal
+		node.Start, node.End, node.Body = toJSON(e.Start), toJSON(e.End), toJSON(e.Body)
+	case *Interpolation:
+		node.Texts, _ = e.Strings()
+		node.Parts = toJSONList(e.Parts)
+	case *CONSTANT:
+		node.Node = "Constant"
+		node.Type = strings.ToLower(constantKind(e))
+		node.Value = e.TokenLiteral.Literal
+	case *Identifier:
+		node.Name = e.TokenLiteral.Literal
+	}
+	return node
+}
+
+func toJSONList(expressions []Expression) []*jsonNode {
+	nodes := make([]*jsonNode, len(expressions))
+	for i, exp := range expressions {
+		nodes[i] = toJSON(exp)
+	}
+	return nodes
+}
+
+func (this *Printer) sexpr(exp Expression) {
+	switch e := exp.(type) {
+	case *Program:
+		this.sexprList("program", e.Statements...)
+	case *Block:
+		this.sexprList("block", e.Statements...)
+	case *VarDeclaration:
+		this.builder.WriteString("(var " + e.Operand.TokenLiteral.Literal + ")")
+	case *Assignement:
+		this.sexprList(e.operator()+" "+e.LHS.TokenLiteral.Literal, e.Rhs)
+	case *FunctionDeclaration:
+		this.sexprList("fn "+e.Name.TokenLiteral.Literal+" ("+strings.Join(parameterNames(e.Parameters), " ")+")", e.Body)
+	case *Lambda:
+		this.sexprList("lambda ("+strings.Join(parameterNames(e.Parameters), " ")+")", e.Body)
+	case *Call:
+		this.sexprList("call", append([]Expression{e.Callee}, e.Arguments...)...)
+	case *BinaryExpression:
+		this.sexprList(string(e.Operator.Token), e.Lhs, e.Rhs)
+	case *LogicalExpression:
+		this.sexprList(string(e.Operator.Token), e.Lhs, e.Rhs)
+	case *UnaryExpression:
+		this.sexprList(string(e.Operator.Token), e.Operand)
+	case *ConditionalExpression:
+		if e.Else == nil {
+			this.sexprList("if", e.Condition, e.Then)
+		} else {
+			this.sexprList("if", e.Condition, e.Then, e.Else)
+		}
+	case *While:
+		this.sexprList("while", e.Condition, e.Body)
+	case *For:
+		this.sexprList("for "+e.Variable.TokenLiteral.Literal, e.Start, e.End, e.Body)
+	case *Break:
+		this.builder.WriteString("(break)")
+	case *Continue:
+		this.builder.WriteString("(continue)")
+	case *Interpolation:
+		// The texts are written as string literals between the parts
+		texts, _ := e.Strings()
+		this.builder.WriteString("(interpolate")
+		for i, text := range texts {
+			this.builder.WriteString(" " + Quote(text))
+			if i < len(e.Parts) {
+				this.builder.WriteString(" ")
+				this.sexpr(e.Parts[i])
+			}
+		}
+		this.builder.WriteString(")")
+	case *CONSTANT:
+		this.builder.WriteString(e.TokenLiteral.Literal)
+	case *Identifier:
+		this.builder.WriteString(e.TokenLiteral.Literal)
+	case *BadExpression:
+		this.builder.WriteString("(error)")
+	}
+}
+
+func (this *Printer) sexprList(head string, expressions ...Expression) {
+	this.builder.WriteString("(" + head)
+	for _, exp := range expressions {
+		this.builder.WriteString(" ")
+		this.sexpr(exp)
+	}
+	this.builder.WriteString(")")
+}
+
+// dot writes the node of exp and the edges to its children, returning its id.
+func (this *Printer) dot(exp Expression) string {
+	id := "n" + strconv.Itoa(this.nodes)
+	this.nodes++
+	fmt.Fprintf(&this.builder, "\t%s [label=%s];\n", id, strconv.Quote(label(exp)))
+	for _, child := range namedChildren(exp) {
+		childId := this.dot(child.exp)
+		if child.name == "" {
+			fmt.Fprintf(&this.builder, "\t%s -> %s;\n", id, childId)
+		} else {
+			fmt.Fprintf(&this.builder, "\t%s -> %s [label=%s];\n", id, childId, strconv.Quote(child.name))
+		}
+	}
+	return id
 }
@@ -0,0 +1,18 @@
+package ast
+
+// Program is a list of statements evaluated in order. Its value is the value
+// of the last statement.
+type Program struct {
+	Statements []Expression
+}
+
+func (this *Program) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *Program) Span() Span {
+	if len(this.Statements) == 0 {
+		return Span{}
+	}
+	return this.Statements[0].Span().Join(this.Statements[len(this.Statements)-1].Span())
+}
@@ -0,0 +1,129 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// escapes maps the character following a backslash in a string literal to
+// the one the sequence stands for. \u followed by four hexadecimal digits
+// stands for that code point.
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+	'$':  '$',
+}
+
+// Unescape replaces the escape sequences of text, the inside of a string
+// literal. It fails on the first invalid sequence, also returning its offset
+// in text.
+func Unescape(text string) (string, int, error) {
+	if !strings.Contains(text, `\`) {
+		return text, 0, nil
+	}
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' {
+			builder.WriteByte(text[i])
+			continue
+		}
+		if i+1 >= len(text) {
+			return "", i, fmt.Errorf("invalid escape sequence '\\'")
+		}
+		if char, exist := escapes[text[i+1]]; exist {
+			builder.WriteByte(char)
+			i++
+			continue
+		}
+		if text[i+1] == 'u' && i+6 <= len(text) {
+			if code, err := strconv.ParseUint(text[i+2:i+6], 16, 32); err == nil {
+				builder.WriteRune(rune(code))
+				i += 5
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i+1:])
+		return "", i, fmt.Errorf("invalid escape sequence '\\%s'", text[i+1:i+1+size])
+	}
+	return builder.String(), 0, nil
+}
+
+// Quote returns a double quoted string literal evaluating to value.
+func Quote(value string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for i, char := range value {
+		switch char {
+		case '"', '\\':
+			builder.WriteString(`\` + string(char))
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '$':
+			// Only "${" would start an interpolation
+			if strings.HasPrefix(value[i:], "${") {
+				builder.WriteString(`\$`)
+			} else {
+				builder.WriteByte('$')
+			}
+		default:
+			if char < ' ' || char == 0x7f {
+				fmt.Fprintf(&builder, `\u%04x`, char)
+			} else {
+				builder.WriteRune(char)
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+// stringValue returns the value of a string literal token.
+func stringValue(literal string) (Value, error) {
+	if len(literal) < 2 {
+		return nil, fmt.Errorf("invalid string literal %s", literal)
+	}
+	value, _, err := Unescape(literal[1 : len(literal)-1])
+	if err != nil {
+		return nil, err
+	}
+	return String(value), nil
+}
+
+// stringOperation applies a binary operator to two strings: + concatenates
+// them and the comparisons order them byte-wise.
+func stringOperation(operator Token, lhs String, rhs String) (Value, error) {
+	switch operator.Token {
+	case Plus:
+		return lhs + rhs, nil
+	case LESS:
+		return Boolean(lhs < rhs), nil
+	case LESS_EQUAL:
+		return Boolean(lhs <= rhs), nil
+	case GREATER:
+		return Boolean(lhs > rhs), nil
+	case GREATER_EQUAL:
+		return Boolean(lhs >= rhs), nil
+	}
+	return nil, typeError(operator, lhs, rhs)
+}
+
+// interpolate concatenates the text of values, as the value of an
+// Interpolation.
+func interpolate(values []Value) String {
+	var builder strings.Builder
+	for _, value := range values {
+		builder.WriteString(value.String())
+	}
+	return String(builder.String())
+}
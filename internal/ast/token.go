@@ -5,13 +5,40 @@ type TokenType string
 type Token struct {
 	Literal string
 	Token   TokenType
+	Span    Span
+	// Comments around the token, nil unless the lexer keeps comments
+	Trivia *Trivia
 }
 
+// IsArithmeticOperator reports whether the token is a binary operator whose
+// result is a number, bitwise operators included.
 func (this *Token) IsArithmeticOperator() bool {
-	return this.Token == Minus ||
-		this.Token == Plus ||
-		this.Token == Multiplication ||
-		this.Token == Division
+	switch this.Token {
+	case Minus, Plus, Multiplication, Division, IntegerDivision, Modulo, Power,
+		BIT_AND, BIT_OR, BIT_XOR, LEFT_SHIFT, RIGHT_SHIFT:
+		return true
+	}
+	return false
+}
+
+// IsAssignmentOperator reports whether the token is = or a compound
+// assignment operator.
+func (this *Token) IsAssignmentOperator() bool {
+	switch this.Token {
+	case EQUAL, PLUS_EQUAL, MINUS_EQUAL, STAR_EQUAL, SLASH_EQUAL:
+		return true
+	}
+	return false
+}
+
+// IsBitwiseOperator reports whether the token is a binary operator on
+// integers.
+func (this *Token) IsBitwiseOperator() bool {
+	switch this.Token {
+	case BIT_AND, BIT_OR, BIT_XOR, LEFT_SHIFT, RIGHT_SHIFT:
+		return true
+	}
+	return false
 }
 
 const (
@@ -19,12 +46,56 @@ const (
 	Minus              TokenType = "-"
 	Multiplication     TokenType = "*"
 	Division           TokenType = "/"
+	IntegerDivision    TokenType = "//"
+	Modulo             TokenType = "%"
+	Power              TokenType = "**"
+	BIT_AND            TokenType = "&"
+	BIT_OR             TokenType = "|"
+	BIT_XOR            TokenType = "^"
+	BIT_NOT            TokenType = "~"
+	LEFT_SHIFT         TokenType = "<<"
+	RIGHT_SHIFT        TokenType = ">>"
 	Open_Parentheses   TokenType = "("
 	Close_Parentheses  TokenType = ")"
+	INTEGER_LITERAL    TokenType = "\\d+"
 	NUMBER_LITERAL     TokenType = "\\d*"
+	STRING_LITERAL     TokenType = "\"...\""
+	TEMPLATE_START     TokenType = "\"...${"
+	TEMPLATE_MIDDLE    TokenType = "}...${"
+	TEMPLATE_END       TokenType = "}...\""
 	IDENTIFIER_LITERAL TokenType = "_[a-zA-Z]"
 	EQUAL              TokenType = "="
+	PLUS_EQUAL         TokenType = "+="
+	MINUS_EQUAL        TokenType = "-="
+	STAR_EQUAL         TokenType = "*="
+	SLASH_EQUAL        TokenType = "/="
+	EQUAL_EQUAL        TokenType = "=="
+	BANG_EQUAL         TokenType = "!="
+	LESS               TokenType = "<"
+	LESS_EQUAL         TokenType = "<="
+	GREATER            TokenType = ">"
+	GREATER_EQUAL      TokenType = ">="
+	AND                TokenType = "&&"
+	OR                 TokenType = "||"
+	BANG               TokenType = "!"
+	SEMICOLON          TokenType = ";"
+	NEWLINE            TokenType = "\\n"
+	COMMA              TokenType = ","
+	Open_Brace         TokenType = "{"
+	Close_Brace        TokenType = "}"
+	ARROW              TokenType = "=>"
+	QUESTION           TokenType = "?"
+	COLON              TokenType = ":"
 	VAR                TokenType = "var"
+	FN                 TokenType = "fn"
 	TRUE               TokenType = "true"
 	FALSE              TokenType = "false"
+	IF                 TokenType = "if"
+	THEN               TokenType = "then"
+	ELSE               TokenType = "else"
+	WHILE              TokenType = "while"
+	FOR                TokenType = "for"
+	IN                 TokenType = "in"
+	BREAK              TokenType = "break"
+	CONTINUE           TokenType = "continue"
 )
@@ -5,6 +5,10 @@ type UnaryExpression struct {
 	Operand  Expression
 }
 
-func (this *UnaryExpression) accept(visitor Visitor) (string, error) {
-	return visitor.visit(this)
+func (this *UnaryExpression) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *UnaryExpression) Span() Span {
+	return this.Operator.Span.Join(this.Operand.Span())
 }
@@ -0,0 +1,61 @@
+package ast
+
+import "strconv"
+
+type ValueType string
+
+const (
+	NumberType   ValueType = "number"
+	BooleanType  ValueType = "bool"
+	NilType      ValueType = "nil"
+	StringType   ValueType = "string"
+	FunctionType ValueType = "function"
+)
+
+// Value is the result of evaluating an expression. New kinds of runtime
+// values are added by implementing this interface; the operators in
+// operations.go report a type error for any kind they do not support.
+type Value interface {
+	Type() ValueType
+	String() string
+}
+
+type Number float64
+
+func (this Number) Type() ValueType {
+	return NumberType
+}
+
+func (this Number) String() string {
+	return strconv.FormatFloat(float64(this), 'f', -1, 64)
+}
+
+type Boolean bool
+
+func (this Boolean) Type() ValueType {
+	return BooleanType
+}
+
+func (this Boolean) String() string {
+	return strconv.FormatBool(bool(this))
+}
+
+type Nil struct{}
+
+func (this Nil) Type() ValueType {
+	return NilType
+}
+
+func (this Nil) String() string {
+	return "nil"
+}
+
+type String string
+
+func (this String) Type() ValueType {
+	return StringType
+}
+
+func (this String) String() string {
+	return string(this)
+}
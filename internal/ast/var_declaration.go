@@ -1,9 +1,14 @@
 package ast
 
 type VarDeclaration struct {
+	Keyword Token
 	Operand Identifier
 }
 
-func (this *VarDeclaration) accept(visitor Visitor) (string, error) {
-	return visitor.visit(this)
+func (this *VarDeclaration) Accept(visitor Visitor) (Value, error) {
+	return visitor.Visit(this)
+}
+
+func (this *VarDeclaration) Span() Span {
+	return this.Keyword.Span.Join(this.Operand.Span())
 }
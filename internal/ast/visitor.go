@@ -1,5 +1,7 @@
 package ast
 
+// Visitor is implemented by passes over the AST. Expression.Accept calls
+// Visit with the node itself, which the visitor then switches on.
 type Visitor interface {
-	visit(expression Expression) (string, error)
+	Visit(expression Expression) (Value, error)
 }
@@ -0,0 +1,324 @@
+package ast
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// vm is the state of one run of a Bytecode program.
+type vm struct {
+	stack    []Value
+	calls    []callFrame
+	loops    []loopFrame
+	globals  []Value
+	fallback []bool
+	context  context.Context
+	osEnv    bool
+}
+
+// callFrame is a call in progress, saved while it calls another function.
+type callFrame struct {
+	chunk *Chunk
+	ip    int
+	scope *frame
+}
+
+// loopFrame is a loop in progress, recorded by ENTER_LOOP.
+type loopFrame struct {
+	height int
+	scope  *frame
+}
+
+// Run executes the program on the virtual machine. It takes its globals as
+// CompiledProgram.Run does, and evaluates like the Evaluator.
+func (this *Bytecode) Run(ctx context.Context, globals []Value, osEnv bool) (Value, error) {
+	if globals != nil && len(globals) != len(this.globals) {
+		return nil, fmt.Errorf("expected %d globals, got %d", len(this.globals), len(globals))
+	}
+	machine, ok := this.runs.Get().(*vm)
+	if !ok {
+		machine = &vm{globals: make([]Value, len(this.globals)), fallback: make([]bool, len(this.globals))}
+	}
+	defer this.runs.Put(machine)
+
+	machine.context = ctx
+	machine.osEnv = osEnv
+	machine.stack = machine.stack[:0]
+	machine.calls = machine.calls[:0]
+	machine.loops = machine.loops[:0]
+	bindGlobals(this.globals, globals, machine.globals, machine.fallback)
+	value, err := machine.run(this.Chunk)
+	clear(machine.stack[:cap(machine.stack)])
+	clear(machine.calls[:cap(machine.calls)])
+	clear(machine.loops[:cap(machine.loops)])
+	return value, err
+}
+
+func (this *vm) run(chunk *Chunk) (Value, error) {
+	var scope *frame
+	ip := 0
+	code := chunk.Code
+
+	for {
+		offset := ip
+		opcode := Opcode(code[ip])
+		ip++
+		// Operands of the instruction, when it has some
+		var operand, second int
+		if operands := opcode.Operands(); operands > 0 {
+			operand = int(binary.BigEndian.Uint16(code[ip:]))
+			if operands > 1 {
+				second = int(binary.BigEndian.Uint16(code[ip+2:]))
+			}
+			ip += 2 * operands
+		}
+
+		switch opcode {
+		case OpConstant:
+			this.push(chunk.Constants[operand])
+		case OpPop:
+			this.pop()
+		case OpGetGlobal:
+			value := this.globals[operand]
+			if value == nil {
+				var err error
+				if value, err = unboundGlobal(chunk.nodes[offset].(*Identifier), this.osEnv); err != nil {
+					return nil, err
+				}
+			}
+			this.push(value)
+		case OpSetGlobal:
+			this.globals[operand] = this.peek(0)
+			this.fallback[operand] = false
+		case OpDefineGlobal:
+			if this.globals[operand] != nil && !this.fallback[operand] {
+				return nil, this.doubleDeclaration(chunk.nodes[offset])
+			}
+			this.globals[operand] = this.peek(0)
+			this.fallback[operand] = false
+		case OpGetDeclared:
+			if this.fallback[operand] {
+				this.push(nil)
+			} else {
+				this.push(this.globals[operand])
+			}
+		case OpGetLocal:
+			this.push(scope.up(operand).slots[second])
+		case OpSetLocal:
+			scope.up(operand).slots[second] = this.peek(0)
+		case OpDefineLocal:
+			if scope.slots[operand] != nil {
+				return nil, this.doubleDeclaration(chunk.nodes[offset])
+			}
+			scope.slots[operand] = this.peek(0)
+		case OpAdd, OpSubtract, OpMultiply, OpDivide,
+			OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual,
+			OpIntegerDivide, OpModulo, OpPower, OpBitAnd, OpBitOr, OpBitXor, OpShiftLeft, OpShiftRight:
+			rhs := this.pop()
+			lhs := this.pop()
+			res, err := this.binary(opcode, chunk.nodes[offset].(*BinaryExpression), lhs, rhs)
+			if err != nil {
+				return nil, err
+			}
+			this.push(res)
+		case OpNegate, OpNot, OpBitNot:
+			exp := chunk.nodes[offset].(*UnaryExpression)
+			res, err := UnaryOperation(exp.Operator, this.pop())
+			if err != nil {
+				return nil, diagnosticFor(exp, err)
+			}
+			this.push(res)
+		case OpCheckBool:
+			exp := chunk.nodes[offset].(*LogicalExpression)
+			operandExp := exp.Lhs
+			if operand == 1 {
+				operandExp = exp.Rhs
+			}
+			if _, err := logicalOperand(exp, operandExp, this.peek(0)); err != nil {
+				return nil, err
+			}
+		case OpShortCircuit:
+			exp := chunk.nodes[offset].(*LogicalExpression)
+			if shortCircuits(exp.Operator, this.peek(0).(Boolean)) {
+				ip = operand
+			} else {
+				this.pop()
+			}
+		case OpBranch:
+			boolean, err := condition(chunk.nodes[offset], this.pop())
+			if err != nil {
+				return nil, err
+			}
+			if !boolean {
+				ip = operand
+			}
+		case OpJump:
+			ip = operand
+		case OpJumpIfSet:
+			if this.peek(0) != nil {
+				ip = operand
+			} else {
+				this.pop()
+			}
+		case OpEnterLoop:
+			this.loops = append(this.loops, loopFrame{height: len(this.stack), scope: scope})
+		case OpExitLoop:
+			this.loops = this.loops[:len(this.loops)-1]
+		case OpLoop:
+			if err := this.context.Err(); err != nil {
+				return nil, diagnosticFor(chunk.nodes[offset], err)
+			}
+			ip = operand
+		case OpRange:
+			loop := chunk.nodes[offset].(*For)
+			if err := rangeBounds(loop, this.peek(1), this.peek(0)); err != nil {
+				return nil, err
+			}
+		case OpForIterate:
+			next, end := this.peek(1), this.peek(0)
+			if inRange(next, end) {
+				scope.slots[0] = next
+				this.stack[len(this.stack)-2] = rangeNext(next)
+			} else {
+				ip = operand
+			}
+		case OpBreak, OpContinue:
+			loop := this.loops[len(this.loops)-1]
+			this.truncate(loop.height)
+			scope = loop.scope
+			ip = operand
+		case OpEnterScope:
+			scope = &frame{slots: make([]Value, operand), parent: scope}
+		case OpExitScope:
+			scope = scope.parent
+		case OpClosure:
+			this.push(&BytecodeFunction{Function: chunk.Functions[operand], closure: scope})
+		case OpCallee:
+			if err := this.callee(chunk.nodes[offset].(*Call), operand); err != nil {
+				return nil, err
+			}
+		case OpCall:
+			arguments := operand
+			call := chunk.nodes[offset].(*Call)
+			base := len(this.stack) - arguments - 1
+			switch function := this.stack[base].(type) {
+			case *Builtin:
+				args := make([]Value, arguments)
+				copy(args, this.stack[base+1:])
+				res, err := function.Fn(this.context, args)
+				if err != nil {
+					return nil, diagnosticFor(call, err)
+				}
+				this.truncate(base)
+				this.push(res)
+			case *BytecodeFunction:
+				if len(this.calls) >= maxCallDepth {
+					return nil, diagnosticFor(call, fmt.Errorf("maximum call depth of %d exceeded", maxCallDepth))
+				}
+				callScope := &frame{slots: make([]Value, function.Function.Size), parent: function.closure}
+				copy(callScope.slots, this.stack[base+1:])
+				this.truncate(base)
+				this.calls = append(this.calls, callFrame{chunk: chunk, ip: ip, scope: scope})
+				chunk, code, ip, scope = &function.Function.Chunk, function.Function.Code, 0, callScope
+			}
+		case OpReturn:
+			if len(this.calls) == 0 {
+				return this.pop(), nil
+			}
+			caller := this.calls[len(this.calls)-1]
+			this.calls = this.calls[:len(this.calls)-1]
+			chunk, code, ip, scope = caller.chunk, caller.chunk.Code, caller.ip, caller.scope
+		case OpError:
+			return nil, chunk.Errors[operand]
+		case OpInterpolate:
+			base := len(this.stack) - operand
+			res := interpolate(this.stack[base:])
+			this.truncate(base)
+			this.push(res)
+		default:
+			return nil, fmt.Errorf("unknown opcode %s at %d", opcode, offset)
+		}
+	}
+}
+
+// binary applies the operator of exp, with a fast path for numbers.
+func (this *vm) binary(opcode Opcode, exp *BinaryExpression, lhs Value, rhs Value) (Value, error) {
+	if lhsNumber, ok := lhs.(Number); ok {
+		if rhsNumber, ok := rhs.(Number); ok {
+			switch opcode {
+			case OpAdd:
+				return lhsNumber + rhsNumber, nil
+			case OpSubtract:
+				return lhsNumber - rhsNumber, nil
+			case OpMultiply:
+				return lhsNumber * rhsNumber, nil
+			case OpLess:
+				return Boolean(lhsNumber < rhsNumber), nil
+			case OpLessEqual:
+				return Boolean(lhsNumber <= rhsNumber), nil
+			case OpGreater:
+				return Boolean(lhsNumber > rhsNumber), nil
+			case OpGreaterEqual:
+				return Boolean(lhsNumber >= rhsNumber), nil
+			}
+		}
+	}
+	res, err := BinaryOperation(exp.Operator, lhs, rhs)
+	if err != nil {
+		return nil, diagnosticFor(exp, err)
+	}
+	return res, nil
+}
+
+// callee checks the function on top of the stack can be called with that many
+// arguments, before they are evaluated.
+func (this *vm) callee(call *Call, arguments int) error {
+	if err := this.context.Err(); err != nil {
+		return diagnosticFor(call, err)
+	}
+	switch function := this.peek(0).(type) {
+	case *Builtin:
+		if err := function.CheckArity(arguments); err != nil {
+			return diagnosticFor(call, err)
+		}
+		return nil
+	case *BytecodeFunction:
+		if arguments != function.Function.Parameters {
+			return diagnosticFor(call, fmt.Errorf("%s expects %d arguments, got %d", function, function.Function.Parameters, arguments))
+		}
+		return nil
+	}
+	return diagnosticFor(call.Callee, fmt.Errorf("cannot call %s", this.peek(0).Type()))
+}
+
+func (this *vm) doubleDeclaration(exp Expression) error {
+	return diagnosticFor(exp, fmt.Errorf("double declaration of %s", localName(exp)))
+}
+
+func (this *vm) push(value Value) {
+	this.stack = append(this.stack, value)
+}
+
+func (this *vm) pop() Value {
+	value := this.stack[len(this.stack)-1]
+	this.stack = this.stack[:len(this.stack)-1]
+	return value
+}
+
+func (this *vm) peek(distance int) Value {
+	return this.stack[len(this.stack)-1-distance]
+}
+
+func (this *vm) truncate(length int) {
+	clear(this.stack[length:])
+	this.stack = this.stack[:length]
+}
+
+// up returns the scope depth levels above this one.
+func (this *frame) up(depth int) *frame {
+	for range depth {
+		this = this.parent
+	}
+	return this
+}
@@ -0,0 +1,58 @@
+package ast
+
+// Children returns the direct sub-expressions of exp, in source order.
+func Children(exp Expression) []Expression {
+	switch e := exp.(type) {
+	case *Program:
+		return e.Statements
+	case *Block:
+		return e.Statements
+	case *VarDeclaration:
+		return []Expression{&e.Operand}
+	case *Assignement:
+		return []Expression{&e.LHS, e.Rhs}
+	case *FunctionDeclaration:
+		res := []Expression{&e.Name}
+		for i := range e.Parameters {
+			res = append(res, &e.Parameters[i])
+		}
+		return append(res, e.Body)
+	case *Lambda:
+		res := make([]Expression, 0, len(e.Parameters)+1)
+		for i := range e.Parameters {
+			res = append(res, &e.Parameters[i])
+		}
+		return append(res, e.Body)
+	case *Call:
+		return append([]Expression{e.Callee}, e.Arguments...)
+	case *BinaryExpression:
+		return []Expression{e.Lhs, e.Rhs}
+	case *LogicalExpression:
+		return []Expression{e.Lhs, e.Rhs}
+	case *UnaryExpression:
+		return []Expression{e.Operand}
+	case *Interpolation:
+		return e.Parts
+	case *ConditionalExpression:
+		if e.Else == nil {
+			return []Expression{e.Condition, e.Then}
+		}
+		return []Expression{e.Condition, e.Then, e.Else}
+	case *While:
+		return []Expression{e.Condition, e.Body}
+	case *For:
+		return []Expression{&e.Variable, e.Start, e.End, e.Body}
+	}
+	return nil
+}
+
+// Walk calls fn for exp and then, depth first, for every node below it as long
+// as fn returns true.
+func Walk(exp Expression, fn func(Expression) bool) {
+	if exp == nil || !fn(exp) {
+		return
+	}
+	for _, child := range Children(exp) {
+		Walk(child, fn)
+	}
+}
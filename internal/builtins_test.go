@@ -0,0 +1,170 @@
+package internal_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+func TestEvaluateBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"sqrt(16)", 4},
+		{"pow(2, 10)", 1024},
+		{"abs(-3.5)", 3.5},
+		{"floor(2.7)", 2},
+		{"ceil(2.1)", 3},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(3.14159, 2)", 3.14},
+		{"min(4, 2, 8)", 2},
+		{"max(4, 2, 8)", 8},
+		{"max(1)", 1},
+		{"log(1000)", 3},
+		{"log(8, 2)", 3},
+		{"ln(e)", 1},
+		{"exp(0)", 1},
+		{"sin(0)", 0},
+		{"cos(pi)", -1},
+		{"tan(0)", 0},
+		{"atan2(1, 1) * 4", math.Pi},
+		{"hypot(3, 4)", 5},
+		{"2 * pi", 2 * math.Pi},
+		{"sqrt(pow(3, 2) + pow(4, 2))", 5},
+	}
+
+	for _, tc := range tests {
+		value, err := evaluate(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", tc.input, err)
+			continue
+		}
+		number, ok := ast.ToFloat(value)
+		if !ok {
+			t.Errorf("for '%s': expected number, got %s", tc.input, value.Type())
+			continue
+		}
+		if math.Abs(float64(number)-tc.expected) > 1e-9 {
+			t.Errorf("for '%s': expected %v, got %v", tc.input, tc.expected, number)
+		}
+	}
+}
+
+func TestEvaluateStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{`len("héllo")`, ast.NewInteger(5)},
+		{`len("")`, ast.NewInteger(0)},
+		{`upper("héllo")`, ast.String("HÉLLO")},
+		{`lower("ÉTÉ")`, ast.String("été")},
+		{`contains("haystack", "st")`, ast.Boolean(true)},
+		{`contains("haystack", "needle")`, ast.Boolean(false)},
+		{`split("a,b,,c", ",", 1)`, ast.String("b")},
+		{`split("a,b,,c", ",", 2)`, ast.String("")},
+		{`replace("a-b-c", "-", "+")`, ast.String("a+b+c")},
+		{`substr("héllo", 1)`, ast.String("éllo")},
+		{`substr("héllo", 1, 3)`, ast.String("éll")},
+		{`substr("abc", 3)`, ast.String("")},
+		{`number(" 2.5 ") * 2`, ast.Number(5)},
+		{`upper(substr("x=" + split("k:v", ":", 1), 2))`, ast.String("V")},
+	}
+	for _, tc := range tests {
+		value, err := evaluate(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", tc.input, err)
+			continue
+		}
+		if !sameValue(value, tc.expected) {
+			t.Errorf("for '%s': expected %q, got %q (%s)", tc.input, tc.expected, value, value.Type())
+		}
+	}
+}
+
+func TestEvaluateBuiltinAsValue(t *testing.T) {
+	value, err := evaluate("fn apply(f, x) { f(x) }", "apply(sqrt, 81)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Number(9) {
+		t.Errorf("expected 9, got %v", value)
+	}
+}
+
+func TestEvaluateShadowBuiltin(t *testing.T) {
+	value, err := evaluate("var max; max = 3", "fn pi() { 4 }", "max + pi()")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(7)) {
+		t.Errorf("expected 7, got %v", value)
+	}
+}
+
+func TestEvaluateBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"sqrt()", "sqrt expects 1 argument, got 0"},
+		{"sqrt(1, 2)", "sqrt expects 1 argument, got 2"},
+		{"pow(2)", "pow expects 2 arguments, got 1"},
+		{"round(1, 2, 3)", "round expects 1 to 2 arguments, got 3"},
+		{"min()", "min expects at least 1 argument, got 0"},
+		{"sqrt(true)", "sqrt expects a number as argument 1, got bool"},
+		{"max(1, 2, false)", "max expects a number as argument 3, got bool"},
+		{"pi(1)", "cannot call number"},
+		{"len(12)", "len expects a string as argument 1, got number"},
+		{`contains("a", true)`, "contains expects a string as argument 2, got bool"},
+		{`split("a,b", ",", 2)`, "split index 2 out of range for 2 fields"},
+		{`split("a,b", ",", 0.5)`, "split expects an integer as argument 3, got 0.5"},
+		{`substr("abc", 4)`, "substr start 4 out of range for 3 characters"},
+		{`substr("abc", 1, 3)`, "substr length 3 out of range for 3 characters from 1"},
+		{`substr("abc", "1")`, "substr expects an integer as argument 2, got string"},
+		{`number("12abc")`, `cannot convert "12abc" to number`},
+	}
+
+	for _, tc := range tests {
+		_, err := evaluate(tc.input)
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", tc.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("for '%s': expected error containing '%s', got '%v'", tc.input, tc.expected, err)
+		}
+	}
+}
+
+func TestBuiltinRegistry(t *testing.T) {
+	list := ast.Builtins()
+	names := make(map[string]bool)
+	for i, builtin := range list {
+		names[builtin.Name] = true
+		if i > 0 && list[i-1].Name >= builtin.Name {
+			t.Errorf("builtins are not sorted: '%s' before '%s'", list[i-1].Name, builtin.Name)
+		}
+		if builtin.Doc == "" {
+			t.Errorf("builtin '%s' has no documentation", builtin.Name)
+		}
+	}
+	for _, name := range []string{"sqrt", "pow", "abs", "floor", "ceil", "round", "min", "max", "log", "ln", "exp", "sin", "cos", "tan", "hypot",
+		"len", "upper", "lower", "contains", "split", "replace", "substr", "number"} {
+		if !names[name] {
+			t.Errorf("missing builtin '%s'", name)
+		}
+	}
+
+	if _, exist := ast.LookupBuiltin("sqrt"); !exist {
+		t.Error("expected to find 'sqrt'")
+	}
+	constants := ast.Constants()
+	if constants["pi"] != ast.Number(math.Pi) || constants["e"] != ast.Number(math.E) {
+		t.Errorf("unexpected constants %v", constants)
+	}
+}
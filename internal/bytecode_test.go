@@ -0,0 +1,183 @@
+package internal_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Helper to compile input to bytecode
+func bytecode(t *testing.T, input string) *ast.Bytecode {
+	t.Helper()
+	exp, err := internal.Parse(tokens(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	program, err := ast.CompileBytecode(exp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return program
+}
+
+func TestDisassemble(t *testing.T) {
+	listing := bytecode(t, "var rate\nfn scale(x) { x * rate }\nscale(4) > 5 && !false").Disassemble()
+	expected := []string{
+		"== <program> ==",
+		"0000    1:1  CONSTANT          0       ; 0",
+		"0003    1:1  DEFINE_GLOBAL     0       ; rate",
+		"0007    2:1  CLOSURE           0       ; <fn scale>",
+		"0014    3:1  GET_GLOBAL        1       ; scale",
+		"0017    3:1  CALLEE            1",
+		"0029    3:1  GREATER",
+		"0033    3:1  SHORT_CIRCUIT    43       ; -> 0043",
+		"0043    1:1  RETURN",
+		"== <fn scale> ==",
+		"0000   2:15  GET_LOCAL         0    0  ; x",
+		"0005   2:19  GET_GLOBAL        0       ; rate",
+		"0008   2:15  MULTIPLY",
+	}
+	for _, line := range expected {
+		if !strings.Contains(listing, line+"\n") {
+			t.Errorf("expected line %q in listing:\n%s", line, listing)
+		}
+	}
+}
+
+func TestDisassembleIntegerOperators(t *testing.T) {
+	listing := bytecode(t, "~(7 // 2 % 3 ** 2) & 1 | 2 ^ 3 << 4 >> 5").Disassemble()
+	for _, name := range []string{"INTEGER_DIVIDE", "MODULO", "POWER", "BIT_NOT", "BIT_AND", "BIT_OR", "BIT_XOR", "SHIFT_LEFT", "SHIFT_RIGHT"} {
+		if !strings.Contains(listing, "  "+name+"\n") {
+			t.Errorf("expected %s in listing:\n%s", name, listing)
+		}
+	}
+}
+
+func TestDisassembleLoop(t *testing.T) {
+	listing := bytecode(t, "for i in range(0, 3) { if i == 1 { continue }; break }").Disassemble()
+	expected := []string{
+		"0006    1:1  RANGE",
+		"0010    1:1  ENTER_LOOP",
+		"0011    1:1  FOR_ITERATE      51       ; -> 0051",
+		"0026   1:27  BRANCH           39       ; -> 0039",
+		"0032   1:36  CONTINUE         48       ; -> 0048",
+		"0043   1:48  BREAK            51       ; -> 0051",
+		"0048    1:1  LOOP             11       ; -> 0011",
+		"0051    1:1  EXIT_LOOP",
+	}
+	for _, line := range expected {
+		if !strings.Contains(listing, line+"\n") {
+			t.Errorf("expected line %q in listing:\n%s", line, listing)
+		}
+	}
+}
+
+func TestDisassembleUnsetLocals(t *testing.T) {
+	listing := bytecode(t, "fn f() { fn g() { 1 }; x = g(); x }").Disassemble()
+	expected := []string{
+		"0003   1:10  DEFINE_LOCAL      0       ; g",
+		"0018   1:24  GET_DECLARED      1       ; x",
+		"0021   1:24  JUMP_IF_SET      32       ; -> 0032",
+		"0024   1:24  SET_LOCAL         0    1  ; x",
+		"0033   1:24  SET_GLOBAL        1       ; x",
+		"0037   1:33  GET_LOCAL         0    1  ; x",
+		"0042   1:33  JUMP_IF_SET      48       ; -> 0048",
+		"0045   1:33  GET_GLOBAL        1       ; x",
+	}
+	for _, line := range expected {
+		if !strings.Contains(listing, line+"\n") {
+			t.Errorf("expected line %q in listing:\n%s", line, listing)
+		}
+	}
+}
+
+func TestBytecodeGlobals(t *testing.T) {
+	program := bytecode(t, "price * quantity")
+	globals := make([]ast.Value, len(program.Globals()))
+	slot, exist := program.Slot("quantity")
+	if !exist {
+		t.Fatalf("expected a slot for quantity in %v", program.Globals())
+	}
+	globals[slot] = ast.Number(3)
+	slot, _ = program.Slot("price")
+	globals[slot] = ast.Number(2.5)
+
+	value, err := program.Run(context.Background(), globals, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Number(7.5) {
+		t.Errorf("expected 7.5, got %v", value)
+	}
+	if _, err := program.Run(context.Background(), globals[:1], false); err == nil {
+		t.Error("expected an error for missing globals")
+	}
+}
+
+func TestBytecodeDeepRecursion(t *testing.T) {
+	value, err := bytecode(t, "fn sum(n) { n == 0 || sum(n - 1) }; sum(500)").Run(context.Background(), nil, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Boolean(true) {
+		t.Errorf("expected true, got %v", value)
+	}
+}
+
+func TestBytecodeCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := bytecode(t, "fn f() { f() }; f()").Run(ctx, nil, false)
+	if err == nil || err.Error() != "1:17: context canceled" {
+		t.Errorf("expected the call to be cancelled, got %v", err)
+	}
+}
+
+func TestBytecodeConcurrentRuns(t *testing.T) {
+	program := bytecode(t, "fn square(v) { v * v }; square(x) + 1")
+	slot, _ := program.Slot("x")
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			globals := make([]ast.Value, len(program.Globals()))
+			globals[slot] = ast.Number(i)
+			value, err := program.Run(context.Background(), globals, false)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if value != ast.Number(i*i+1) {
+				t.Errorf("expected %d, got %v", i*i+1, value)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+const recursion = "fn poly(x) { x * x * 3 + x * 2 - 1 }; fn walk(n) { n <= 0 || poly(n) > 0 && walk(n - 1) }; walk(500)"
+
+func BenchmarkTreeWalker(b *testing.B) {
+	exp, _ := internal.Parse(tokens(recursion))
+	for b.Loop() {
+		evaluator := ast.Evaluator{DisableOSEnv: true}
+		if _, err := evaluator.Evaluate(exp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBytecode(b *testing.B) {
+	exp, _ := internal.Parse(tokens(recursion))
+	program, _ := ast.CompileBytecode(exp)
+	for b.Loop() {
+		if _, err := program.Run(context.Background(), nil, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package internal_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Run with `go test -race` to catch shared state between lexers and parsers.
+
+func TestConcurrentTokenizeAndParse(t *testing.T) {
+	const goroutines = 64
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				input := fmt.Sprintf("(%d + x) * %d", g, i)
+				tokens, err := internal.Tokenize(input)
+				if err != nil {
+					errs <- fmt.Errorf("tokenize %q: %v", input, err)
+					return
+				}
+				if len(tokens) != 7 {
+					errs <- fmt.Errorf("tokenize %q: expected 7 tokens, got %d", input, len(tokens))
+					return
+				}
+				exp, err := internal.Parse(tokens)
+				if err != nil {
+					errs <- fmt.Errorf("parse %q: %v", input, err)
+					return
+				}
+				bin, ok := exp.(*ast.BinaryExpression)
+				if !ok {
+					errs <- fmt.Errorf("parse %q: expected BinaryExpression, got %T", input, exp)
+					return
+				}
+				rhs, ok := bin.Rhs.(*ast.CONSTANT)
+				if !ok || rhs.TokenLiteral.Literal != fmt.Sprint(i) {
+					errs <- fmt.Errorf("parse %q: wrong right operand %v", input, bin.Rhs)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentParseErrorsDoNotLeak(t *testing.T) {
+	const goroutines = 32
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				// Half of the goroutines feed invalid input; the other half
+				// must never observe their errors.
+				if g%2 == 0 {
+					if _, err := internal.Parse(tokens("(1+")); err == nil {
+						errs <- fmt.Errorf("expected error for '(1+'")
+						return
+					}
+					continue
+				}
+				if _, err := internal.Parse(tokens("1+2*3")); err != nil {
+					errs <- fmt.Errorf("unexpected error for '1+2*3': %v", err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLexerAndParserInstances(t *testing.T) {
+	tokens, err := internal.NewLexer("var x").Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp, err := internal.NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := exp.(*ast.VarDeclaration); !ok {
+		t.Fatalf("expected VarDeclaration, got %T", exp)
+	}
+}
@@ -0,0 +1,214 @@
+package internal_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Helper to run input through an evaluator in the given decimal mode.
+func evaluateDecimal(mode ast.DecimalMode, input string) (ast.Value, error) {
+	exp, err := internal.Parse(tokens(input))
+	if err != nil {
+		return nil, err
+	}
+	evaluator := ast.Evaluator{Decimal: &mode}
+	return evaluator.Evaluate(exp)
+}
+
+func TestEvaluateDecimalMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0.1 + 0.2", "0.3"},
+		{"0.1 + 0.2 == 0.3", "true"},
+		{"1.10 * 3", "3.3"},
+		{"1 - 0.9", "0.1"},
+		{"-0.1 - 0.2", "-0.3"},
+		{"0.3 > 0.1 + 0.2", "false"},
+		{"1e3 + 0.001", "1000.001"},
+		{"0x10 + 1_000.5 + 25e-2", "1016.75"},
+		{"123456789012345678901234567890 + 1", "123456789012345678901234567891"},
+		{"10 / 4", "2.5"},
+		{"10 / 3", "3.33"},
+		{"2 / 3", "0.67"},
+		{"1.2345 / 1", "1.23"},
+		{"var s; for i in range(0, 10) { s = s + 0.1 }; s", "1"},
+		{"var n; for i in range(0.5, 3) { n = i }; n", "2.5"},
+		{`"total: ${0.1 + 0.2}"`, "total: 0.3"},
+		{"round(2.345, 2)", "2.35"},
+		{"round(-2.5)", "-3"},
+		{"round(1234.5, -2)", "1200"},
+		{"floor(-1.5) + ceil(1.2) + trunc(-1.7) + abs(-0.1)", "-0.9"},
+		{"min(0.3, 0.1 + 0.2, 0.4)", "0.3"},
+		{"max(0.1, 0.2)", "0.2"},
+		{"7.5 // 2", "3"},
+		{"-7.5 // 2", "-4"},
+		{"-7.5 % 2", "0.5"},
+		{"0.3 % 0.1", "0"},
+		{"1.1 ** 2", "1.21"},
+		{"2 ** -2", "0.25"},
+		{"2 ** -3", "0.12"},
+		{"4 ** 0.5", "2"},
+		{"2 ** 0.5", "1.41"},
+		{"0.5 ** -0.5", "1.41"},
+		{"0xff & 0x0f", "15"},
+		{"6 | 1", "7"},
+	}
+	for _, test := range tests {
+		value, err := evaluateDecimal(ast.DecimalMode{Scale: 2}, test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if value.String() != test.expected {
+			t.Errorf("expected %s for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateDecimalRounding(t *testing.T) {
+	tests := []struct {
+		input    string
+		rounding ast.Rounding
+		expected string
+	}{
+		{"2.345 / 1", ast.RoundHalfEven, "2.34"},
+		{"4.69 / 2", ast.RoundHalfEven, "2.34"},
+		{"4.71 / 2", ast.RoundHalfEven, "2.36"},
+		{"4.69 / 2", ast.RoundHalfUp, "2.35"},
+		{"0.125 / 1.00", ast.RoundHalfEven, "0.12"},
+		{"1.000 / 3", ast.RoundHalfEven, "0.33"},
+		{"1 / 8", ast.RoundHalfEven, "0.12"},
+		{"3 / 8", ast.RoundHalfEven, "0.38"},
+		{"-1 / 8", ast.RoundHalfEven, "-0.12"},
+		{"1 / 8", ast.RoundHalfUp, "0.13"},
+		{"-1 / 8", ast.RoundHalfUp, "-0.13"},
+		{"2 / 3", ast.RoundHalfUp, "0.67"},
+		{"2 / 3", ast.RoundDown, "0.66"},
+		{"-2 / 3", ast.RoundDown, "-0.66"},
+		{"7 / 8", ast.RoundDown, "0.87"},
+		{"sqrt(2)", ast.RoundHalfEven, "1.41"},
+		{"exp(1)", ast.RoundHalfUp, "2.72"},
+		{"exp(1)", ast.RoundDown, "2.71"},
+		{"5 ** 0.5", ast.RoundHalfUp, "2.24"},
+		{"5 ** 0.5", ast.RoundDown, "2.23"},
+	}
+	for _, test := range tests {
+		value, err := evaluateDecimal(ast.DecimalMode{Scale: 2, Rounding: test.rounding}, test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if value.String() != test.expected {
+			t.Errorf("expected %s for '%s' rounding %s, got %v", test.expected, test.input, test.rounding, value)
+		}
+	}
+}
+
+func TestEvaluateDecimalScale(t *testing.T) {
+	tests := []struct {
+		scale    int
+		expected string
+	}{
+		{0, "1"},
+		{1, "0.7"},
+		{4, "0.6667"},
+		{20, "0.66666666666666666667"},
+	}
+	for _, test := range tests {
+		value, err := evaluateDecimal(ast.DecimalMode{Scale: test.scale}, "2 / 3")
+		if err != nil {
+			t.Errorf("unexpected error for scale %d: %v", test.scale, err)
+			continue
+		}
+		if value.String() != test.expected {
+			t.Errorf("expected %s for scale %d, got %v", test.expected, test.scale, value)
+		}
+	}
+}
+
+func TestEvaluateDecimalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "1:1: division by zero"},
+		{"1 / (0.5 - 0.5)", "1:1: division by zero"},
+		{"0.5 + true", "1:1: cannot add number and bool"},
+		{`1.5 < "2"`, "1:1: cannot compare number and string"},
+		{"round(1.5, 0.5)", "1:1: round expects an integer as argument 2, got 0.5"},
+		{"1 % 0.0", "1:1: division by zero"},
+		{"0.5 & 1", "1:1: operands of '&' must be integers, got decimal 0.5"},
+		{"1.0 | 1", "1:1: operands of '|' must be integers, got decimal 1.0"},
+		{"1 << 2.00", "1:1: operands of '<<' must be integers, got decimal 2.0"},
+	}
+	for _, test := range tests {
+		_, err := evaluateDecimal(ast.DecimalMode{Scale: 2}, test.input)
+		if err == nil {
+			t.Errorf("expected an error for '%s'", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%v'", test.expected, test.input, err)
+		}
+	}
+}
+
+func TestEvaluateDecimalKeepsFloatsElsewhere(t *testing.T) {
+	value, err := evaluate("0.1 + 0.2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value.String() != "0.30000000000000004" {
+		t.Errorf("expected 0.30000000000000004 without the decimal mode, got %v", value)
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"0", "0"},
+		{"1.50", "1.5"},
+		{"-0.001", "-0.001"},
+		{"2.5e3", "2500"},
+		{"25E-4", "0.0025"},
+		{"+7", "7"},
+	}
+	for _, test := range tests {
+		decimal, err := ast.ParseDecimal(test.text)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.text, err)
+			continue
+		}
+		if decimal.String() != test.expected {
+			t.Errorf("expected %s for '%s', got %v", test.expected, test.text, decimal)
+		}
+		if decimal.Type() != ast.NumberType {
+			t.Errorf("expected number type for '%s', got %s", test.text, decimal.Type())
+		}
+	}
+	for _, text := range []string{"", ".", "1.2.3", "1e", "1e99999", "abc", "1.-2"} {
+		if _, err := ast.ParseDecimal(text); err == nil {
+			t.Errorf("expected an error for '%s'", text)
+		}
+	}
+}
+
+func TestParseRounding(t *testing.T) {
+	for _, rounding := range []ast.Rounding{ast.RoundHalfEven, ast.RoundHalfUp, ast.RoundDown} {
+		parsed, err := ast.ParseRounding(rounding.String())
+		if err != nil || parsed != rounding {
+			t.Errorf("expected %s to parse back, got %v, %v", rounding, parsed, err)
+		}
+	}
+	_, err := ast.ParseRounding("up")
+	if err == nil || !strings.Contains(err.Error(), "half-even, half-up, down") {
+		t.Errorf("expected the roundings to be listed, got %v", err)
+	}
+}
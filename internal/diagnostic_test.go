@@ -0,0 +1,64 @@
+package internal_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+func TestDiagnosticRender(t *testing.T) {
+	source := "var x\nx = 1 + true"
+	_, err := evaluate(source)
+	var diagnostic *ast.Diagnostic
+	if !errors.As(err, &diagnostic) {
+		t.Fatalf("expected *ast.Diagnostic, got %T: %v", err, err)
+	}
+	expected := "cannot add number and bool\n" +
+		" --> 2:5\n" +
+		"  |\n" +
+		"2 | x = 1 + true\n" +
+		"  |     ^^^^^^^^\n"
+	if rendered := diagnostic.Render(source); rendered != expected {
+		t.Errorf("unexpected rendering:\n%s\nexpected:\n%s", rendered, expected)
+	}
+}
+
+func TestDiagnosticRenderKeepsTabs(t *testing.T) {
+	source := "\t1 + @"
+	_, err := internal.Tokenize(source)
+	var diagnostic *ast.Diagnostic
+	if !errors.As(err, &diagnostic) {
+		t.Fatalf("expected *ast.Diagnostic, got %T: %v", err, err)
+	}
+	expected := "unrecognized character '@'\n" +
+		" --> 1:6\n" +
+		"  |\n" +
+		"1 | \t1 + @\n" +
+		"  | \t    ^\n"
+	if rendered := diagnostic.Render(source); rendered != expected {
+		t.Errorf("unexpected rendering:\n%q\nexpected:\n%q", rendered, expected)
+	}
+}
+
+func TestDiagnosticRenderWithoutPosition(t *testing.T) {
+	diagnostic := &ast.Diagnostic{Message: "empty input"}
+	if rendered := diagnostic.Render(""); rendered != "empty input\n" {
+		t.Errorf("unexpected rendering: %q", rendered)
+	}
+	if diagnostic.Error() != "empty input" {
+		t.Errorf("unexpected error message: %q", diagnostic.Error())
+	}
+}
+
+func TestEvaluateErrorSpan(t *testing.T) {
+	_, err := evaluate("var total\ntotal = price * 2")
+	var diagnostic *ast.Diagnostic
+	if !errors.As(err, &diagnostic) {
+		t.Fatalf("expected *ast.Diagnostic, got %T: %v", err, err)
+	}
+	if diagnostic.Span.Start != (ast.Position{Offset: 18, Line: 2, Column: 9}) {
+		t.Errorf("expected error on 'price', got %+v", diagnostic.Span.Start)
+	}
+}
@@ -0,0 +1,1104 @@
+package internal_test
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Helper to run every input through a fresh evaluator, returning the value of
+// the last one. The inputs are also run as a single compiled program, which
+// must agree with the evaluator.
+func evaluate(inputs ...string) (ast.Value, error) {
+	evaluator := ast.Evaluator{}
+	var statements []ast.Expression
+	var value ast.Value
+	for _, input := range inputs {
+		exp, err := internal.Parse(tokens(input))
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, exp)
+		value, err = evaluator.Evaluate(exp)
+		if err != nil {
+			return nil, compare(statements, value, err)
+		}
+	}
+	return value, compare(statements, value, nil)
+}
+
+// compare runs statements as a compiled program and as bytecode, returning err
+// when both agree with the evaluator
+func compare(statements []ast.Expression, value ast.Value, err error) error {
+	program := &ast.Program{Statements: statements}
+	bytecode, compileErr := ast.CompileBytecode(program)
+	if compileErr != nil {
+		return compileErr
+	}
+	backends := map[string]func(context.Context, []ast.Value, bool) (ast.Value, error){
+		"compiled program": ast.Compile(program).Run,
+		"bytecode":         bytecode.Run,
+	}
+	for name, run := range backends {
+		backendValue, backendErr := run(context.Background(), nil, true)
+		if err != nil || backendErr != nil {
+			if err == nil || backendErr == nil || err.Error() != backendErr.Error() {
+				return fmt.Errorf("%s disagrees: evaluator %v, %s %v", name, err, name, backendErr)
+			}
+			continue
+		}
+		if value.Type() != backendValue.Type() || value.String() != backendValue.String() {
+			return fmt.Errorf("%s disagrees: evaluator %v, %s %v", name, value, name, backendValue)
+		}
+	}
+	return err
+}
+
+// sameValue reports whether value equals expected and is of the same kind, an
+// integer not being the same as a float.
+func sameValue(value ast.Value, expected ast.Value) bool {
+	return reflect.TypeOf(value) == reflect.TypeOf(expected) && ast.Equal(value, expected)
+}
+
+func TestEvaluateNumber(t *testing.T) {
+	value, err := evaluate("1+2*3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(7)) {
+		t.Errorf("expected 7, got %v", value)
+	}
+	if value.Type() != ast.NumberType {
+		t.Errorf("expected number type, got %s", value.Type())
+	}
+}
+
+func TestEvaluateIgnoresComments(t *testing.T) {
+	value, err := evaluate("# price in cents\nvar price # set below\nprice = 2 /* units */ * 21", "price /* done */")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(42)) {
+		t.Errorf("expected 42, got %v", value)
+	}
+}
+
+func TestEvaluateDecimal(t *testing.T) {
+	value, err := evaluate("3.5/2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value.String() != "1.75" {
+		t.Errorf("expected '1.75', got '%s'", value.String())
+	}
+}
+
+func TestEvaluateCompoundAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"var x; x += 5; x", ast.NewInteger(5)},
+		{"var x; x = 10; x -= 3; x *= 2; x", ast.NewInteger(14)},
+		{"var x; x = 10; x /= 4", ast.NewRational(5, 2)},
+		{"var x; x = 1; x += 0.5", ast.Number(1.5)},
+		{`var s; s = "a"; s += "b"; s`, ast.String("ab")},
+		{"var i; var total; while i < 4 { i += 1; total += i }; total", ast.NewInteger(10)},
+		{"var c; fn inc() { c += 1 }; inc(); inc(); c", ast.NewInteger(2)},
+		{"fn f() { var n; n = 2; n *= n; n }; f()", ast.NewInteger(4)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateAssignmentExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"y = x = 3; x + y", ast.NewInteger(6)},
+		{"var x; (x = 2) * 3", ast.NewInteger(6)},
+		{"var x; var y; y = x += 4; y", ast.NewInteger(4)},
+		{"var n; var i; while (i += 1) <= 3 { n = i }; n", ast.NewInteger(3)},
+		{"var last; fn f(x) { x }; f(last = 7) + last", ast.NewInteger(14)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+
+	// An assignment that is not evaluated leaves its identifier undeclared
+	failures := []struct {
+		input    string
+		expected string
+	}{
+		{"{ true || (x = 1); x + 1 }", "1:20: undeclared identifier x"},
+		{"{ true || (x = 1); -x }", "1:21: undeclared identifier x"},
+		{"{ true || (x = 1); 'a${x}' }", "1:24: undeclared identifier x"},
+		{"{ if false then (x = 1) else 0; x }", "1:33: undeclared identifier x"},
+	}
+	for _, test := range failures {
+		_, err := evaluate(test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%v'", test.expected, test.input, err)
+		}
+	}
+}
+
+func TestEvaluateCompoundAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var x; x += true", "1:8: cannot add number and bool"},
+		{`var s; s = "a"; s -= "b"`, "1:17: cannot subtract string and string"},
+		{"var x; x = 1; x /= 0", "1:15: division by zero"},
+		{"undeclared_counter_for_test += 1", "undeclared identifier"},
+	}
+	for _, test := range tests {
+		_, err := evaluate(test.input)
+		if err == nil {
+			t.Errorf("expected an error for '%s'", test.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected '%s' for '%s', got '%v'", test.expected, test.input, err)
+		}
+	}
+}
+
+func TestEvaluateNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"0xff + 0b1", ast.NewInteger(256)},
+		{"0o17 - 0XF", ast.NewInteger(0)},
+		{"1_000 * 2", ast.NewInteger(2000)},
+		{"0x7fff_ffff_ffff_ffff + 1", ast.NewIntegerFromBig(new(big.Int).Lsh(big.NewInt(1), 63))},
+		{"1.5e-3", ast.Number(0.0015)},
+		{"1e+21", ast.Number(1e21)},
+		{"2.5E3 == 2500", ast.Boolean(true)},
+		{"1_000.000_5", ast.Number(1000.0005)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateIntegerOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"7 // 2", ast.NewInteger(3)},
+		{"-7 // 2", ast.NewInteger(-4)},
+		{"7 % 3", ast.NewInteger(1)},
+		{"-7 % 3", ast.NewInteger(2)},
+		{"7 % -3", ast.NewInteger(-2)},
+		{"(7 / 2) % 1", ast.NewRational(1, 2)},
+		{"7.5 // 2", ast.Number(3)},
+		{"-7.5 % 2", ast.Number(0.5)},
+		{"5.5 % -2", ast.Number(-0.5)},
+		{"2 ** 10", ast.NewInteger(1024)},
+		{"2 ** 3 ** 2", ast.NewInteger(512)},
+		{"-2 ** 2", ast.NewInteger(-4)},
+		{"(-2) ** 3", ast.NewInteger(-8)},
+		{"2 ** -2", ast.NewRational(1, 4)},
+		{"(2 / 3) ** 2", ast.NewRational(4, 9)},
+		{"1 ** 100000000000000000000", ast.NewInteger(1)},
+		{"4 ** 0.5", ast.Number(2)},
+		{"2.0 ** 3", ast.Number(8)},
+		{"2 ** 100 == 1 << 100", ast.Boolean(true)},
+		{"0xf0 & 0x3c", ast.NewInteger(0x30)},
+		{"0xf0 | 0x0f", ast.NewInteger(0xff)},
+		{"0b1100 ^ 0b1010", ast.NewInteger(0b0110)},
+		{"~5", ast.NewInteger(-6)},
+		{"-8 >> 1", ast.NewInteger(-4)},
+		{"-1 >> 1000", ast.NewInteger(-1)},
+		{"1 >> 100000000000000000000", ast.NewInteger(0)},
+		{"1 << 3 | 1", ast.NewInteger(9)},
+		{"6 & 3 == 2", ast.Boolean(true)},
+		{"var flags; flags = 0b101; flags & ~0b100", ast.NewInteger(1)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateIntegerOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 // 0", "1:1: division by zero"},
+		{"1 % 0", "1:1: division by zero"},
+		{"0 ** -1", "1:1: division by zero"},
+		{"1.5 & 1", "1:1: operands of '&' must be integers, got float 1.5"},
+		{"1 | true", "1:1: operands of '|' must be integers, got bool"},
+		{`"a" ^ "b"`, "1:1: operands of '^' must be integers, got string"},
+		{"1 << 0.0", "1:1: operands of '<<' must be integers, got float 0.0"},
+		{"1.0 | 2", "1:1: operands of '|' must be integers, got float 1.0"},
+		{"~(1 / 2)", "1:1: operand of '~' must be an integer, got rational 1/2"},
+		{"1 >> -1", "1:1: negative shift count -1"},
+		{"1 << 100000000", "1:1: 1 << 100000000 is too large"},
+		{"10 ** 1000000000", "1:1: 10 ** 1000000000 is too large"},
+		{"true % 2", "1:1: cannot apply '%' to bool and number"},
+	}
+	for _, test := range tests {
+		_, err := evaluate(test.input)
+		if err == nil {
+			t.Errorf("expected an error for '%s'", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%v'", test.expected, test.input, err)
+		}
+	}
+}
+
+func TestEvaluateExactNumbers(t *testing.T) {
+	large, _ := new(big.Int).SetString("123456789012345678901234567891", 10)
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"123456789012345678901234567890 + 1", ast.NewIntegerFromBig(large)},
+		{"1 / 3", ast.NewRational(1, 3)},
+		{"10 / 4", ast.NewRational(5, 2)},
+		{"1 / 3 + 1 / 6", ast.NewRational(1, 2)},
+		{"1 / 3 * 3", ast.NewInteger(1)},
+		{"-(2 / 4)", ast.NewRational(-1, 2)},
+		{"1 / 3 == 2 / 6", ast.Boolean(true)},
+		{"0.5 == 1 / 2", ast.Boolean(true)},
+		{"1 / 3 < 0.34", ast.Boolean(true)},
+		{"9007199254740993 > 9007199254740992.0", ast.Boolean(true)},
+		{"2 * 3.0", ast.Number(6)},
+		{"1 / 4 + 0.5", ast.Number(0.75)},
+		{"float(1 / 4)", ast.Number(0.25)},
+		{"float(2)", ast.Number(2)},
+		{"floor(7 / 2) + ceil(-7 / 2)", ast.NewInteger(0)},
+		{"trunc(-7 / 2)", ast.NewInteger(-3)},
+		{"round(5 / 2) + round(-5 / 2)", ast.NewInteger(0)},
+		{"round(1 / 3, 2)", ast.NewRational(33, 100)},
+		{"round(1250, -2)", ast.NewInteger(1300)},
+		{"abs(-1 / 3)", ast.NewRational(1, 3)},
+		{"min(1 / 2, 1 / 3, 1)", ast.NewRational(1, 3)},
+		{"max(1, 2.5)", ast.Number(2.5)},
+		{`len("ab") / 4`, ast.NewRational(1, 2)},
+		{"var s; for i in range(0, 3) { s = s + 1 / 3 }; s", ast.NewInteger(1)},
+		{"var n; for i in range(0, 5 / 2) { n = i }; n", ast.NewInteger(2)},
+		{`"${1 / 3}"`, ast.String("1/3")},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v (%T) for '%s', got %v (%T)", test.expected, test.expected, test.input, value, value)
+		}
+	}
+}
+
+func TestEvaluateExactNumberErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "1:1: division by zero"},
+		{"1 / (2 - 2)", "1:1: division by zero"},
+		{"float(true)", "1:1: float expects a number as argument 1, got bool"},
+		{"round(1 / 2, 1 / 2)", "1:1: round expects an integer as argument 2, got 1/2"},
+	}
+	for _, test := range tests {
+		_, err := evaluate(test.input)
+		if err == nil {
+			t.Errorf("expected an error for '%s'", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%v'", test.expected, test.input, err)
+		}
+	}
+	value, err := evaluate("1.0 / 0")
+	if err != nil || value.String() != "+Inf" {
+		t.Errorf("expected floats to divide by zero to +Inf, got %v, %v", value, err)
+	}
+}
+
+func TestEvaluateBoolean(t *testing.T) {
+	value, err := evaluate("true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Boolean(true) {
+		t.Errorf("expected true, got %v", value)
+	}
+	if value.Type() != ast.BooleanType {
+		t.Errorf("expected bool type, got %s", value.Type())
+	}
+}
+
+func TestEvaluateDoubleUnaryMinus(t *testing.T) {
+	value, err := evaluate("--5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(5)) {
+		t.Errorf("expected 5, got %v", value)
+	}
+}
+
+func TestEvaluateVariables(t *testing.T) {
+	value, err := evaluate("var x", "x = 4", "x * x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(16)) {
+		t.Errorf("expected 16, got %v", value)
+	}
+}
+
+func TestEvaluateBooleanVariable(t *testing.T) {
+	value, err := evaluate("var flag", "flag = false", "flag")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Boolean(false) {
+		t.Errorf("expected false, got %v", value)
+	}
+}
+
+func TestEvaluateEnvironmentVariable(t *testing.T) {
+	t.Setenv("EVAL_TEST_RATE", "0.5")
+	value, err := evaluate("number(EVAL_TEST_RATE) * 4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Number(2) {
+		t.Errorf("expected 2, got %v", value)
+	}
+}
+
+func TestEvaluateEnvironmentVariableIsRaw(t *testing.T) {
+	t.Setenv("EVAL_TEST_RATE", "0.5")
+	value, err := evaluate("EVAL_TEST_RATE")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.String("0.5") {
+		t.Errorf("expected '0.5', got %v", value)
+	}
+}
+
+func TestEvaluateEnvironmentVariableString(t *testing.T) {
+	t.Setenv("EVAL_TEST_USER", "ada")
+	value, err := evaluate(`"hello " + EVAL_TEST_USER`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.String("hello ada") {
+		t.Errorf("expected 'hello ada', got %v", value)
+	}
+}
+
+func TestEvaluateStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{`"abc"`, ast.String("abc")},
+		{`'it\'s' + " " + "\"ok\""`, ast.String(`it's "ok"`)},
+		{`"tab\there\n"`, ast.String("tab\there\n")},
+		{`"\u00e9t\u00E9"`, ast.String("été")},
+		{`"" + ""`, ast.String("")},
+		{`"a" == 'a'`, ast.Boolean(true)},
+		{`"a" != "b"`, ast.Boolean(true)},
+		{`"1" == 1`, ast.Boolean(false)},
+		{`"apple" < "banana"`, ast.Boolean(true)},
+		{`"b" >= "ab"`, ast.Boolean(true)},
+		{`"Z" > "a"`, ast.Boolean(false)},
+		{`var name; name = "Ada"; "hi " + name`, ast.String("hi Ada")},
+		{`fn twice(s) { s + s }; twice("ab")`, ast.String("abab")},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("expected %q for '%s', got %q (%s)", test.expected, test.input, value, value.Type())
+		}
+	}
+}
+
+func TestEvaluateInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"1 + 2 = ${1 + 2}"`, "1 + 2 = 3"},
+		{`var n; n = 0.5; 'n is ${n}, ${n > 0}'`, "n is 0.5, true"},
+		{`"${"nested ${"a" + 'b'}"}"`, "nested ab"},
+		{`fn f(x) { x * 2 }; "${f}: ${f(21)}"`, "<fn f>: 42"},
+		{`"\${not} $ {x} $"`, "${not} $ {x} $"},
+		{`"${(fn() { var a; a = 3; a })()}"`, "3"},
+		{`var s; s = "x"; "[${s}${s}]"`, "[xx]"},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if value != ast.String(test.expected) {
+			t.Errorf("expected %q for '%s', got %q", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateStringTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"1" + 1`, "1:1: cannot add string and number"},
+		{`1 + "1"`, "1:1: cannot add number and string"},
+		{`"ab" - "b"`, "1:1: cannot subtract string and string"},
+		{`"a" * 2`, "1:1: cannot multiply string and number"},
+		{`"a" < 1`, "1:1: cannot compare string and number"},
+		{`-"a"`, "1:1: cannot negate string"},
+		{`!"a"`, "1:1: cannot apply '!' to string"},
+		{`"a" && true`, "1:1: operands of '&&' must be bool, got string"},
+		{`"x ${undefined_name} y"`, "1:6: undeclared identifier undefined_name"},
+	}
+	for _, test := range tests {
+		_, err := evaluate(test.input)
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", test.expected, test.input, err.Error())
+		}
+	}
+}
+
+func TestEvaluateConditional(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"var qty; qty = 150; if qty > 100 then 10 * 0.9 else 10", ast.Number(9)},
+		{"var qty; qty = 50; qty > 100 ? 10 * 0.9 : 10", ast.NewInteger(10)},
+		{"if true then 1 else 2 + 3", ast.NewInteger(1)},
+		{"if false then 1 else 2 + 3", ast.NewInteger(5)},
+		{"(if false then 1 else 2) + 3", ast.NewInteger(5)},
+		{`fn grade(n) { n >= 90 ? "A" : n >= 80 ? "B" : "C" }; grade(95) + grade(85) + grade(10)`, ast.String("ABC")},
+		{"var fact; fact = fn(n) => if n <= 1 then 1 else n * fact(n - 1); fact(5)", ast.NewInteger(120)},
+		{"true ? false ? 1 : 2 : 3", ast.NewInteger(2)},
+		{"1 < 2 && 2 < 3 ? 1 : 0", ast.NewInteger(1)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateConditionalIsLazy(t *testing.T) {
+	value, err := evaluate("var calls; calls = 0", "fn hit() { calls = calls + 1 }",
+		"true ? 1 : hit(); if false then undefined_name else 2; false ? 1 + true : hit()", "calls")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(1)) {
+		t.Errorf("expected a single branch to be evaluated, got %v calls", value)
+	}
+}
+
+func TestEvaluateConditionStrict(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 ? 2 : 3", "1:1: condition must be bool, got number"},
+		{`var s; s = ""; if s then 1 else 2`, "1:19: condition must be bool, got string"},
+		{"fn f() {}; f() ? 1 : 2", "1:12: condition must be bool, got nil"},
+		{"true ? 1 + true : 2", "1:8: cannot add number and bool"},
+	}
+	for _, test := range tests {
+		_, err := evaluate(test.input)
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", test.expected, test.input, err.Error())
+		}
+	}
+}
+
+func TestEvaluateIfBlock(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"var x; x = 5; if x > 3 { x = 1 } else { x = 2 }; x", ast.NewInteger(1)},
+		{"var x; x = 5; if x > 9 { 1 } else if x > 3 { 2 } else { 3 }", ast.NewInteger(2)},
+		{"if false { 1 }", ast.Nil{}},
+		{"if true { var y; y = 4; y * 2 }", ast.NewInteger(8)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+// Loops and blocks
+
+func TestEvaluateLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"var i; var sum; while i < 5 { sum = sum + i; i = i + 1 }; sum", ast.NewInteger(10)},
+		{"var sum; for i in range(0, 5) { sum = sum + i }; sum", ast.NewInteger(10)},
+		{"var sum; for i in range(3, 1) { sum = sum + 1 }; sum", ast.NewInteger(0)},
+		{"var n; for i in range(0.5, 3) { n = i }; n", ast.Number(2.5)},
+		{"while false {}", ast.Nil{}},
+		{"for i in range(0, 3) { i }", ast.Nil{}},
+		{"var n; n = 3; var fact; fact = 1; while n > 1 { fact = fact * n; n = n - 1 }; fact", ast.NewInteger(6)},
+		{"fn sum(n) { var total; for i in range(1, n + 1) { total = total + i }; total }; sum(100)", ast.NewInteger(5050)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateLoopControl(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"var i; while true { i = i + 1; if i == 4 { break } }; i", ast.NewInteger(4)},
+		{"var sum; for i in range(0, 10) { if i > 4 { continue }; sum = sum + i }; sum", ast.NewInteger(10)},
+		{"var last; for i in range(0, 10) { last = i; if i == 3 { break } }; last", ast.NewInteger(3)},
+		// break and continue only leave the innermost loop
+		{`var s; s = ""
+for i in range(0, 3) {
+	for j in range(0, 3) {
+		if j > i { break }
+		if j == 1 { continue }
+		s = s + "${i}${j} "
+	}
+	if i == 1 { continue }
+	s = s + "| "
+}
+s`, ast.String("00 | 10 20 22 | ")},
+		{"var n; var i; while i < 3 { i = i + 1; var j; j = 0; while true { j = j + 1; n = n + 1; if j == i { break } } }; n", ast.NewInteger(6)},
+		// In the middle of an expression, with values on the stack
+		{"var sum; for i in range(0, 5) { sum = sum + (if i == 2 { continue } else { i }) }; sum", ast.NewInteger(8)},
+		{"var sum; for i in range(0, 5) { sum = sum + 1 + (i < 3 ? 1 : (if true { break } else { 0 })) }; sum", ast.NewInteger(6)},
+		// Inside nested blocks
+		{"var i; while true { { { i = i + 1; if i >= 3 { break } } } }; i", ast.NewInteger(3)},
+		// A function called in a loop runs loops of its own
+		{"fn first(n) { var res; for i in range(0, n) { res = i; break }; res }; var sum; for i in range(0, 4) { sum = sum + first(i + 1) + 1; if i == 2 { break } }; sum", ast.NewInteger(3)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateBlockScope(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"var x; x = 1; { var x; x = 2 }; x", ast.NewInteger(1)},
+		{"var x; x = 1; { x = 2 }; x", ast.NewInteger(2)},
+		{"var x; x = 1; { var y; y = x + 1; y }", ast.NewInteger(2)},
+		{"{ var x; x = 1 }; { var x; x = 2 }", ast.NewInteger(2)},
+		// Each iteration has a scope of its own
+		{"var n; for i in range(0, 3) { var x; x = i; n = n + x }; n", ast.NewInteger(3)},
+		{"var fns; var f; for i in range(0, 3) { if i == 1 { f = fn() => i } }; f()", ast.NewInteger(2)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+}
+
+func TestEvaluateBlockLocalsDoNotLeak(t *testing.T) {
+	_, err := evaluate("{ var hidden; hidden = 1 }", "hidden")
+	if err == nil || !strings.Contains(err.Error(), "undeclared identifier hidden") {
+		t.Errorf("expected hidden to be undeclared, got %v", err)
+	}
+}
+
+func TestEvaluateLoopErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while 1 {}", "1:7: condition must be bool, got number"},
+		{"var i; while i < 2 { i = i + 1 }; while i {}", "1:41: condition must be bool, got number"},
+		{`for i in range("a", 2) {}`, "1:16: range bounds must be numbers, got string"},
+		{"for i in range(0, true) {}", "1:19: range bounds must be numbers, got bool"},
+		{"for i in range(0, 3) { i + true }", "1:24: cannot add number and bool"},
+	}
+	for _, test := range tests {
+		_, err := evaluate(test.input)
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", test.expected, test.input, err.Error())
+		}
+	}
+}
+
+func TestEvaluateLoopCancelled(t *testing.T) {
+	for _, input := range []string{"while true {}", "while true { continue }", "for i in range(0, 1e18) {}"} {
+		exp, err := internal.Parse(tokens(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		evaluator := ast.Evaluator{}
+		if _, err := evaluator.EvaluateContext(ctx, exp); err == nil || err.Error() != "1:1: context canceled" {
+			t.Errorf("expected '%s' to be cancelled, got %v", input, err)
+		}
+		program := &ast.Program{Statements: []ast.Expression{exp}}
+		if _, err := ast.Compile(program).Run(ctx, nil, false); err == nil || err.Error() != "1:1: context canceled" {
+			t.Errorf("expected the compiled '%s' to be cancelled, got %v", input, err)
+		}
+		bytecode, err := ast.CompileBytecode(program)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := bytecode.Run(ctx, nil, false); err == nil || err.Error() != "1:1: context canceled" {
+			t.Errorf("expected the bytecode of '%s' to be cancelled, got %v", input, err)
+		}
+	}
+}
+
+// Type errors
+
+func TestEvaluateAddBoolAndNumber(t *testing.T) {
+	_, err := evaluate("true + 1")
+	if err == nil {
+		t.Fatal("expected error for 'true + 1', got nil")
+	}
+	if !strings.Contains(err.Error(), "cannot add bool and number") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestEvaluateTypeErrorMessages(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 - false", "cannot subtract number and bool"},
+		{"true * true", "cannot multiply bool and bool"},
+		{"false / 2", "cannot divide bool and number"},
+		{"-true", "cannot negate bool"},
+	}
+
+	for _, tc := range tests {
+		_, err := evaluate(tc.input)
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", tc.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("for '%s': expected error containing '%s', got '%v'", tc.input, tc.expected, err)
+		}
+	}
+}
+
+func TestEvaluateUndeclaredIdentifier(t *testing.T) {
+	_, err := evaluate("undeclared_identifier_for_test")
+	if err == nil {
+		t.Fatal("expected error for undeclared identifier, got nil")
+	}
+	if !strings.Contains(err.Error(), "undeclared identifier") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestEvaluateDoubleDeclaration(t *testing.T) {
+	_, err := evaluate("var x", "var x")
+	if err == nil {
+		t.Fatal("expected error for double declaration, got nil")
+	}
+}
+
+// Comparison and equality
+
+func TestEvaluateComparisons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"1 < 2", ast.Boolean(true)},
+		{"2 < 2", ast.Boolean(false)},
+		{"2 <= 2", ast.Boolean(true)},
+		{"3 > 2", ast.Boolean(true)},
+		{"2 >= 3", ast.Boolean(false)},
+		{"1 + 1 == 2", ast.Boolean(true)},
+		{"1 != 1", ast.Boolean(false)},
+		{"true == true", ast.Boolean(true)},
+		{"true != false", ast.Boolean(true)},
+		{"1 == true", ast.Boolean(false)},
+		{"1 < 2 == 3 < 4", ast.Boolean(true)},
+	}
+
+	for _, tc := range tests {
+		value, err := evaluate(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", tc.input, err)
+			continue
+		}
+		if value != tc.expected {
+			t.Errorf("for '%s': expected %v, got %v", tc.input, tc.expected, value)
+		}
+	}
+}
+
+func TestEvaluateComparisonWithVariable(t *testing.T) {
+	value, err := evaluate("var x", "x = 12", "x >= 10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Boolean(true) {
+		t.Errorf("expected true, got %v", value)
+	}
+}
+
+func TestEvaluateCompareBoolAndNumber(t *testing.T) {
+	_, err := evaluate("true < 1")
+	if err == nil {
+		t.Fatal("expected error for 'true < 1', got nil")
+	}
+	if !strings.Contains(err.Error(), "cannot compare bool and number") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+// Logical operators
+
+func TestEvaluateLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"true && true", ast.Boolean(true)},
+		{"true && false", ast.Boolean(false)},
+		{"false || true", ast.Boolean(true)},
+		{"false or false", ast.Boolean(false)},
+		{"!true", ast.Boolean(false)},
+		{"not false and true", ast.Boolean(true)},
+		{"!(1 < 2)", ast.Boolean(false)},
+		{"1 < 2 && 3 < 4", ast.Boolean(true)},
+	}
+
+	for _, tc := range tests {
+		value, err := evaluate(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", tc.input, err)
+			continue
+		}
+		if value != tc.expected {
+			t.Errorf("for '%s': expected %v, got %v", tc.input, tc.expected, value)
+		}
+	}
+}
+
+func TestEvaluateLogicalShortCircuit(t *testing.T) {
+	// The right operands reference undeclared identifiers and would fail if
+	// they were evaluated.
+	for _, input := range []string{"false && undeclared_for_test", "true || undeclared_for_test"} {
+		if _, err := evaluate(input); err != nil {
+			t.Errorf("unexpected error for '%s': %v", input, err)
+		}
+	}
+}
+
+func TestEvaluateLogicalGuard(t *testing.T) {
+	value, err := evaluate("var x", "x != 0 && 10 / x > 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Boolean(false) {
+		t.Errorf("expected false, got %v", value)
+	}
+}
+
+func TestEvaluateLogicalTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 && true", "operands of '&&' must be bool, got number"},
+		{"true and 1", "operands of 'and' must be bool, got number"},
+		{"!5", "cannot apply '!' to number"},
+	}
+
+	for _, tc := range tests {
+		_, err := evaluate(tc.input)
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", tc.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("for '%s': expected error containing '%s', got '%v'", tc.input, tc.expected, err)
+		}
+	}
+}
+
+// Programs
+
+func TestEvaluateProgram(t *testing.T) {
+	value, err := evaluate("var x; x = 3; x * 2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(6)) {
+		t.Errorf("expected 6, got %v", value)
+	}
+}
+
+func TestEvaluateProgramKeepsState(t *testing.T) {
+	value, err := evaluate("var rate\nrate = 0.5", "var total; total = 10 * rate\ntotal + 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != ast.Number(6) {
+		t.Errorf("expected 6, got %v", value)
+	}
+}
+
+func TestEvaluateProgramStopsAtError(t *testing.T) {
+	_, err := evaluate("var x; x = true + 1; undeclared_for_test")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "cannot add bool and number") {
+		t.Errorf("expected error from the failing statement, got: %v", err)
+	}
+}
+
+// Functions
+
+func TestEvaluateFunctionDeclaration(t *testing.T) {
+	value, err := evaluate("fn area(w, h) { w * h }", "area(3, 4)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(12)) {
+		t.Errorf("expected 12, got %v", value)
+	}
+}
+
+func TestEvaluateLambda(t *testing.T) {
+	value, err := evaluate("var double; double = fn(x) => x * 2; double(21)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(42)) {
+		t.Errorf("expected 42, got %v", value)
+	}
+}
+
+func TestEvaluateImmediateLambdaCall(t *testing.T) {
+	value, err := evaluate("(fn(a, b) => a - b)(10, 4)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(6)) {
+		t.Errorf("expected 6, got %v", value)
+	}
+}
+
+func TestEvaluateClosureCapturesScope(t *testing.T) {
+	value, err := evaluate("fn adder(n) { fn(x) => x + n }", "var add_two; add_two = adder(2)", "var n; n = 100", "add_two(5)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(7)) {
+		t.Errorf("expected 7, got %v", value)
+	}
+}
+
+func TestEvaluateClosureSharesState(t *testing.T) {
+	value, err := evaluate("fn counter() {\n var n\n fn() {\n n = n + 1\n }\n}\nvar next; next = counter()\nnext(); next()\nnext()")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(3)) {
+		t.Errorf("expected 3, got %v", value)
+	}
+}
+
+func TestEvaluateFunctionLocalsDoNotLeak(t *testing.T) {
+	_, err := evaluate("fn f(x) { var local; local = x }", "f(1)", "local")
+	if err == nil {
+		t.Fatal("expected error for function local used outside, got nil")
+	}
+	if !strings.Contains(err.Error(), "undeclared identifier local") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestEvaluateNestedDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.Value
+	}{
+		{"fn outer() { fn f() { g() }; fn g() { 1 }; f() }; outer()", ast.NewInteger(1)},
+		{"fn outer() { fn g() { x }; var x; x = 2; g() }; outer()", ast.NewInteger(2)},
+		{"fn g() { 2 }; fn outer() { { var r; r = g(); fn g() { 1 }; r * 10 + g() } }; outer()", ast.NewInteger(21)},
+		{"fn f() { x = 2; x = 3 }; x = 1; f(); x", ast.NewInteger(3)},
+	}
+	for _, test := range tests {
+		value, err := evaluate(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if !sameValue(value, test.expected) {
+			t.Errorf("expected %v for '%s', got %v", test.expected, test.input, value)
+		}
+	}
+
+	failures := []struct {
+		input    string
+		expected string
+	}{
+		{"fn outer() { fn f() { q = 1 }; f(); q }; outer()", "1:37: undeclared identifier q"},
+		{"fn f() { { y = 1 }; y }; f()", "1:21: undeclared identifier y"},
+		{"fn f() { x = 1; var x }; f()", "1:17: double declaration of x"},
+		{"fn f() { fn g() { 1 }; fn g() { 2 } }; f()", "1:24: double declaration of g"},
+	}
+	for _, test := range failures {
+		_, err := evaluate(test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%v'", test.expected, test.input, err)
+		}
+	}
+}
+
+func TestEvaluateParameterShadowsGlobal(t *testing.T) {
+	value, err := evaluate("var x; x = 1", "fn f(x) { x * 10 }", "f(5) + x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(51)) {
+		t.Errorf("expected 51, got %v", value)
+	}
+}
+
+func TestEvaluateHigherOrderFunction(t *testing.T) {
+	value, err := evaluate("fn twice(f, x) { f(f(x)) }", "twice(fn(x) => x * 3, 2)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameValue(value, ast.NewInteger(18)) {
+		t.Errorf("expected 18, got %v", value)
+	}
+}
+
+func TestEvaluateCallErrors(t *testing.T) {
+	tests := []struct {
+		inputs   []string
+		expected string
+	}{
+		{[]string{"fn f(a, b) { a }", "f(1)"}, "<fn f> expects 2 arguments, got 1"},
+		{[]string{"var x; x = 3", "x(1)"}, "cannot call number"},
+		{[]string{"fn f() { f() }", "f()"}, "maximum call depth"},
+		{[]string{"fn f(x) { x }", "fn f(y) { y }"}, "double declaration of f"},
+	}
+
+	for _, tc := range tests {
+		_, err := evaluate(tc.inputs...)
+		if err == nil {
+			t.Errorf("expected error for %v, got nil", tc.inputs)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("for %v: expected error containing '%s', got '%v'", tc.inputs, tc.expected, err)
+		}
+	}
+}
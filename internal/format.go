@@ -0,0 +1,19 @@
+package internal
+
+import "github.com/jayjunior/eval/internal/ast"
+
+// Format parses src and returns it in the canonical form of ast.Source,
+// comments included. Source with syntax errors is not formatted.
+func Format(src string) (string, error) {
+	lexer := NewLexer(src)
+	lexer.KeepComments = true
+	tokens, err := lexer.Tokenize()
+	if err != nil {
+		return "", err
+	}
+	exp, err := Parse(tokens)
+	if err != nil {
+		return "", err
+	}
+	return ast.Source(exp, lexer.Comments()...), nil
+}
@@ -0,0 +1,241 @@
+package internal_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1+2*3", "1 + 2 * 3\n"},
+		{"((1+2))*3", "(1 + 2) * 3\n"},
+		{"a-(b-c)", "a - (b - c)\n"},
+		{"(a-b)-c", "a - b - c\n"},
+		{"(a*b)+(c/d)", "a * b + c / d\n"},
+		{"-(-x)", "--x\n"},
+		{"-(a+b)", "-(a + b)\n"},
+		{"not a and (b or c)", "!a && (b || c)\n"},
+		{"(a && b) || c", "a && b || c\n"},
+		{"(1 < 2) == (3 > 4)", "1 < 2 == 3 > 4\n"},
+		{"(a&b)|(c^d)", "a & b | c ^ d\n"},
+		{"a&(b|c)", "a & (b | c)\n"},
+		{"(a<<1)+b", "(a << 1) + b\n"},
+		{"(a//b)%c", "a // b % c\n"},
+		{"2**(3**2)", "2 ** 3 ** 2\n"},
+		{"(2**3)**2", "(2 ** 3) ** 2\n"},
+		{"-(2**2)", "-2 ** 2\n"},
+		{"(-2)**2", "(-2) ** 2\n"},
+		{"2**(-x)", "2 ** -x\n"},
+		{"~(a)&b", "~a & b\n"},
+		{"(f)(x)(y)", "f(x)(y)\n"},
+		{"(fn(a)=>a*2)(21)", "(fn(a) => a * 2)(21)\n"},
+		{"(fn(x)=>x) + 1", "(fn(x) => x) + 1\n"},
+		{"map(fn(x)=>(x+1), xs)", "map(fn(x) => x + 1, xs)\n"},
+		{"var   x;x=(2)", "var x\nx = 2\n"},
+		{"x+=1;y-=(2);z*=3;w/=4", "x += 1\ny -= 2\nz *= 3\nw /= 4\n"},
+		{"y=(x=3)", "y = x = 3\n"},
+		{"1+(x=2)", "1 + (x = 2)\n"},
+		{"f((x=1))", "f(x = 1)\n"},
+		{"(x=a)?b:c", "(x = a) ? b : c\n"},
+		{"while true{i+=1}", "while true {\n\ti += 1\n}\n"},
+		{"fn f(a,b){a+b}", "fn f(a, b) { a + b }\n"},
+		{"fn f(){}", "fn f() {}\n"},
+		{"fn g(x) { var y; y = x\n\n y }", "fn g(x) {\n\tvar y\n\ty = x\n\ty\n}\n"},
+		{"fn outer() { fn inner() { 1 } }", "fn outer() {\n\tfn inner() { 1 }\n}\n"},
+		{"f(fn(x) { var y; y })", "f(fn(x) {\n\tvar y\n\ty\n})\n"},
+		{`x='a'+"b\n"`, `x = 'a' + "b\n"` + "\n"},
+		{`"${ (1+2)*3 } and ${ "${a}" }"`, `"${(1 + 2) * 3} and ${"${a}"}"` + "\n"},
+		{"\"${1 +\n 2}\"", `"${1 + 2}"` + "\n"},
+		{"if(a)then(b)else(c+1)", "if a then b else c + 1\n"},
+		{"(a?b:c)?(d?e:f):(g?h:i)", "(a ? b : c) ? d ? e : f : g ? h : i\n"},
+		{"(if a then b else c) + 1", "(if a then b else c) + 1\n"},
+		{"(a || b) ? (fn(x) => x) : -1", "a || b ? fn(x) => x : -1\n"},
+		{"while(i<3){i=i+1}", "while i < 3 {\n\ti = i + 1\n}\n"},
+		{"while (a) { f() }", "while a { f() }\n"},
+		{"for i in range( 0,n+1 ){if i>2{break}else{continue}}", "for i in range(0, n + 1) {\n\tif i > 2 {\n\t\tbreak\n\t} else {\n\t\tcontinue\n\t}\n}\n"},
+		{"{var x\nx=1}", "{\n\tvar x\n\tx = 1\n}\n"},
+		{"if(a){1}else if b{2}else{3}", "if a { 1 } else if b { 2 } else { 3 }\n"},
+		{"(if a {1}) + 1", "(if a { 1 }) + 1\n"},
+		{"a /* c */ + b", "a /* c */ + b\n"},
+		{"f(x /* c */)", "f(x /* c */)\n"},
+		{"x /* c */ += 1", "x /* c */ += 1\n"},
+	}
+	for _, test := range tests {
+		formatted, err := internal.Format(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if formatted != test.expected {
+			t.Errorf("expected '%s' to format to %q, got %q", test.input, test.expected, formatted)
+		}
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"# header\nvar x # the x\nx = 1 + /* inline */ 2", "# header\nvar x # the x\nx = 1 + /* inline */ 2\n"},
+		{"x = (1 + # one\n 2)", "x = 1 + 2 # one\n"},
+		{"a /* multi\nline */ b", "a /* multi\nline */\nb\n"},
+		{"fn g() { /* only */ }", "fn g() {\n\t/* only */\n}\n"},
+		{"fn h(n) { n # result\n}", "fn h(n) {\n\tn # result\n}\n"},
+		{"fn k() {\n # first\n 1\n # last\n}\n# end", "fn k() {\n\t# first\n\t1\n\t# last\n}\n# end\n"},
+		{"1 / 2 # not /* a block */", "1 / 2 # not /* a block */\n"},
+	}
+	for _, test := range tests {
+		formatted, err := internal.Format(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if formatted != test.expected {
+			t.Errorf("expected '%s' to format to %q, got %q", test.input, test.expected, formatted)
+		}
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	if _, err := internal.Format("1 + * 2"); err == nil {
+		t.Error("expected an error for invalid source")
+	}
+}
+
+// Helper returning the structure of the AST of input, ignoring positions and
+// the spelling of operators
+func structure(t *testing.T, input string) string {
+	t.Helper()
+	exp, err := internal.Parse(tokens(input))
+	if err != nil {
+		t.Fatalf("unexpected error for %q: %v", input, err)
+	}
+	printer := ast.CreatePrinter()
+	printer.Format = ast.FormatSExpr
+	return printer.Sprint(exp)
+}
+
+// generator writes random, valid and oddly spaced source
+type generator struct {
+	random *rand.Rand
+}
+
+func (this *generator) space() string {
+	return []string{"", " ", "  ", " /* c */ "}[this.random.Intn(4)]
+}
+
+func (this *generator) expression(depth int) string {
+	choice := this.random.Intn(14)
+	if depth <= 0 {
+		choice %= 3
+	}
+	switch choice {
+	case 0:
+		return fmt.Sprint(this.random.Intn(100))
+	case 1:
+		return []string{"a", "b", "x_1", "true", "false", `"s"`, `'t\n'`}[this.random.Intn(7)]
+	case 2:
+		return "(" + this.space() + this.expression(depth-1) + this.space() + ")"
+	case 3:
+		return []string{"-", "!", "not ", "~"}[this.random.Intn(4)] + this.expression(depth-1)
+	case 4:
+		arguments := make([]string, this.random.Intn(3))
+		for i := range arguments {
+			arguments[i] = this.expression(depth - 1)
+		}
+		return []string{"f", "(g)", "h(1)"}[this.random.Intn(3)] + "(" + strings.Join(arguments, ","+this.space()) + ")"
+	case 5:
+		return "(fn(p, q) =>" + this.space() + this.expression(depth-1) + ")"
+	case 6:
+		return `"a${` + this.space() + this.expression(depth-1) + this.space() + `}b"`
+	case 7:
+		return "(" + this.expression(depth-1) + this.space() + "?" + this.space() + this.expression(depth-1) + this.space() + ":" + this.space() + this.expression(depth-1) + ")"
+	case 8:
+		return "(if " + this.expression(depth-1) + " then " + this.expression(depth-1) + " else " + this.expression(depth-1) + ")"
+	case 9:
+		return "(if " + this.expression(depth-1) + this.space() + "{" + this.expression(depth-1) + "}" + this.space() + "else {" + this.space() + "})"
+	case 10:
+		return "(w" + this.space() + []string{"=", "+="}[this.random.Intn(2)] + this.space() + this.expression(depth-1) + ")"
+	default:
+		operators := []string{"+", "-", "*", "/", "//", "%", "**", "&", "|", "^", "<<", ">>", "==", "!=", "<", "<=", ">", ">=", "&&", "||", "and", "or"}
+		operator := operators[this.random.Intn(len(operators))]
+		if operator == "and" || operator == "or" {
+			operator = " " + operator + " "
+		}
+		return this.expression(depth-1) + this.space() + operator + this.space() + this.expression(depth-1)
+	}
+}
+
+// statement writes a statement, inside a loop when loop is set
+func (this *generator) statement(depth int, loop bool) string {
+	switch this.random.Intn(12) {
+	case 0:
+		return "var" + " " + this.space() + "v"
+	case 1:
+		return "v" + this.space() + []string{"=", "+=", "-=", "*=", "/="}[this.random.Intn(5)] + this.space() + this.expression(depth)
+	case 2:
+		return "fn k(" + this.space() + "n)" + this.space() + "{" + this.statements(depth-1, false) + "}"
+	case 3:
+		return "while " + this.expression(depth-1) + this.space() + "{" + this.statements(depth-1, true) + "}"
+	case 4:
+		return "for i in range(" + this.expression(depth-1) + "," + this.space() + this.expression(depth-1) + ")" + this.space() + "{" + this.statements(depth-1, true) + "}"
+	case 5:
+		return "{" + this.space() + this.statements(depth-1, loop) + "}"
+	case 6:
+		return "if " + this.expression(depth-1) + " {" + this.statements(depth-1, loop) + "} else if b {} else {" + this.statements(depth-1, loop) + "}"
+	case 7:
+		if loop {
+			return []string{"break", "continue"}[this.random.Intn(2)]
+		}
+	}
+	return this.expression(depth)
+}
+
+func (this *generator) statements(depth int, loop bool) string {
+	if depth < 0 {
+		return ""
+	}
+	statements := make([]string, this.random.Intn(3))
+	for i := range statements {
+		statements[i] = this.statement(depth, loop)
+	}
+	return strings.Join(statements, ";")
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	generator := &generator{random: rand.New(rand.NewSource(1))}
+	for range 2000 {
+		statements := make([]string, 1+generator.random.Intn(3))
+		for i := range statements {
+			statements[i] = generator.statement(4, false)
+		}
+		input := strings.Join(statements, []string{";", "\n", " ; "}[generator.random.Intn(3)])
+
+		formatted, err := internal.Format(input)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", input, err)
+		}
+		if structure(t, formatted) != structure(t, input) {
+			t.Fatalf("formatting %q as %q changes its structure from\n%s\nto\n%s", input, formatted, structure(t, input), structure(t, formatted))
+		}
+		again, err := internal.Format(formatted)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", formatted, err)
+		}
+		if again != formatted {
+			t.Fatalf("formatting is not idempotent: %q formats to %q, then to %q", input, formatted, again)
+		}
+		if strings.Count(formatted, "/* c */") != strings.Count(input, "/* c */") {
+			t.Fatalf("formatting %q as %q loses comments", input, formatted)
+		}
+	}
+}
@@ -1,104 +1,510 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/jayjunior/eval/internal/ast"
 )
 
-var input = ""
-var current_index = 0
-var res = make([]ast.Token, 0)
 var operators = map[byte]ast.TokenType{
 	'+': ast.Plus,
 	'-': ast.Minus,
 	'*': ast.Multiplication,
 	'/': ast.Division,
+	'%': ast.Modulo,
+	'&': ast.BIT_AND,
+	'|': ast.BIT_OR,
+	'^': ast.BIT_XOR,
+	'~': ast.BIT_NOT,
 	'(': ast.Open_Parentheses,
 	')': ast.Close_Parentheses,
 	'=': ast.EQUAL,
+	'<': ast.LESS,
+	'>': ast.GREATER,
+	'!': ast.BANG,
+	';': ast.SEMICOLON,
+	',': ast.COMMA,
+	'{': ast.Open_Brace,
+	'}': ast.Close_Brace,
+	'?': ast.QUESTION,
+	':': ast.COLON,
+}
+
+// Operators made of two characters, matched before the single character ones.
+var twoCharOperators = map[string]ast.TokenType{
+	"==": ast.EQUAL_EQUAL,
+	"!=": ast.BANG_EQUAL,
+	"<=": ast.LESS_EQUAL,
+	">=": ast.GREATER_EQUAL,
+	"&&": ast.AND,
+	"||": ast.OR,
+	"=>": ast.ARROW,
+	"//": ast.IntegerDivision,
+	"**": ast.Power,
+	"<<": ast.LEFT_SHIFT,
+	">>": ast.RIGHT_SHIFT,
+	"+=": ast.PLUS_EQUAL,
+	"-=": ast.MINUS_EQUAL,
+	"*=": ast.STAR_EQUAL,
+	"/=": ast.SLASH_EQUAL,
 }
 var keywords = map[string]ast.TokenType{
-	"var":   ast.VAR,
-	"true":  ast.TRUE,
-	"false": ast.FALSE,
+	"var":      ast.VAR,
+	"fn":       ast.FN,
+	"true":     ast.TRUE,
+	"false":    ast.FALSE,
+	"and":      ast.AND,
+	"or":       ast.OR,
+	"not":      ast.BANG,
+	"if":       ast.IF,
+	"then":     ast.THEN,
+	"else":     ast.ELSE,
+	"while":    ast.WHILE,
+	"for":      ast.FOR,
+	"in":       ast.IN,
+	"break":    ast.BREAK,
+	"continue": ast.CONTINUE,
+}
+
+// Lexer holds the scanning state for a single input, so separate lexers can
+// be used concurrently.
+type Lexer struct {
+	input         string
+	current_index int
+	res           []ast.Token
+	// Open parentheses and braces. Newlines only separate statements at the
+	// top level or directly inside braces.
+	groups     []ast.TokenType
+	line       int
+	line_start int
+	// KeepComments makes the lexer attach comments to the tokens around them
+	// as ast.Trivia, and record them for Comments.
+	KeepComments bool
+	comments     []ast.Comment
+	// Comments waiting for the next token
+	pending []ast.Comment
+	// String literals whose interpolations are being scanned, innermost last
+	templates []template
+}
+
+// template is a string literal holding interpolations.
+type template struct {
+	quote byte
+	start ast.Position
+}
+
+func NewLexer(input string) *Lexer {
+	return &Lexer{input: input}
 }
 
 func Tokenize(input_string string) ([]ast.Token, error) {
-	input = input_string
-	current_index = 0
-	res = make([]ast.Token, 0)
-	for !isEnd() {
-		token := peek_char()
-		if tokenType, exist := operators[token]; exist {
-			operator(tokenType)
+	return NewLexer(input_string).Tokenize()
+}
+
+func (this *Lexer) Tokenize() ([]ast.Token, error) {
+	this.current_index = 0
+	this.groups = nil
+	this.line = 1
+	this.line_start = 0
+	this.res = make([]ast.Token, 0)
+	this.comments = nil
+	this.pending = nil
+	this.templates = nil
+	for !this.isEnd() {
+		token := this.peek_char()
+		if token == '"' || token == '\'' {
+			if err := this.stringLiteral(token, this.position(), false); err != nil {
+				return nil, err
+			}
+		} else if token == '}' && len(this.groups) > 0 && this.groups[len(this.groups)-1] == ast.TEMPLATE_START {
+			// End of an interpolation: the string literal goes on
+			this.groups = this.groups[:len(this.groups)-1]
+			template := this.templates[len(this.templates)-1]
+			if err := this.stringLiteral(template.quote, template.start, true); err != nil {
+				return nil, err
+			}
+		} else if token == '#' {
+			this.lineComment()
+		} else if this.peek_string(2) == "/*" {
+			if err := this.blockComment(); err != nil {
+				return nil, err
+			}
+		} else if tokenType, exist := twoCharOperators[this.peek_string(2)]; exist {
+			this.operator(tokenType, 2)
+		} else if tokenType, exist := operators[token]; exist {
+			this.operator(tokenType, 1)
 		} else if isDigit(rune(token)) {
-			number()
+			if err := this.number(); err != nil {
+				return nil, err
+			}
 		} else if isLetter(rune(token)) || token == '_' {
-			word()
-		} else if token == '\t' || token == ' ' {
-			consume_char()
+			this.word()
+		} else if token == '\n' && this.newlineSeparates() {
+			this.operator(ast.NEWLINE, 1)
+		} else if token == '\t' || token == ' ' || token == '\r' || token == '\n' {
+			this.consume_char()
 		} else {
-			return nil, fmt.Errorf("Unrecognized character at position %d", current_index)
+			start := this.position()
+			char, size := utf8.DecodeRuneInString(this.input[this.current_index:])
+			for range size {
+				this.consume_char()
+			}
+			return nil, &ast.Diagnostic{
+				Span:    ast.Span{Start: start, End: this.position()},
+				Message: fmt.Sprintf("unrecognized character '%c'", char),
+			}
 		}
 	}
 
-	return res, nil
+	if len(this.templates) > 0 {
+		return nil, unterminatedString(this.templates[len(this.templates)-1].start)
+	}
+	if last := this.lastToken(); last != nil && len(this.pending) > 0 {
+		trivia := trivia(last)
+		trivia.Trailing = append(trivia.Trailing, this.pending...)
+	}
+	return this.res, nil
 }
 
-func operator(tokenType ast.TokenType) {
-	token := consume_char()
-	res = append(res, ast.Token{Literal: string(token), Token: tokenType})
+// Comments returns the comments of the input, in order, when the lexer keeps
+// comments.
+func (this *Lexer) Comments() []ast.Comment {
+	return this.comments
 }
 
-func peek_char() byte {
-	return input[current_index]
+func (this *Lexer) lineComment() {
+	start := this.position()
+	for !this.isEnd() && this.peek_char() != '\n' {
+		this.consume_char()
+	}
+	text := strings.TrimRight(this.input[start.Offset:this.current_index], " \t\r")
+	this.comment(ast.Comment{Text: text, Span: ast.Span{Start: start, End: this.position()}})
+}
+
+// blockComment skips a block comment. One spanning several lines separates
+// statements as a newline would.
+func (this *Lexer) blockComment() error {
+	start := this.position()
+	this.consume_char()
+	this.consume_char()
+	for this.peek_string(2) != "*/" {
+		if this.isEnd() {
+			end := start
+			end.Offset += 2
+			end.Column += 2
+			return &ast.Diagnostic{Span: ast.Span{Start: start, End: end}, Message: "unterminated block comment"}
+		}
+		this.consume_char()
+	}
+	this.consume_char()
+	this.consume_char()
+
+	span := ast.Span{Start: start, End: this.position()}
+	this.comment(ast.Comment{Text: this.input[start.Offset:this.current_index], Span: span})
+	if span.End.Line > span.Start.Line && this.newlineSeparates() {
+		this.res = append(this.res, ast.Token{Literal: "\n", Token: ast.NEWLINE, Span: span})
+	}
+	return nil
+}
+
+// comment records comment, as trailing trivia of the previous token when on
+// its line, as leading trivia of the next one otherwise.
+func (this *Lexer) comment(comment ast.Comment) {
+	if !this.KeepComments {
+		return
+	}
+	this.comments = append(this.comments, comment)
+	if last := this.lastToken(); last != nil && last.Span.End.Line == comment.Span.Start.Line && len(this.pending) == 0 {
+		trivia := trivia(last)
+		trivia.Trailing = append(trivia.Trailing, comment)
+		return
+	}
+	this.pending = append(this.pending, comment)
 }
 
-func consume_char() byte {
-	res := input[current_index]
-	current_index++
+// lastToken returns the last token that is not a newline.
+func (this *Lexer) lastToken() *ast.Token {
+	for i := len(this.res) - 1; i >= 0; i-- {
+		if this.res[i].Token != ast.NEWLINE {
+			return &this.res[i]
+		}
+	}
+	return nil
+}
+
+// trivia returns the trivia of token, creating them if needed.
+func trivia(token *ast.Token) *ast.Trivia {
+	if token.Trivia == nil {
+		token.Trivia = &ast.Trivia{}
+	}
+	return token.Trivia
+}
+
+// stringLiteral scans a string literal delimited by quote, which started at
+// start, up to its end or its next interpolation. continued is set when
+// resuming the literal after an interpolation, at its closing brace.
+//
+// A literal without interpolations is a STRING_LITERAL token. Otherwise the
+// text up to the first interpolation is a TEMPLATE_START token, the text
+// between two interpolations a TEMPLATE_MIDDLE one and the rest a
+// TEMPLATE_END one, with the tokens of the interpolated expressions in
+// between.
+func (this *Lexer) stringLiteral(quote byte, start ast.Position, continued bool) error {
+	textStart := this.position()
+	this.consume_char()
+	for {
+		if this.isEnd() || this.peek_char() == '\n' {
+			return unterminatedString(start)
+		}
+		char := this.peek_char()
+		if char == '\\' {
+			this.consume_char()
+			if this.isEnd() || this.peek_char() == '\n' {
+				return unterminatedString(start)
+			}
+			this.consume_char()
+			continue
+		}
+		if char == quote || this.peek_string(2) == "${" {
+			break
+		}
+		this.consume_char()
+	}
+
+	interpolation := this.peek_char() != quote
+	tokenType := ast.STRING_LITERAL
+	switch {
+	case continued && interpolation:
+		tokenType = ast.TEMPLATE_MIDDLE
+	case continued:
+		tokenType = ast.TEMPLATE_END
+		this.templates = this.templates[:len(this.templates)-1]
+	case interpolation:
+		tokenType = ast.TEMPLATE_START
+		this.templates = append(this.templates, template{quote: quote, start: start})
+	}
+	text := this.input[textStart.Offset+1 : this.current_index]
+	if _, offset, err := ast.Unescape(text); err != nil {
+		escape := textStart
+		escape.Offset += 1 + offset
+		escape.Column += 1 + offset
+		end := escape
+		end.Offset += 2
+		end.Column += 2
+		return &ast.Diagnostic{Span: ast.Span{Start: escape, End: end}, Message: err.Error()}
+	}
+
+	this.consume_char()
+	if interpolation {
+		this.consume_char()
+		this.groups = append(this.groups, ast.TEMPLATE_START)
+	}
+	this.addToken(tokenType, this.input[textStart.Offset:this.current_index], textStart)
+	return nil
+}
+
+func unterminatedString(start ast.Position) *ast.Diagnostic {
+	end := start
+	end.Offset++
+	end.Column++
+	return &ast.Diagnostic{Span: ast.Span{Start: start, End: end}, Message: "unterminated string"}
+}
+
+func (this *Lexer) operator(tokenType ast.TokenType, length int) {
+	start := this.position()
+	literal := ""
+	for range length {
+		literal += string(this.consume_char())
+	}
+	this.addToken(tokenType, literal, start)
+	if tokenType == ast.Open_Parentheses || tokenType == ast.Open_Brace {
+		this.groups = append(this.groups, tokenType)
+	} else if (tokenType == ast.Close_Parentheses || tokenType == ast.Close_Brace) && len(this.groups) > 0 {
+		this.groups = this.groups[:len(this.groups)-1]
+	}
+}
+
+func (this *Lexer) newlineSeparates() bool {
+	return len(this.groups) == 0 || this.groups[len(this.groups)-1] == ast.Open_Brace
+}
+
+func (this *Lexer) addToken(tokenType ast.TokenType, literal string, start ast.Position) {
+	span := ast.Span{Start: start, End: this.position()}
+	token := ast.Token{Literal: literal, Token: tokenType, Span: span}
+	if len(this.pending) > 0 && tokenType != ast.NEWLINE {
+		token.Trivia = &ast.Trivia{Leading: this.pending}
+		this.pending = nil
+	}
+	this.res = append(this.res, token)
+}
+
+func (this *Lexer) position() ast.Position {
+	return ast.Position{
+		Offset: this.current_index,
+		Line:   this.line,
+		Column: this.current_index - this.line_start + 1,
+	}
+}
+
+func (this *Lexer) peek_char() byte {
+	return this.input[this.current_index]
+}
+
+// peek_string returns the next length characters, or fewer near the end of
+// the input.
+func (this *Lexer) peek_string(length int) string {
+	end := min(this.current_index+length, len(this.input))
+	return this.input[this.current_index:end]
+}
+
+func (this *Lexer) consume_char() byte {
+	res := this.input[this.current_index]
+	this.current_index++
+	if res == '\n' {
+		this.line++
+		this.line_start = this.current_index
+	}
 	return res
 }
 
-func number() {
-	digit := ""
+// Bases of the integer literals with a prefix, by prefix
+var bases = map[string]int{"0x": 16, "0X": 16, "0o": 8, "0O": 8, "0b": 2, "0B": 2}
+
+var baseNames = map[int]string{16: "a hexadecimal", 10: "a decimal", 8: "an octal", 2: "a binary"}
+
+// number scans an integer, in decimal or, after a 0x, 0o or 0b prefix, in
+// hexadecimal, octal or binary, or a decimal float with a fraction, a signed
+// exponent or both. Single underscores may separate digits. A literal
+// directly followed by a letter, a digit or a '.' is malformed.
+func (this *Lexer) number() error {
+	start := this.position()
+	base, exists := bases[this.peek_string(2)]
+	if exists {
+		this.consume_char()
+		this.consume_char()
+	} else {
+		base = 10
+	}
 	isFloat := false
-	for !isEnd() && (isDigit(rune(peek_char())) || peek_char() == '.' || peek_char() == 'e' || peek_char() == 'E') {
-		if peek_char() == '.' || peek_char() == 'e' || peek_char() == 'E' {
-			digit += string(consume_char())
-			isFloat = true
-			break
+	problem := this.digits(base)
+	if problem == "" && base == 10 && this.peek_string(1) == "." {
+		isFloat = true
+		this.consume_char()
+		if this.digits(base) != "" {
+			problem = "expected a digit after '.'"
+		}
+	}
+	if next := this.peek_string(1); problem == "" && base == 10 && (next == "e" || next == "E") {
+		isFloat = true
+		this.consume_char()
+		if sign := this.peek_string(1); sign == "+" || sign == "-" {
+			this.consume_char()
+		}
+		if this.digits(base) != "" {
+			problem = "expected a digit in the exponent"
+		}
+	}
+	if problem == "" && this.inNumber() {
+		next := rune(this.peek_char())
+		if base != 10 && next != '_' && next != '.' {
+			problem = fmt.Sprintf("'%c' is not %s digit", next, baseNames[base])
+		} else {
+			problem = fmt.Sprintf("unexpected '%c'", next)
 		}
-		digit += string(consume_char())
 	}
+	if problem != "" {
+		// The diagnostic spans the whole malformed literal
+		for this.inNumber() {
+			this.consume_char()
+		}
+		return &ast.Diagnostic{
+			Span:    ast.Span{Start: start, End: this.position()},
+			Message: fmt.Sprintf("malformed number '%s': %s", this.input[start.Offset:this.current_index], problem),
+		}
+	}
+	if isFloat {
+		literal := this.input[start.Offset:this.current_index]
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(literal, "_", ""), 64); errors.Is(err, strconv.ErrRange) {
+			return &ast.Diagnostic{
+				Span:    ast.Span{Start: start, End: this.position()},
+				Message: fmt.Sprintf("number '%s' is out of range", literal),
+			}
+		}
+		this.addToken(ast.NUMBER_LITERAL, literal, start)
+	} else {
+		this.addToken(ast.INTEGER_LITERAL, this.input[start.Offset:this.current_index], start)
+	}
+	return nil
+}
 
-	for !isEnd() && isFloat && isDigit(rune(peek_char())) {
-		digit += string(consume_char())
+// inNumber reports whether the next character would continue a number: a
+// letter, a digit, '_' or '.'.
+func (this *Lexer) inNumber() bool {
+	if this.isEnd() {
+		return false
 	}
-	res = append(res, ast.Token{Literal: digit, Token: ast.NUMBER_LITERAL})
+	next := rune(this.peek_char())
+	return isLetter(next) || isDigit(next) || next == '_' || next == '.'
 }
 
-func word() {
+// digits scans digits of base separated by single underscores, returning what
+// is wrong with them if anything.
+func (this *Lexer) digits(base int) string {
+	count := 0
+	for !this.isEnd() {
+		if this.peek_char() == '_' && count > 0 && this.current_index+1 < len(this.input) && isDigitOf(this.input[this.current_index+1], base) {
+			this.consume_char()
+		} else if !isDigitOf(this.peek_char(), base) {
+			break
+		}
+		this.consume_char()
+		count++
+	}
+	if count > 0 {
+		return ""
+	}
+	if this.inNumber() && this.peek_char() != '_' && this.peek_char() != '.' {
+		return fmt.Sprintf("'%c' is not %s digit", this.peek_char(), baseNames[base])
+	}
+	return fmt.Sprintf("expected %s digit", baseNames[base])
+}
+
+func (this *Lexer) word() {
+	start := this.position()
 	result := ""
-	for !isEnd() && (isLetter(rune(peek_char())) || peek_char() == '_') {
-		result += string(consume_char())
+	for !this.isEnd() && (isLetter(rune(this.peek_char())) || this.peek_char() == '_' || isDigit(rune(this.peek_char()))) {
+		result += string(this.consume_char())
 	}
 	if tokenType, exists := keywords[result]; exists {
-		res = append(res, ast.Token{Literal: result, Token: tokenType})
+		this.addToken(tokenType, result, start)
 	} else {
-		res = append(res, ast.Token{Literal: result, Token: ast.IDENTIFIER_LITERAL})
+		this.addToken(ast.IDENTIFIER_LITERAL, result, start)
 	}
 }
 
-func isEnd() bool {
-	return current_index >= len(input)
+func (this *Lexer) isEnd() bool {
+	return this.current_index >= len(this.input)
 }
 
 func isDigit(digit rune) bool {
 	return digit >= '0' && digit <= '9'
 }
 
+// isDigitOf reports whether char is a digit in base, which is at most 16.
+func isDigitOf(char byte, base int) bool {
+	switch {
+	case char >= '0' && char <= '9':
+		return int(char-'0') < base
+	case char >= 'a' && char <= 'f', char >= 'A' && char <= 'F':
+		return base == 16
+	}
+	return false
+}
+
 func isLetter(letter rune) bool {
 	return (letter >= 'a' && letter <= 'z') || (letter >= 'A' && letter <= 'Z')
 }
@@ -1,6 +1,8 @@
 package internal_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/jayjunior/eval/internal"
@@ -15,8 +17,8 @@ func TestTokenizeSingleDigit(t *testing.T) {
 	if len(tokens) != 1 {
 		t.Fatalf("expected 1 token, got %d", len(tokens))
 	}
-	if tokens[0].Token != ast.NUMBER_LITERAL {
-		t.Errorf("expected Number token, got %v", tokens[0].Token)
+	if tokens[0].Token != ast.INTEGER_LITERAL {
+		t.Errorf("expected Integer token, got %v", tokens[0].Token)
 	}
 }
 
@@ -31,8 +33,8 @@ func TestTokenizeMultiDigitNumber(t *testing.T) {
 	if tokens[0].Literal != "123" {
 		t.Errorf("expected '123', got '%s'", tokens[0].Literal)
 	}
-	if tokens[0].Token != ast.NUMBER_LITERAL {
-		t.Errorf("expected Number token, got %v", tokens[0].Token)
+	if tokens[0].Token != ast.INTEGER_LITERAL {
+		t.Errorf("expected Integer token, got %v", tokens[0].Token)
 	}
 }
 
@@ -85,14 +87,14 @@ func TestTokenizeSimpleExpression(t *testing.T) {
 	if len(tokens) != 3 {
 		t.Fatalf("expected 3 tokens, got %d", len(tokens))
 	}
-	if tokens[0].Token != ast.NUMBER_LITERAL || tokens[0].Literal != "1" {
-		t.Errorf("expected Number '1', got %v '%s'", tokens[0].Token, tokens[0].Literal)
+	if tokens[0].Token != ast.INTEGER_LITERAL || tokens[0].Literal != "1" {
+		t.Errorf("expected Integer '1', got %v '%s'", tokens[0].Token, tokens[0].Literal)
 	}
 	if tokens[1].Token != ast.Plus {
 		t.Errorf("expected Plus, got %v", tokens[1].Token)
 	}
-	if tokens[2].Token != ast.NUMBER_LITERAL || tokens[2].Literal != "2" {
-		t.Errorf("expected Number '2', got %v '%s'", tokens[2].Token, tokens[2].Literal)
+	if tokens[2].Token != ast.INTEGER_LITERAL || tokens[2].Literal != "2" {
+		t.Errorf("expected Integer '2', got %v '%s'", tokens[2].Token, tokens[2].Literal)
 	}
 }
 
@@ -106,12 +108,12 @@ func TestTokenizeComplexExpression(t *testing.T) {
 		value     string
 	}{
 		{ast.Open_Parentheses, "("},
-		{ast.NUMBER_LITERAL, "5"},
+		{ast.INTEGER_LITERAL, "5"},
 		{ast.Plus, "+"},
-		{ast.NUMBER_LITERAL, "3"},
+		{ast.INTEGER_LITERAL, "3"},
 		{ast.Close_Parentheses, ")"},
 		{ast.Multiplication, "*"},
-		{ast.NUMBER_LITERAL, "2"},
+		{ast.INTEGER_LITERAL, "2"},
 	}
 	if len(tokens) != len(expected) {
 		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
@@ -131,14 +133,14 @@ func TestTokenizeWithSpaces(t *testing.T) {
 	if len(tokens) != 3 {
 		t.Fatalf("expected 3 tokens, got %d", len(tokens))
 	}
-	if tokens[0].Token != ast.NUMBER_LITERAL {
-		t.Errorf("expected Number, got %v", tokens[0].Token)
+	if tokens[0].Token != ast.INTEGER_LITERAL {
+		t.Errorf("expected Integer, got %v", tokens[0].Token)
 	}
 	if tokens[1].Token != ast.Plus {
 		t.Errorf("expected Plus, got %v", tokens[1].Token)
 	}
-	if tokens[2].Token != ast.NUMBER_LITERAL {
-		t.Errorf("expected Number, got %v", tokens[2].Token)
+	if tokens[2].Token != ast.INTEGER_LITERAL {
+		t.Errorf("expected Integer, got %v", tokens[2].Token)
 	}
 }
 
@@ -163,8 +165,8 @@ func TestTokenizeUnaryMinus(t *testing.T) {
 	if tokens[0].Token != ast.Minus {
 		t.Errorf("expected Minus, got %v", tokens[0].Token)
 	}
-	if tokens[1].Token != ast.NUMBER_LITERAL {
-		t.Errorf("expected Number, got %v", tokens[1].Token)
+	if tokens[1].Token != ast.INTEGER_LITERAL {
+		t.Errorf("expected Integer, got %v", tokens[1].Token)
 	}
 }
 
@@ -182,8 +184,8 @@ func TestTokenizeDoubleUnaryMinus(t *testing.T) {
 	if tokens[1].Token != ast.Minus {
 		t.Errorf("expected Minus, got %v", tokens[1].Token)
 	}
-	if tokens[2].Token != ast.NUMBER_LITERAL {
-		t.Errorf("expected Number, got %v", tokens[2].Token)
+	if tokens[2].Token != ast.INTEGER_LITERAL {
+		t.Errorf("expected Integer, got %v", tokens[2].Token)
 	}
 }
 
@@ -195,9 +197,9 @@ func TestTokenizeNestedParentheses(t *testing.T) {
 	expected := []ast.TokenType{
 		ast.Open_Parentheses,
 		ast.Open_Parentheses,
-		ast.NUMBER_LITERAL,
+		ast.INTEGER_LITERAL,
 		ast.Plus,
-		ast.NUMBER_LITERAL,
+		ast.INTEGER_LITERAL,
 		ast.Close_Parentheses,
 		ast.Close_Parentheses,
 	}
@@ -230,7 +232,7 @@ func TestTokenizeAllOperators(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	expectedTypes := []ast.TokenType{
-		ast.NUMBER_LITERAL, ast.Plus, ast.NUMBER_LITERAL, ast.Minus, ast.NUMBER_LITERAL, ast.Multiplication, ast.NUMBER_LITERAL, ast.Division, ast.NUMBER_LITERAL,
+		ast.INTEGER_LITERAL, ast.Plus, ast.INTEGER_LITERAL, ast.Minus, ast.INTEGER_LITERAL, ast.Multiplication, ast.INTEGER_LITERAL, ast.Division, ast.INTEGER_LITERAL,
 	}
 	if len(tokens) != len(expectedTypes) {
 		t.Fatalf("expected %d tokens, got %d", len(expectedTypes), len(tokens))
@@ -277,6 +279,89 @@ func TestTokenizeLargeNumber(t *testing.T) {
 	}
 }
 
+func TestTokenizeIntegersAndFloats(t *testing.T) {
+	tokens, err := internal.Tokenize("7 2.5 3e2 10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{ast.INTEGER_LITERAL, ast.NUMBER_LITERAL, ast.NUMBER_LITERAL, ast.INTEGER_LITERAL}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("token %d '%s': expected type %v, got %v", i, tokens[i].Literal, tokenType, tokens[i].Token)
+		}
+	}
+}
+
+func TestTokenizeNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input     string
+		tokenType ast.TokenType
+	}{
+		{"0xFF", ast.INTEGER_LITERAL},
+		{"0Xff", ast.INTEGER_LITERAL},
+		{"0b1010", ast.INTEGER_LITERAL},
+		{"0o17", ast.INTEGER_LITERAL},
+		{"1_000_000", ast.INTEGER_LITERAL},
+		{"1.5e-3", ast.NUMBER_LITERAL},
+		{"1e+5", ast.NUMBER_LITERAL},
+		{"2.5E3", ast.NUMBER_LITERAL},
+		{"1_000.000_1", ast.NUMBER_LITERAL},
+	}
+	for _, test := range tests {
+		tokens, err := internal.Tokenize(test.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.input, err)
+			continue
+		}
+		if len(tokens) != 1 {
+			t.Errorf("expected 1 token for '%s', got %d", test.input, len(tokens))
+			continue
+		}
+		if tokens[0].Token != test.tokenType || tokens[0].Literal != test.input {
+			t.Errorf("expected %v '%s', got %v '%s'", test.tokenType, test.input, tokens[0].Token, tokens[0].Literal)
+		}
+	}
+}
+
+func TestTokenizeMalformedNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		end      int
+	}{
+		{"1e", "1:1: malformed number '1e': expected a digit in the exponent", 2},
+		{"2 * 1e+", "1:5: malformed number '1e+': expected a digit in the exponent", 7},
+		{"1..2", "1:1: malformed number '1..2': expected a digit after '.'", 4},
+		{"1. + 2", "1:1: malformed number '1.': expected a digit after '.'", 2},
+		{"1.2.3", "1:1: malformed number '1.2.3': unexpected '.'", 5},
+		{"0x", "1:1: malformed number '0x': expected a hexadecimal digit", 2},
+		{"0b102", "1:1: malformed number '0b102': '2' is not a binary digit", 5},
+		{"0o8", "1:1: malformed number '0o8': '8' is not an octal digit", 3},
+		{"0x_1", "1:1: malformed number '0x_1': expected a hexadecimal digit", 4},
+		{"1__0", "1:1: malformed number '1__0': unexpected '_'", 4},
+		{"1_", "1:1: malformed number '1_': unexpected '_'", 2},
+		{"12abc", "1:1: malformed number '12abc': unexpected 'a'", 5},
+		{"2 * 1e400", "1:5: number '1e400' is out of range", 9},
+		{"1_000e4_00", "1:1: number '1_000e4_00' is out of range", 10},
+	}
+	for _, test := range tests {
+		_, err := internal.Tokenize(test.input)
+		if err == nil {
+			t.Errorf("expected an error for '%s'", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%v'", test.expected, test.input, err)
+		}
+		if diagnostic, ok := err.(*ast.Diagnostic); !ok || diagnostic.Span.End.Offset != test.end {
+			t.Errorf("expected the diagnostic for '%s' to end at %d, got %v", test.input, test.end, err)
+		}
+	}
+}
+
 func TestTokenizeExpressionWithLeadingSpaces(t *testing.T) {
 	tokens, err := internal.Tokenize("  1+2")
 	if err != nil {
@@ -332,7 +417,7 @@ func TestTokenizeConsecutiveOperators(t *testing.T) {
 	if len(tokens) != 4 {
 		t.Fatalf("expected 4 tokens, got %d", len(tokens))
 	}
-	expectedTypes := []ast.TokenType{ast.NUMBER_LITERAL, ast.Plus, ast.Minus, ast.NUMBER_LITERAL}
+	expectedTypes := []ast.TokenType{ast.INTEGER_LITERAL, ast.Plus, ast.Minus, ast.INTEGER_LITERAL}
 	for i, exp := range expectedTypes {
 		if tokens[i].Token != exp {
 			t.Errorf("token %d: expected %v, got %v", i, exp, tokens[i].Token)
@@ -349,10 +434,13 @@ func TestTokenizeUnrecognizedSpecialChar(t *testing.T) {
 	}
 }
 
-func TestTokenizeUnrecognizedPercent(t *testing.T) {
-	_, err := internal.Tokenize("100%")
-	if err == nil {
-		t.Error("expected error for unrecognized character '%', got nil")
+func TestTokenizePercent(t *testing.T) {
+	tokens, err := internal.Tokenize("100%7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 3 || tokens[1].Token != ast.Modulo {
+		t.Errorf("expected 100, '%%' and 7, got %v", tokens)
 	}
 }
 
@@ -371,18 +459,48 @@ func TestTokenizeUnrecognizedBrackets(t *testing.T) {
 	}
 }
 
-func TestTokenizeUnrecognizedCurlyBraces(t *testing.T) {
-	_, err := internal.Tokenize("{1+2}")
-	if err == nil {
-		t.Error("expected error for unrecognized character '{', got nil")
+func TestTokenizeCurlyBraces(t *testing.T) {
+	tokens, err := internal.Tokenize("{1+2}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 5 {
+		t.Fatalf("expected 5 tokens, got %d", len(tokens))
+	}
+	if tokens[0].Token != ast.Open_Brace || tokens[4].Token != ast.Close_Brace {
+		t.Errorf("expected braces, got %v and %v", tokens[0].Token, tokens[4].Token)
 	}
 }
 
-func TestTokenizeUnrecognizedCaret(t *testing.T) {
-	// Exponentiation is not in the grammar
-	_, err := internal.Tokenize("2^3")
-	if err == nil {
-		t.Error("expected error for unrecognized character '^', got nil")
+func TestTokenizeCaret(t *testing.T) {
+	// '^' is the bitwise exclusive or, exponentiation is '**'
+	tokens, err := internal.Tokenize("2^3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 3 || tokens[1].Token != ast.BIT_XOR {
+		t.Errorf("expected 2, '^' and 3, got %v", tokens)
+	}
+}
+
+func TestTokenizeIntegerAndBitwiseOperators(t *testing.T) {
+	tokens, err := internal.Tokenize("a // b ** c % d & e | f ^ ~g << h >> i && j || k /* c */")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{
+		ast.IDENTIFIER_LITERAL, ast.IntegerDivision, ast.IDENTIFIER_LITERAL, ast.Power, ast.IDENTIFIER_LITERAL,
+		ast.Modulo, ast.IDENTIFIER_LITERAL, ast.BIT_AND, ast.IDENTIFIER_LITERAL, ast.BIT_OR, ast.IDENTIFIER_LITERAL,
+		ast.BIT_XOR, ast.BIT_NOT, ast.IDENTIFIER_LITERAL, ast.LEFT_SHIFT, ast.IDENTIFIER_LITERAL, ast.RIGHT_SHIFT,
+		ast.IDENTIFIER_LITERAL, ast.AND, ast.IDENTIFIER_LITERAL, ast.OR, ast.IDENTIFIER_LITERAL,
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("token %d '%s': expected type %v, got %v", i, tokens[i].Literal, tokenType, tokens[i].Token)
+		}
 	}
 }
 
@@ -427,7 +545,7 @@ func TestTokenizeParenthesizedUnary(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	expectedTypes := []ast.TokenType{ast.Minus, ast.Open_Parentheses, ast.Minus, ast.NUMBER_LITERAL, ast.Close_Parentheses}
+	expectedTypes := []ast.TokenType{ast.Minus, ast.Open_Parentheses, ast.Minus, ast.INTEGER_LITERAL, ast.Close_Parentheses}
 	if len(tokens) != len(expectedTypes) {
 		t.Fatalf("expected %d tokens, got %d", len(expectedTypes), len(tokens))
 	}
@@ -528,7 +646,7 @@ func TestTokenizeSimpleAssignment(t *testing.T) {
 	if len(tokens) != 3 {
 		t.Fatalf("expected 3 tokens, got %d", len(tokens))
 	}
-	expectedTypes := []ast.TokenType{ast.IDENTIFIER_LITERAL, ast.EQUAL, ast.NUMBER_LITERAL}
+	expectedTypes := []ast.TokenType{ast.IDENTIFIER_LITERAL, ast.EQUAL, ast.INTEGER_LITERAL}
 	for i, exp := range expectedTypes {
 		if tokens[i].Token != exp {
 			t.Errorf("token %d: expected %v, got %v", i, exp, tokens[i].Token)
@@ -536,6 +654,28 @@ func TestTokenizeSimpleAssignment(t *testing.T) {
 	}
 }
 
+func TestTokenizeCompoundAssignments(t *testing.T) {
+	tokens, err := internal.Tokenize("a += 1; b -= -1; c *= 2; d /= 4; e => f")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{ast.PLUS_EQUAL, ast.MINUS_EQUAL, ast.STAR_EQUAL, ast.SLASH_EQUAL, ast.ARROW}
+	var operators []ast.TokenType
+	for _, token := range tokens {
+		if token.Token != ast.IDENTIFIER_LITERAL && token.Token != ast.INTEGER_LITERAL && token.Token != ast.SEMICOLON && token.Token != ast.Minus {
+			operators = append(operators, token.Token)
+		}
+	}
+	if len(operators) != len(expected) {
+		t.Fatalf("expected operators %v, got %v", expected, operators)
+	}
+	for i, tokenType := range expected {
+		if operators[i] != tokenType {
+			t.Errorf("operator %d: expected %v, got %v", i, tokenType, operators[i])
+		}
+	}
+}
+
 func TestTokenizeAssignmentWithExpression(t *testing.T) {
 	tokens, err := internal.Tokenize("x = 1 + 2")
 	if err != nil {
@@ -544,7 +684,7 @@ func TestTokenizeAssignmentWithExpression(t *testing.T) {
 	if len(tokens) != 5 {
 		t.Fatalf("expected 5 tokens, got %d", len(tokens))
 	}
-	expectedTypes := []ast.TokenType{ast.IDENTIFIER_LITERAL, ast.EQUAL, ast.NUMBER_LITERAL, ast.Plus, ast.NUMBER_LITERAL}
+	expectedTypes := []ast.TokenType{ast.IDENTIFIER_LITERAL, ast.EQUAL, ast.INTEGER_LITERAL, ast.Plus, ast.INTEGER_LITERAL}
 	for i, exp := range expectedTypes {
 		if tokens[i].Token != exp {
 			t.Errorf("token %d: expected %v, got %v", i, exp, tokens[i].Token)
@@ -561,8 +701,8 @@ func TestTokenizeAssignmentWithComplexExpression(t *testing.T) {
 		t.Fatalf("expected 9 tokens, got %d", len(tokens))
 	}
 	expectedTypes := []ast.TokenType{
-		ast.IDENTIFIER_LITERAL, ast.EQUAL, ast.Open_Parentheses, ast.NUMBER_LITERAL, ast.Plus, ast.NUMBER_LITERAL,
-		ast.Close_Parentheses, ast.Multiplication, ast.NUMBER_LITERAL,
+		ast.IDENTIFIER_LITERAL, ast.EQUAL, ast.Open_Parentheses, ast.INTEGER_LITERAL, ast.Plus, ast.INTEGER_LITERAL,
+		ast.Close_Parentheses, ast.Multiplication, ast.INTEGER_LITERAL,
 	}
 	for i, exp := range expectedTypes {
 		if tokens[i].Token != exp {
@@ -620,3 +760,555 @@ func TestTokenizeAssignmentWithIdentifierExpression(t *testing.T) {
 		}
 	}
 }
+
+// Comparison and equality operators
+
+func TestTokenizeComparisonOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.TokenType
+	}{
+		{"==", ast.EQUAL_EQUAL},
+		{"!=", ast.BANG_EQUAL},
+		{"<", ast.LESS},
+		{"<=", ast.LESS_EQUAL},
+		{">", ast.GREATER},
+		{">=", ast.GREATER_EQUAL},
+	}
+
+	for _, tc := range tests {
+		tokens, err := internal.Tokenize(tc.input)
+		if err != nil {
+			t.Fatalf("unexpected error for '%s': %v", tc.input, err)
+		}
+		if len(tokens) != 1 {
+			t.Fatalf("expected 1 token for '%s', got %d", tc.input, len(tokens))
+		}
+		if tokens[0].Token != tc.expected || tokens[0].Literal != tc.input {
+			t.Errorf("for '%s': expected %v, got %v '%s'", tc.input, tc.expected, tokens[0].Token, tokens[0].Literal)
+		}
+	}
+}
+
+func TestTokenizeComparisonWithoutSpaces(t *testing.T) {
+	tokens, err := internal.Tokenize("x>=10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{ast.IDENTIFIER_LITERAL, ast.GREATER_EQUAL, ast.INTEGER_LITERAL}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("token %d: expected %v, got %v", i, tokenType, tokens[i].Token)
+		}
+	}
+}
+
+func TestTokenizeEqualFollowedByEqualEqual(t *testing.T) {
+	tokens, err := internal.Tokenize("x = a == b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 5 {
+		t.Fatalf("expected 5 tokens, got %d", len(tokens))
+	}
+	if tokens[1].Token != ast.EQUAL {
+		t.Errorf("expected EQUAL, got %v", tokens[1].Token)
+	}
+	if tokens[3].Token != ast.EQUAL_EQUAL {
+		t.Errorf("expected EQUAL_EQUAL, got %v", tokens[3].Token)
+	}
+}
+
+func TestTokenizeLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ast.TokenType
+	}{
+		{"&&", ast.AND},
+		{"||", ast.OR},
+		{"!", ast.BANG},
+		{"and", ast.AND},
+		{"or", ast.OR},
+		{"not", ast.BANG},
+	}
+
+	for _, tc := range tests {
+		tokens, err := internal.Tokenize(tc.input)
+		if err != nil {
+			t.Fatalf("unexpected error for '%s': %v", tc.input, err)
+		}
+		if len(tokens) != 1 {
+			t.Fatalf("expected 1 token for '%s', got %d", tc.input, len(tokens))
+		}
+		if tokens[0].Token != tc.expected {
+			t.Errorf("for '%s': expected %v, got %v", tc.input, tc.expected, tokens[0].Token)
+		}
+	}
+}
+
+func TestTokenizeBangBeforeBangEqual(t *testing.T) {
+	tokens, err := internal.Tokenize("!a != !b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{ast.BANG, ast.IDENTIFIER_LITERAL, ast.BANG_EQUAL, ast.BANG, ast.IDENTIFIER_LITERAL}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("token %d: expected %v, got %v", i, tokenType, tokens[i].Token)
+		}
+	}
+}
+
+func TestTokenizeKeywordPrefixIsIdentifier(t *testing.T) {
+	tokens, err := internal.Tokenize("order")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].Token != ast.IDENTIFIER_LITERAL {
+		t.Errorf("expected a single identifier, got %v", tokens)
+	}
+}
+
+func TestTokenizeSingleAmpersand(t *testing.T) {
+	tokens, err := internal.Tokenize("a & b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 3 || tokens[1].Token != ast.BIT_AND {
+		t.Errorf("expected a, '&' and b, got %v", tokens)
+	}
+}
+
+// Statement separators
+
+func TestTokenizeSemicolon(t *testing.T) {
+	tokens, err := internal.Tokenize("var x; x = 3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 6 {
+		t.Fatalf("expected 6 tokens, got %d", len(tokens))
+	}
+	if tokens[2].Token != ast.SEMICOLON {
+		t.Errorf("expected SEMICOLON, got %v", tokens[2].Token)
+	}
+}
+
+func TestTokenizeNewline(t *testing.T) {
+	tokens, err := internal.Tokenize("1\r\n2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{ast.INTEGER_LITERAL, ast.NEWLINE, ast.INTEGER_LITERAL}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("token %d: expected %v, got %v", i, tokenType, tokens[i].Token)
+		}
+	}
+}
+
+func TestTokenizeNewlineInsideParentheses(t *testing.T) {
+	tokens, err := internal.Tokenize("(1 +\n 2)\n3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{
+		ast.Open_Parentheses, ast.INTEGER_LITERAL, ast.Plus, ast.INTEGER_LITERAL, ast.Close_Parentheses,
+		ast.NEWLINE, ast.INTEGER_LITERAL,
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("token %d: expected %v, got %v", i, tokenType, tokens[i].Token)
+		}
+	}
+}
+
+// Source positions
+
+func TestTokenizePositions(t *testing.T) {
+	tokens, err := internal.Tokenize("x = 12\n  y")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.Span{
+		{Start: ast.Position{Offset: 0, Line: 1, Column: 1}, End: ast.Position{Offset: 1, Line: 1, Column: 2}},
+		{Start: ast.Position{Offset: 2, Line: 1, Column: 3}, End: ast.Position{Offset: 3, Line: 1, Column: 4}},
+		{Start: ast.Position{Offset: 4, Line: 1, Column: 5}, End: ast.Position{Offset: 6, Line: 1, Column: 7}},
+		{Start: ast.Position{Offset: 6, Line: 1, Column: 7}, End: ast.Position{Offset: 7, Line: 2, Column: 1}},
+		{Start: ast.Position{Offset: 9, Line: 2, Column: 3}, End: ast.Position{Offset: 10, Line: 2, Column: 4}},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, span := range expected {
+		if tokens[i].Span != span {
+			t.Errorf("token %d '%s': expected span %+v, got %+v", i, tokens[i].Literal, span, tokens[i].Span)
+		}
+	}
+}
+
+func TestTokenizeErrorPosition(t *testing.T) {
+	_, err := internal.Tokenize("1 +\n 2 @ 3")
+	if err == nil {
+		t.Fatal("expected error for unrecognized character '@', got nil")
+	}
+	diagnostic, ok := err.(*ast.Diagnostic)
+	if !ok {
+		t.Fatalf("expected *ast.Diagnostic, got %T", err)
+	}
+	if diagnostic.Span.Start != (ast.Position{Offset: 7, Line: 2, Column: 4}) {
+		t.Errorf("unexpected error position %+v", diagnostic.Span.Start)
+	}
+	if !strings.Contains(err.Error(), "2:4") {
+		t.Errorf("expected error to mention line and column, got: %v", err)
+	}
+}
+
+// Functions
+
+func TestTokenizeFunctionDeclaration(t *testing.T) {
+	tokens, err := internal.Tokenize("fn area(w, h) { w * h }")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{
+		ast.FN, ast.IDENTIFIER_LITERAL, ast.Open_Parentheses, ast.IDENTIFIER_LITERAL, ast.COMMA,
+		ast.IDENTIFIER_LITERAL, ast.Close_Parentheses, ast.Open_Brace, ast.IDENTIFIER_LITERAL,
+		ast.Multiplication, ast.IDENTIFIER_LITERAL, ast.Close_Brace,
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("token %d: expected %v, got %v", i, tokenType, tokens[i].Token)
+		}
+	}
+}
+
+func TestTokenizeArrow(t *testing.T) {
+	tokens, err := internal.Tokenize("fn(x) => x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 6 {
+		t.Fatalf("expected 6 tokens, got %d", len(tokens))
+	}
+	if tokens[4].Token != ast.ARROW || tokens[4].Literal != "=>" {
+		t.Errorf("expected ARROW, got %v '%s'", tokens[4].Token, tokens[4].Literal)
+	}
+}
+
+func TestTokenizeNewlineInsideBraces(t *testing.T) {
+	// Newlines separate statements in a block, even inside parentheses
+	tokens, err := internal.Tokenize("f(fn() {\n1\n})")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newlines := 0
+	for _, token := range tokens {
+		if token.Token == ast.NEWLINE {
+			newlines++
+		}
+	}
+	if newlines != 2 {
+		t.Errorf("expected 2 newlines, got %d", newlines)
+	}
+}
+
+func TestTokenizeIdentifierWithDigits(t *testing.T) {
+	tokens, err := internal.Tokenize("atan2(y1, 2)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 6 {
+		t.Fatalf("expected 6 tokens, got %d", len(tokens))
+	}
+	if tokens[0].Token != ast.IDENTIFIER_LITERAL || tokens[0].Literal != "atan2" {
+		t.Errorf("expected identifier 'atan2', got %v '%s'", tokens[0].Token, tokens[0].Literal)
+	}
+	if tokens[2].Token != ast.IDENTIFIER_LITERAL || tokens[2].Literal != "y1" {
+		t.Errorf("expected identifier 'y1', got %v '%s'", tokens[2].Token, tokens[2].Literal)
+	}
+}
+
+func TestTokenizeLineComment(t *testing.T) {
+	tokens, err := internal.Tokenize("price * 2 # doubled, see /* note */\n+ 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	literals := []string{}
+	for _, token := range tokens {
+		literals = append(literals, token.Literal)
+	}
+	if strings.Join(literals, " ") != "price * 2 \n + 1" {
+		t.Errorf("expected the comment to be skipped, got %q", literals)
+	}
+}
+
+func TestTokenizeBlockComment(t *testing.T) {
+	tokens, err := internal.Tokenize("1 /* one */ + /* # two */ 2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("expected 3 tokens, got %d", len(tokens))
+	}
+	if tokens[2].Span.Start.Column != 27 {
+		t.Errorf("expected 2 at column 27, got %d", tokens[2].Span.Start.Column)
+	}
+}
+
+func TestTokenizeMultiLineBlockCommentSeparates(t *testing.T) {
+	tokens, err := internal.Tokenize("a /* one\ntwo */ b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 3 || tokens[1].Token != ast.NEWLINE {
+		t.Fatalf("expected a newline between a and b, got %v", tokens)
+	}
+	if tokens[2].Span.Start.Line != 2 || tokens[2].Span.Start.Column != 8 {
+		t.Errorf("expected b at 2:8, got %s", tokens[2].Span.Start)
+	}
+
+	tokens, err = internal.Tokenize("(a /* one\ntwo */ + b)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, token := range tokens {
+		if token.Token == ast.NEWLINE {
+			t.Errorf("expected no newline inside parentheses, got %v", tokens)
+		}
+	}
+}
+
+func TestTokenizeUnterminatedBlockComment(t *testing.T) {
+	_, err := internal.Tokenize("1 +\n  /* two\n 2")
+	var diagnostic *ast.Diagnostic
+	if !errors.As(err, &diagnostic) {
+		t.Fatalf("expected a diagnostic, got %v", err)
+	}
+	if diagnostic.Error() != "2:3: unterminated block comment" {
+		t.Errorf("expected '2:3: unterminated block comment', got '%s'", diagnostic.Error())
+	}
+	if diagnostic.Span.End.Column != 5 {
+		t.Errorf("expected the span to cover '/*', got %s-%s", diagnostic.Span.Start, diagnostic.Span.End)
+	}
+}
+
+func TestTokenizeCommentsAreDroppedByDefault(t *testing.T) {
+	tokens, err := internal.Tokenize("# a\nx # b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, token := range tokens {
+		if token.Trivia != nil {
+			t.Errorf("expected no trivia, got %v on %s", token.Trivia, token.Literal)
+		}
+	}
+}
+
+func TestTokenizeKeepComments(t *testing.T) {
+	lexer := internal.NewLexer("# total\nvar total # in cents\n/* a */ total = /* b */ 1\n# end")
+	lexer.KeepComments = true
+	all, err := lexer.Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tokens := []ast.Token{}
+	for _, token := range all {
+		if token.Token != ast.NEWLINE {
+			tokens = append(tokens, token)
+		}
+	}
+
+	comments := lexer.Comments()
+	texts := []string{}
+	for _, comment := range comments {
+		texts = append(texts, comment.Text)
+	}
+	if strings.Join(texts, "|") != "# total|# in cents|/* a */|/* b */|# end" {
+		t.Errorf("unexpected comments %q", texts)
+	}
+	if comments[1].Span.Start.String() != "2:11" || comments[1].IsBlock() || !comments[2].IsBlock() {
+		t.Errorf("unexpected second and third comments %v", comments[1:3])
+	}
+
+	trivia := func(index int) (leading []string, trailing []string) {
+		if tokens[index].Trivia == nil {
+			return nil, nil
+		}
+		for _, comment := range tokens[index].Trivia.Leading {
+			leading = append(leading, comment.Text)
+		}
+		for _, comment := range tokens[index].Trivia.Trailing {
+			trailing = append(trailing, comment.Text)
+		}
+		return leading, trailing
+	}
+	expected := []struct {
+		leading  string
+		trailing string
+	}{
+		{"# total", ""},
+		{"", "# in cents"},
+		{"/* a */", ""},
+		{"", "/* b */"},
+		{"", "# end"},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %v", len(expected), tokens)
+	}
+	for i, test := range expected {
+		leading, trailing := trivia(i)
+		if strings.Join(leading, "|") != test.leading || strings.Join(trailing, "|") != test.trailing {
+			t.Errorf("expected trivia %q/%q on '%s', got %q/%q", test.leading, test.trailing, tokens[i].Literal, leading, trailing)
+		}
+	}
+}
+
+func TestTokenizeStringLiterals(t *testing.T) {
+	tokens, err := internal.Tokenize(`"double" + 'single' + "it's" + '"q"'`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{`"double"`, "+", `'single'`, "+", `"it's"`, "+", `'"q"'`}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %v", len(expected), tokens)
+	}
+	for i, literal := range expected {
+		if tokens[i].Literal != literal {
+			t.Errorf("expected token %d to be %s, got %s", i, literal, tokens[i].Literal)
+		}
+	}
+	if tokens[0].Token != ast.STRING_LITERAL || tokens[2].Token != ast.STRING_LITERAL {
+		t.Errorf("expected string literals, got %s and %s", tokens[0].Token, tokens[2].Token)
+	}
+}
+
+func TestTokenizeStringEscapes(t *testing.T) {
+	tokens, err := internal.Tokenize(`"a\"b\\c\n\$é" 1`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Literal != `"a\"b\\c\n\$é"` {
+		t.Fatalf("expected the escapes to stay in the literal, got %v", tokens)
+	}
+	value, _, err := ast.Unescape(tokens[0].Literal[1 : len(tokens[0].Literal)-1])
+	if err != nil || value != "a\"b\\c\n$é" {
+		t.Errorf("expected the escapes to be replaced, got %q (%v)", value, err)
+	}
+}
+
+func TestTokenizeInterpolation(t *testing.T) {
+	tokens, err := internal.Tokenize(`"a ${x + "${y}"} b ${ {z} } c"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []struct {
+		token   ast.TokenType
+		literal string
+	}{
+		{ast.TEMPLATE_START, `"a ${`},
+		{ast.IDENTIFIER_LITERAL, "x"},
+		{ast.Plus, "+"},
+		{ast.TEMPLATE_START, `"${`},
+		{ast.IDENTIFIER_LITERAL, "y"},
+		{ast.TEMPLATE_END, `}"`},
+		{ast.TEMPLATE_MIDDLE, "} b ${"},
+		{ast.Open_Brace, "{"},
+		{ast.IDENTIFIER_LITERAL, "z"},
+		{ast.Close_Brace, "}"},
+		{ast.TEMPLATE_END, `} c"`},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %v", len(expected), tokens)
+	}
+	for i, test := range expected {
+		if tokens[i].Token != test.token || tokens[i].Literal != test.literal {
+			t.Errorf("expected token %d to be %s %s, got %s %s", i, test.token, test.literal, tokens[i].Token, tokens[i].Literal)
+		}
+	}
+}
+
+func TestTokenizeNewlineInInterpolation(t *testing.T) {
+	tokens, err := internal.Tokenize("\"${1 +\n 2}\"")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, token := range tokens {
+		if token.Token == ast.NEWLINE {
+			t.Errorf("expected no newline inside an interpolation, got %v", tokens)
+		}
+	}
+}
+
+func TestTokenizeStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + "abc`, "1:5: unterminated string"},
+		{"'abc\n'", "1:1: unterminated string"},
+		{`"abc\`, "1:1: unterminated string"},
+		{`x = "a ${b`, "1:5: unterminated string"},
+		{`x = "a ${b} c`, "1:5: unterminated string"},
+		{`"a\qb"`, `1:3: invalid escape sequence '\q'`},
+		{`"${x} \u12"`, `1:7: invalid escape sequence '\u'`},
+	}
+	for _, test := range tests {
+		_, err := internal.Tokenize(test.input)
+		if err == nil {
+			t.Errorf("expected an error for %q, got nil", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for %q, got '%s'", test.expected, test.input, err.Error())
+		}
+	}
+}
+
+func TestTokenizeConditional(t *testing.T) {
+	tokens, err := internal.Tokenize("if a then b else c ? d : e")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{ast.IF, ast.IDENTIFIER_LITERAL, ast.THEN, ast.IDENTIFIER_LITERAL, ast.ELSE, ast.IDENTIFIER_LITERAL,
+		ast.QUESTION, ast.IDENTIFIER_LITERAL, ast.COLON, ast.IDENTIFIER_LITERAL}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %v", len(expected), tokens)
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("expected token %d to be %s, got %s", i, tokenType, tokens[i].Token)
+		}
+	}
+}
+
+func TestTokenizeLoopKeywords(t *testing.T) {
+	tokens, err := internal.Tokenize("while for in break continue range")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ast.TokenType{ast.WHILE, ast.FOR, ast.IN, ast.BREAK, ast.CONTINUE, ast.IDENTIFIER_LITERAL}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %v", len(expected), tokens)
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Token != tokenType {
+			t.Errorf("expected token %d to be %s, got %s", i, tokenType, tokens[i].Token)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Helper to parse and optimize input
+func optimize(t *testing.T, input string, passes ...ast.Pass) (ast.Expression, ast.Expression) {
+	t.Helper()
+	exp, err := internal.Parse(tokens(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return exp, ast.Optimize(exp, passes...)
+}
+
+func TestConstantFolding(t *testing.T) {
+	_, optimized := optimize(t, "2 * (3 + 4) * rate", ast.ConstantFolding{})
+	binary, ok := optimized.(*ast.BinaryExpression)
+	if !ok {
+		t.Fatalf("expected a binary expression, got %T", optimized)
+	}
+	constant, ok := binary.Lhs.(*ast.CONSTANT)
+	if !ok || constant.TokenLiteral.Literal != "14" {
+		t.Errorf("expected 2 * (3 + 4) to fold to 14, got %#v", binary.Lhs)
+	}
+	if span := constant.Span(); span.Start.Offset != 0 || span.End.Offset != 10 {
+		t.Errorf("expected the folded constant to span 2 * (3 + 4, got %d-%d", span.Start.Offset, span.End.Offset)
+	}
+	if _, ok := binary.Rhs.(*ast.Identifier); !ok {
+		t.Errorf("expected rate to be kept, got %T", binary.Rhs)
+	}
+}
+
+func TestConstantFoldingValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"-(2 - 5)", "3"},
+		{"1.0 / 4", "0.25"},
+		{"6 / 3", "2"},
+		{"99999999999999999999 + 1", "100000000000000000000"},
+		{"0xff + 0b1", "256"},
+		{"1_000 * 2", "2000"},
+		{"1e20 * 10", "1e+21"},
+		{"1 < 2", "true"},
+		{"!(1 == 2)", "true"},
+		{"false && x", "false"},
+		{"true || x", "true"},
+		{"true && 1 > 2", "false"},
+		{`"a" + 'b'`, `"ab"`},
+		{`"a" < "b"`, "true"},
+		{`"n = ${1 + 2}, ${true}"`, `"n = 3, true"`},
+		{`"${"\n"}" + "$" + "{x}"`, `"\n\${x}"`},
+	}
+	for _, test := range tests {
+		_, optimized := optimize(t, test.input, ast.ConstantFolding{})
+		constant, ok := optimized.(*ast.CONSTANT)
+		if !ok || constant.TokenLiteral.Literal != test.expected {
+			t.Errorf("expected '%s' to fold to %s, got %#v", test.input, test.expected, optimized)
+		}
+	}
+}
+
+func TestConstantFoldingKeepsErrors(t *testing.T) {
+	for _, input := range []string{"1 / 0", "1 + true", "-true", "true && 1", "true && x", `"a" + 1`, `"${x}"`} {
+		original, optimized := optimize(t, input, ast.ConstantFolding{})
+		if optimized != original {
+			t.Errorf("expected '%s' to be left unchanged", input)
+		}
+	}
+}
+
+func TestConstantFoldingKeepsRationals(t *testing.T) {
+	original, optimized := optimize(t, "1 / 3", ast.ConstantFolding{})
+	if optimized != original {
+		t.Errorf("expected 1 / 3, which has no literal, to be left unchanged")
+	}
+}
+
+func TestAlgebraicSimplification(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(a + 1) * 1", "+"},
+		{"1 * (a - b)", "-"},
+		{"(a * b) + 0", "*"},
+		{"0 + (a / b)", "/"},
+		{"(1 + b) - 0", "+"},
+		{"(a - b + 1) / 1", "+"},
+		{"--(a * b)", "*"},
+		{"!!(a < b)", "<"},
+	}
+	for _, test := range tests {
+		_, optimized := optimize(t, test.input, ast.AlgebraicSimplification{})
+		binary, ok := optimized.(*ast.BinaryExpression)
+		if !ok || binary.Operator.Literal != test.expected {
+			t.Errorf("expected '%s' to simplify to a '%s' expression, got %#v", test.input, test.expected, optimized)
+		}
+	}
+}
+
+func TestAlgebraicSimplificationKeepsTypeErrors(t *testing.T) {
+	for _, input := range []string{"x * 1", "flag + 0", "--x", "!!x", "f() * 1", "true * 1", "(a + b) * 1", `"a" + 0`} {
+		original, optimized := optimize(t, input, ast.AlgebraicSimplification{})
+		if optimized != original {
+			t.Errorf("expected '%s' to be left unchanged", input)
+		}
+	}
+}
+
+func TestDeadBranchElimination(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if true then a else b", "a"},
+		{"false ? a : b", "b"},
+		{"true ? (false ? a : b) : c", "b"},
+	}
+	for _, test := range tests {
+		_, optimized := optimize(t, test.input, ast.DeadBranchElimination{})
+		identifier, ok := optimized.(*ast.Identifier)
+		if !ok || identifier.TokenLiteral.Literal != test.expected {
+			t.Errorf("expected '%s' to reduce to %s, got %#v", test.input, test.expected, optimized)
+		}
+	}
+	for _, input := range []string{"c ? a : b", "1 ? a : b", `"" ? a : b`} {
+		original, optimized := optimize(t, input, ast.DeadBranchElimination{})
+		if optimized != original {
+			t.Errorf("expected '%s' to be left unchanged", input)
+		}
+	}
+
+	// The condition is folded first
+	_, optimized := optimize(t, "if 1 < 2 && !false then x else y * 1")
+	if identifier, ok := optimized.(*ast.Identifier); !ok || identifier.TokenLiteral.Literal != "x" {
+		t.Errorf("expected the optimized expression to be x, got %#v", optimized)
+	}
+}
+
+func TestOptimizeRunsPassesToFixpoint(t *testing.T) {
+	_, optimized := optimize(t, "fn f(x) { (x * x) * (3 - 2) + (1 - 1) }")
+	declaration := optimized.(*ast.FunctionDeclaration)
+	binary, ok := declaration.Body.Statements[0].(*ast.BinaryExpression)
+	if !ok || binary.Operator.Literal != "*" {
+		t.Fatalf("expected the body to simplify to x * x, got %#v", declaration.Body.Statements[0])
+	}
+	if _, ok := binary.Lhs.(*ast.Identifier); !ok {
+		t.Errorf("expected x * x, got %#v", binary)
+	}
+}
+
+func TestOptimizeDoesNotModifyOriginal(t *testing.T) {
+	original, optimized := optimize(t, "var y; y = 2 * 3; y * (1 + 1)")
+	if optimized == original {
+		t.Fatal("expected the program to be optimized")
+	}
+	statements := original.(*ast.Program).Statements
+	if _, ok := statements[1].(*ast.Assignement).Rhs.(*ast.BinaryExpression); !ok {
+		t.Error("expected the original program to be left unchanged")
+	}
+}
+
+func TestOptimizePreservesResults(t *testing.T) {
+	inputs := []string{
+		"2 * (3 + 4) * 1.5",
+		"var rate; rate = 3; 2 * (3 + 4) * rate",
+		"fn f(x) { x * (2 - 1) + 0 }; f(21) * 2",
+		"var a; a = 5; --(a * 2) > 3 && !!(1 < 2)",
+		"(fn(x) => x + 0 * 2)(4)",
+		"-(-(1 / 3))",
+		"0x10 * 1e20 + 1_0",
+		"1 + true",
+		"1 / 0",
+		`"a" + "b" + "${1 + 1}"`,
+		`var s; s = "x"; (s + s) * 1`,
+		"if 2 > 1 then 3 * 1 else 1 / 0",
+		"(1 > 2 ? 1 : true) + 0",
+		"2 ? 1 : 0",
+	}
+	for _, input := range inputs {
+		exp, err := internal.Parse(tokens(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected, expectedErr := (&ast.Evaluator{DisableOSEnv: true}).Evaluate(exp)
+		value, err := (&ast.Evaluator{DisableOSEnv: true}).Evaluate(ast.Optimize(exp))
+		if (err == nil) != (expectedErr == nil) || err != nil && err.Error() != expectedErr.Error() {
+			t.Errorf("expected error %v for '%s', got %v", expectedErr, input, err)
+			continue
+		}
+		if err == nil && !sameValue(value, expected) {
+			t.Errorf("expected %v for '%s', got %v", expected, input, value)
+		}
+	}
+}
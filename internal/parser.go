@@ -6,88 +6,449 @@ import (
 	"github.com/jayjunior/eval/internal/ast"
 )
 
-var current = 0
-var Tokens []ast.Token = nil
-var parseError error = nil
+// Parser holds the parsing state for a single token stream, so separate
+// parsers can be used concurrently.
+type Parser struct {
+	tokens      []ast.Token
+	current     int
+	parseError  *ast.Diagnostic
+	diagnostics ast.Diagnostics
+	// Number of loops enclosing the current statement in the current function
+	loops int
+}
+
+func NewParser(tokens []ast.Token) *Parser {
+	return &Parser{tokens: tokens}
+}
 
 func Parse(tokens []ast.Token) (ast.Expression, error) {
-	current = 0
-	parseError = nil
-	Tokens = tokens
+	return NewParser(tokens).Parse()
+}
+
+// Parse parses a program of statements separated by ';' or newlines. A
+// program made of a single statement is returned as that statement, anything
+// longer as an *ast.Program.
+//
+// Parsing does not stop at the first syntax error: the parser skips to the
+// next statement or closing parenthesis and carries on, so the returned
+// ast.Diagnostics lists every error found. The partial AST is returned along
+// with them, with the parts that could not be parsed replaced by
+// *ast.BadExpression nodes.
+func (this *Parser) Parse() (ast.Expression, error) {
+	this.current = 0
+	this.parseError = nil
+	this.diagnostics = nil
+	this.loops = 0
+
+	this.skipSeparators()
+	if this.isAtEnd() {
+		return nil, ast.Diagnostics{{Message: "empty input: no tokens to parse"}}
+	}
+	statements := this.statements(false)
+	for !this.isAtEnd() {
+		// Only a stray '}' stops the statements of the top level
+		this.report(this.unexpectedToken(""))
+		this.consume()
+		statements = append(statements, this.statements(false)...)
+	}
+
+	var program ast.Expression = &ast.Program{Statements: statements}
+	if len(statements) == 1 {
+		program = statements[0]
+	}
+	if len(this.diagnostics) > 0 {
+		return program, this.diagnostics
+	}
+	return program, nil
+}
+
+// statements parses statements separated by ';' or newlines up to the end of
+// the input or, inside a block, up to the closing brace. Statements that fail
+// to parse are reported and replaced by *ast.BadExpression nodes.
+func (this *Parser) statements(inBlock bool) []ast.Expression {
+	statements := make([]ast.Expression, 0)
+	this.skipSeparators()
+	for !this.isAtEnd() && !this.match(ast.Close_Brace) {
+		start := this.current
+		statement := this.statement()
+		if this.parseError != nil {
+			this.report(this.parseError)
+			this.synchronize()
+			statement = this.badExpression(start)
+		} else if !this.isAtEnd() && !this.isSeparator() && !(inBlock && this.match(ast.Close_Brace)) {
+			this.report(this.unexpectedToken("expected ';' or newline"))
+			this.synchronize()
+		}
+		statements = append(statements, statement)
+		this.skipSeparators()
+	}
+	return statements
+}
+
+// report records a syntax error and clears the current one so parsing can
+// resume.
+func (this *Parser) report(diagnostic *ast.Diagnostic) {
+	if n := len(this.diagnostics); n == 0 || this.diagnostics[n-1] != diagnostic {
+		this.diagnostics = append(this.diagnostics, diagnostic)
+	}
+	this.parseError = nil
+}
+
+// synchronize skips the rest of a statement that failed to parse, including
+// any block it opened.
+func (this *Parser) synchronize() {
+	depth := 0
+	for !this.isAtEnd() {
+		if depth == 0 && (this.isSeparator() || this.match(ast.Close_Brace)) {
+			return
+		}
+		if this.match(ast.Open_Brace) {
+			depth++
+		} else if this.match(ast.Close_Brace) {
+			depth--
+		}
+		this.consume()
+	}
+}
+
+// badExpression stands for the tokens from start up to the current one.
+func (this *Parser) badExpression(start int) *ast.BadExpression {
+	if start >= len(this.tokens) {
+		start = len(this.tokens) - 1
+	}
+	end := max(this.current-1, start)
+	return &ast.BadExpression{From: this.tokens[start], To: this.tokens[end]}
+}
+
+func (this *Parser) statement() ast.Expression {
+	if this.match(ast.FN) && this.match_next(ast.IDENTIFIER_LITERAL) {
+		return this.functionDeclaration()
+	}
+	if this.match(ast.VAR) {
+		return this.varDeclaration()
+	}
+	if this.match(ast.WHILE) {
+		return this.whileLoop()
+	}
+	if this.match(ast.FOR) {
+		return this.forLoop()
+	}
+	if this.match(ast.BREAK) || this.match(ast.CONTINUE) {
+		return this.loopControl()
+	}
+	if this.match(ast.Open_Brace) {
+		if block := this.block(); block != nil {
+			return block
+		}
+		return nil
+	}
+	return this.expression()
+}
+
+func (this *Parser) whileLoop() ast.Expression {
+	keyword := this.consume() // while
+	condition := this.expression()
+	if this.parseError != nil {
+		return nil
+	}
+	body := this.loopBody()
+	if this.parseError != nil {
+		return nil
+	}
+	return &ast.While{Keyword: keyword, Condition: condition, Body: body}
+}
+
+// forLoop parses `for variable in range(start, end) { body }`.
+func (this *Parser) forLoop() ast.Expression {
+	keyword := this.consume() // for
+	variable := this.identifier()
+	if this.parseError != nil || !this.expect(ast.IN, "expected 'in'") {
+		return nil
+	}
+	if this.match(ast.IDENTIFIER_LITERAL) && this.tokens[this.current].Literal != "range" {
+		this.parseError = this.unexpectedToken("expected 'range'")
+		return nil
+	}
+	if !this.expect(ast.IDENTIFIER_LITERAL, "expected 'range'") || !this.expect(ast.Open_Parentheses, "expected '('") {
+		return nil
+	}
+	start := this.expression()
+	if !this.expect(ast.COMMA, "expected ','") {
+		return nil
+	}
+	end := this.expression()
+	if !this.expect(ast.Close_Parentheses, "expected ')'") {
+		return nil
+	}
+	body := this.loopBody()
+	if this.parseError != nil {
+		return nil
+	}
+	return &ast.For{Keyword: keyword, Variable: variable, Start: start, End: end, Body: body}
+}
+
+// loopBody parses the block of a loop, in which break and continue are
+// allowed.
+func (this *Parser) loopBody() *ast.Block {
+	this.loops++
+	defer func() { this.loops-- }()
+	return this.block()
+}
+
+func (this *Parser) loopControl() ast.Expression {
+	keyword := this.consume() // break or continue
+	if this.loops == 0 {
+		this.parseError = &ast.Diagnostic{Span: keyword.Span, Message: keyword.Literal + " outside of a loop"}
+		return nil
+	}
+	if keyword.Token == ast.BREAK {
+		return &ast.Break{Keyword: keyword}
+	}
+	return &ast.Continue{Keyword: keyword}
+}
 
-	if len(tokens) == 0 {
-		return nil, fmt.Errorf("empty input: no tokens to parse")
+func (this *Parser) functionDeclaration() ast.Expression {
+	keyword := this.consume() // fn
+	name := this.identifier()
+	parameters := this.parameters()
+	if this.parseError != nil {
+		return nil
 	}
-	var statement ast.Expression
+	// break and continue do not reach the loops enclosing a function
+	loops := this.loops
+	this.loops = 0
+	body := this.block()
+	this.loops = loops
+	if this.parseError != nil {
+		return nil
+	}
+	return &ast.FunctionDeclaration{Keyword: keyword, Name: name, Parameters: parameters, Body: body}
+}
 
-	if match(ast.VAR) {
-		statement = varDeclaration()
-	} else if match(ast.IDENTIFIER_LITERAL) && match_next(ast.EQUAL) {
-		statement = assignement()
+func (this *Parser) lambda() ast.Expression {
+	keyword := this.consume() // fn
+	parameters := this.parameters()
+	if this.parseError != nil {
+		return nil
+	}
+	var body ast.Expression
+	loops := this.loops
+	this.loops = 0
+	defer func() { this.loops = loops }()
+	if this.match(ast.ARROW) {
+		this.consume()
+		body = this.expression()
+	} else if this.match(ast.Open_Brace) {
+		body = this.block()
+	} else if this.isAtEnd() {
+		this.parseError = this.unexpectedEnd("expected '=>' or '{'")
 	} else {
-		statement = expression()
+		this.parseError = this.unexpectedToken("expected '=>' or '{'")
 	}
-	if parseError != nil {
-		return nil, parseError
+	if this.parseError != nil {
+		return nil
 	}
+	return &ast.Lambda{Keyword: keyword, Parameters: parameters, Body: body}
+}
 
-	if current < len(Tokens) {
-		return nil, fmt.Errorf("unexpected token '%s' at position %d", Tokens[current].Literal, current)
+// parameters parses a parenthesized, comma separated list of identifiers.
+func (this *Parser) parameters() []ast.Identifier {
+	if this.parseError != nil {
+		return nil
 	}
+	if !this.expect(ast.Open_Parentheses, "expected '('") {
+		return nil
+	}
+	parameters := make([]ast.Identifier, 0)
+	for !this.match(ast.Close_Parentheses) {
+		if len(parameters) > 0 && !this.expect(ast.COMMA, "expected ',' or ')'") {
+			return nil
+		}
+		parameter := this.identifier()
+		if this.parseError != nil {
+			return nil
+		}
+		parameters = append(parameters, parameter)
+	}
+	this.consume() // )
+	return parameters
+}
 
-	return statement, nil
+func (this *Parser) block() *ast.Block {
+	open, ok := this.consumeExpected(ast.Open_Brace, "expected '{'")
+	if !ok {
+		return nil
+	}
+	statements := this.statements(true)
+	close, ok := this.consumeExpected(ast.Close_Brace, "expected '}'")
+	if !ok {
+		return nil
+	}
+	return &ast.Block{Open: open, Statements: statements, Close: close}
 }
 
-func varDeclaration() ast.Expression {
-	if parseError != nil {
+func (this *Parser) varDeclaration() ast.Expression {
+	if this.parseError != nil {
 		return nil
 	}
-	consume() // var
-	operand := identifier()
-	if parseError != nil {
+	keyword := this.consume() // var
+	operand := this.identifier()
+	if this.parseError != nil {
 		return nil
 	}
-	return &ast.VarDeclaration{Operand: operand}
+	return &ast.VarDeclaration{Keyword: keyword, Operand: operand}
 }
 
-func assignement() ast.Expression {
-	if parseError != nil {
+// isAssignement reports whether an assignement starts at the current token:
+// an identifier followed by = or a compound assignment operator.
+func (this *Parser) isAssignement() bool {
+	if !this.match(ast.IDENTIFIER_LITERAL) || this.current+1 >= len(this.tokens) {
+		return false
+	}
+	return this.tokens[this.current+1].IsAssignmentOperator()
+}
+
+// assignement parses an assignement, whose value is an expression: it is
+// right-associative, y = x = 3 being y = (x = 3).
+func (this *Parser) assignement() ast.Expression {
+	if this.parseError != nil {
 		return nil
 	}
-	lhs := identifier()
-	if !match(ast.EQUAL) {
-		parseError = fmt.Errorf("unexpected token '%s' at position %d: expected equal", Tokens[current].Literal, current)
+	lhs := this.identifier()
+	operator := this.consume() // = or a compound assignment operator
+	rhs := this.expression()
+	if this.parseError != nil {
 		return nil
 	}
-	consume() // =
-	rhs := expression()
-	return &ast.Assignement{LHS: lhs, Rhs: rhs}
+	return ast.NewAssignement(lhs, operator, rhs)
 }
 
-func identifier() ast.Identifier {
-	if isAtEnd() {
-		parseError = fmt.Errorf("unexpected end of input at position %d: expected identifier", current)
+func (this *Parser) identifier() ast.Identifier {
+	if this.isAtEnd() {
+		this.parseError = this.unexpectedEnd("expected identifier")
 		return ast.Identifier{}
 	}
-	if !match(ast.IDENTIFIER_LITERAL) {
-		parseError = fmt.Errorf("unexpected token '%s' at position %d: expected identifier", Tokens[current].Literal, current)
+	if !this.match(ast.IDENTIFIER_LITERAL) {
+		this.parseError = this.unexpectedToken("expected identifier")
 		return ast.Identifier{}
 	}
-	return ast.Identifier{TokenLiteral: consume()}
+	return ast.Identifier{TokenLiteral: this.consume()}
 }
-func expression() ast.Expression {
-	return term()
+func (this *Parser) expression() ast.Expression {
+	if this.isAssignement() {
+		return this.assignement()
+	}
+	return this.conditional()
 }
 
-func term() ast.Expression {
-	exp := factor()
-	if parseError != nil {
+// conditional parses `condition ? a : b`. Both branches are full expressions,
+// so conditionals chain to the right: a ? b : c ? d : e is a ? b : (c ? d : e).
+func (this *Parser) conditional() ast.Expression {
+	exp := this.logicOr()
+	if this.parseError != nil {
 		return nil
 	}
-	for !isAtEnd() && (match(ast.Minus) || match(ast.Plus)) {
-		operator := consume()
-		rhs := factor()
-		if parseError != nil {
+	if !this.match(ast.QUESTION) {
+		return exp
+	}
+	question := this.consume()
+	then := this.expression()
+	if !this.expect(ast.COLON, "expected ':'") {
+		return nil
+	}
+	otherwise := this.expression()
+	if this.parseError != nil {
+		return nil
+	}
+	return &ast.ConditionalExpression{Keyword: question, Condition: exp, Then: then, Else: otherwise}
+}
+
+// ifExpression parses `if condition then a else b`. As with lambdas, the else
+// branch extends as far as possible. With blocks as branches, it is
+// `if condition { a } else { b }`, the else branch being optional or another
+// if with blocks.
+func (this *Parser) ifExpression() ast.Expression {
+	keyword := this.consume() // if
+	condition := this.expression()
+	if this.parseError == nil && this.match(ast.Open_Brace) {
+		return this.ifBlock(keyword, condition)
+	}
+	if !this.expect(ast.THEN, "expected 'then' or '{'") {
+		return nil
+	}
+	then := this.expression()
+	if !this.expect(ast.ELSE, "expected 'else'") {
+		return nil
+	}
+	otherwise := this.expression()
+	if this.parseError != nil {
+		return nil
+	}
+	return &ast.ConditionalExpression{Keyword: keyword, Condition: condition, Then: then, Else: otherwise}
+}
+
+func (this *Parser) ifBlock(keyword ast.Token, condition ast.Expression) ast.Expression {
+	then := this.block()
+	if this.parseError != nil {
+		return nil
+	}
+	exp := &ast.ConditionalExpression{Keyword: keyword, Condition: condition, Then: then}
+	if !this.match(ast.ELSE) {
+		return exp
+	}
+	this.consume() // else
+	if this.match(ast.IF) {
+		exp.Else = this.ifExpression()
+	} else if block := this.block(); block != nil {
+		exp.Else = block
+	}
+	if this.parseError != nil {
+		return nil
+	}
+	return exp
+}
+
+func (this *Parser) logicOr() ast.Expression {
+	exp := this.logicAnd()
+	if this.parseError != nil {
+		return nil
+	}
+	for !this.isAtEnd() && this.match(ast.OR) {
+		operator := this.consume()
+		rhs := this.logicAnd()
+		if this.parseError != nil {
+			return nil
+		}
+		exp = &ast.LogicalExpression{Lhs: exp, Operator: operator, Rhs: rhs}
+	}
+	return exp
+}
+
+func (this *Parser) logicAnd() ast.Expression {
+	exp := this.equality()
+	if this.parseError != nil {
+		return nil
+	}
+	for !this.isAtEnd() && this.match(ast.AND) {
+		operator := this.consume()
+		rhs := this.equality()
+		if this.parseError != nil {
+			return nil
+		}
+		exp = &ast.LogicalExpression{Lhs: exp, Operator: operator, Rhs: rhs}
+	}
+	return exp
+}
+
+func (this *Parser) equality() ast.Expression {
+	exp := this.comparison()
+	if this.parseError != nil {
+		return nil
+	}
+	for !this.isAtEnd() && (this.match(ast.EQUAL_EQUAL) || this.match(ast.BANG_EQUAL)) {
+		operator := this.consume()
+		rhs := this.comparison()
+		if this.parseError != nil {
 			return nil
 		}
 		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
@@ -95,15 +456,15 @@ func term() ast.Expression {
 	return exp
 }
 
-func factor() ast.Expression {
-	exp := unary()
-	if parseError != nil {
+func (this *Parser) comparison() ast.Expression {
+	exp := this.bitOr()
+	if this.parseError != nil {
 		return nil
 	}
-	for !isAtEnd() && (match(ast.Division) || match(ast.Multiplication)) {
-		operator := consume()
-		rhs := unary()
-		if parseError != nil {
+	for !this.isAtEnd() && (this.match(ast.LESS) || this.match(ast.LESS_EQUAL) || this.match(ast.GREATER) || this.match(ast.GREATER_EQUAL)) {
+		operator := this.consume()
+		rhs := this.bitOr()
+		if this.parseError != nil {
 			return nil
 		}
 		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
@@ -111,86 +472,336 @@ func factor() ast.Expression {
 	return exp
 }
 
-func unary() ast.Expression {
-	if parseError != nil {
+func (this *Parser) bitOr() ast.Expression {
+	exp := this.bitXor()
+	if this.parseError != nil {
 		return nil
 	}
-	if isAtEnd() {
-		parseError = fmt.Errorf("unexpected end of input: expected NUMBER or expression")
+	for !this.isAtEnd() && (this.match(ast.BIT_OR)) {
+		operator := this.consume()
+		rhs := this.bitXor()
+		if this.parseError != nil {
+			return nil
+		}
+		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
+	}
+	return exp
+}
+
+func (this *Parser) bitXor() ast.Expression {
+	exp := this.bitAnd()
+	if this.parseError != nil {
 		return nil
 	}
-	if match(ast.NUMBER_LITERAL) || match(ast.Open_Parentheses) || match(ast.IDENTIFIER_LITERAL) || match(ast.TRUE) || match(ast.FALSE) {
-		return primary()
+	for !this.isAtEnd() && (this.match(ast.BIT_XOR)) {
+		operator := this.consume()
+		rhs := this.bitAnd()
+		if this.parseError != nil {
+			return nil
+		}
+		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
 	}
-	if match(ast.Minus) {
-		op := consume()
-		operand := unary()
-		if parseError != nil {
+	return exp
+}
+
+func (this *Parser) bitAnd() ast.Expression {
+	exp := this.shift()
+	if this.parseError != nil {
+		return nil
+	}
+	for !this.isAtEnd() && (this.match(ast.BIT_AND)) {
+		operator := this.consume()
+		rhs := this.shift()
+		if this.parseError != nil {
 			return nil
 		}
-		return &ast.UnaryExpression{Operator: op, Operand: operand}
+		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
 	}
-	parseError = fmt.Errorf("unexpected token '%s' at position %d: expected NUMBER, '(' or '-'", Tokens[current].Literal, current)
-	return nil
+	return exp
 }
 
-func primary() ast.Expression {
-	if parseError != nil {
+func (this *Parser) shift() ast.Expression {
+	exp := this.term()
+	if this.parseError != nil {
 		return nil
 	}
-	if isAtEnd() {
-		parseError = fmt.Errorf("unexpected end of input: expected NUMBER or '('")
+	for !this.isAtEnd() && (this.match(ast.LEFT_SHIFT) || this.match(ast.RIGHT_SHIFT)) {
+		operator := this.consume()
+		rhs := this.term()
+		if this.parseError != nil {
+			return nil
+		}
+		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
+	}
+	return exp
+}
+
+func (this *Parser) term() ast.Expression {
+	exp := this.factor()
+	if this.parseError != nil {
 		return nil
 	}
-	if match(ast.Open_Parentheses) {
-		consume()
-		exp := expression()
-		if parseError != nil {
+	for !this.isAtEnd() && (this.match(ast.Minus) || this.match(ast.Plus)) {
+		operator := this.consume()
+		rhs := this.factor()
+		if this.parseError != nil {
 			return nil
 		}
-		if isAtEnd() {
-			parseError = fmt.Errorf("unexpected end of input: expected ')'")
+		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
+	}
+	return exp
+}
+
+func (this *Parser) factor() ast.Expression {
+	exp := this.unary()
+	if this.parseError != nil {
+		return nil
+	}
+	for !this.isAtEnd() && (this.match(ast.Division) || this.match(ast.Multiplication) || this.match(ast.IntegerDivision) || this.match(ast.Modulo)) {
+		operator := this.consume()
+		rhs := this.unary()
+		if this.parseError != nil {
 			return nil
 		}
-		if !match(ast.Close_Parentheses) {
-			parseError = fmt.Errorf("expected ')' at position %d, got '%s'", current, Tokens[current].Literal)
+		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
+	}
+	return exp
+}
+
+func (this *Parser) unary() ast.Expression {
+	if this.parseError != nil {
+		return nil
+	}
+	if this.isAtEnd() {
+		this.parseError = this.unexpectedEnd("expected expression")
+		return nil
+	}
+	if this.match(ast.INTEGER_LITERAL) || this.match(ast.NUMBER_LITERAL) || this.match(ast.Open_Parentheses) || this.match(ast.IDENTIFIER_LITERAL) || this.match(ast.TRUE) || this.match(ast.FALSE) || this.match(ast.FN) ||
+		this.match(ast.STRING_LITERAL) || this.match(ast.TEMPLATE_START) || this.match(ast.IF) {
+		return this.power()
+	}
+	if this.match(ast.Minus) || this.match(ast.BANG) || this.match(ast.BIT_NOT) {
+		op := this.consume()
+		operand := this.unary()
+		if this.parseError != nil {
 			return nil
 		}
-		consume()
+		return &ast.UnaryExpression{Operator: op, Operand: operand}
+	}
+	this.parseError = this.unexpectedToken("expected expression")
+	return nil
+}
+
+// power parses a call raised to a power. The exponent is a unary, so that **
+// is right-associative and binds tighter than a unary operator on its left
+// only: -2 ** 2 is -(2 ** 2), 2 ** -1 is 2 ** (-1).
+func (this *Parser) power() ast.Expression {
+	exp := this.call()
+	if this.parseError != nil {
+		return nil
+	}
+	if !this.isAtEnd() && this.match(ast.Power) {
+		operator := this.consume()
+		rhs := this.unary()
+		if this.parseError != nil {
+			return nil
+		}
+		exp = &ast.BinaryExpression{Lhs: exp, Operator: operator, Rhs: rhs}
+	}
+	return exp
+}
+
+func (this *Parser) call() ast.Expression {
+	start := this.current
+	exp := this.primary()
+	if this.parseError != nil {
+		return nil
+	}
+	for this.match(ast.Open_Parentheses) {
+		this.consume() // (
+		arguments := make([]ast.Expression, 0)
+		for !this.match(ast.Close_Parentheses) {
+			if len(arguments) > 0 && !this.expect(ast.COMMA, "expected ',' or ')'") {
+				return this.recoverGroup(start)
+			}
+			argument := this.expression()
+			if this.parseError != nil {
+				return this.recoverGroup(start)
+			}
+			arguments = append(arguments, argument)
+		}
+		close := this.consume() // )
+		exp = &ast.Call{Callee: exp, Arguments: arguments, Close: close}
+	}
+	return exp
+}
+
+func (this *Parser) primary() ast.Expression {
+	if this.parseError != nil {
+		return nil
+	}
+	if this.isAtEnd() {
+		this.parseError = this.unexpectedEnd("expected expression")
+		return nil
+	}
+	if this.match(ast.Open_Parentheses) {
+		start := this.current
+		this.consume()
+		exp := this.expression()
+		if this.parseError != nil {
+			return this.recoverGroup(start)
+		}
+		if this.isAtEnd() {
+			this.parseError = this.unexpectedEnd("expected ')'")
+			return nil
+		}
+		if !this.match(ast.Close_Parentheses) {
+			this.parseError = this.unexpectedToken("expected ')'")
+			return this.recoverGroup(start)
+		}
+		this.consume()
 		return exp
 	}
-	if match(ast.NUMBER_LITERAL) || match(ast.TRUE) || match(ast.FALSE) {
-		token := consume()
+	if this.match(ast.INTEGER_LITERAL) || this.match(ast.NUMBER_LITERAL) || this.match(ast.TRUE) || this.match(ast.FALSE) || this.match(ast.STRING_LITERAL) {
+		token := this.consume()
 		return &ast.CONSTANT{TokenLiteral: token}
 	}
-	if match(ast.IDENTIFIER_LITERAL) {
-		token := consume()
+	if this.match(ast.TEMPLATE_START) {
+		return this.interpolation()
+	}
+	if this.match(ast.IDENTIFIER_LITERAL) {
+		token := this.consume()
 		return &ast.Identifier{TokenLiteral: token}
 	}
-	parseError = fmt.Errorf("unexpected token '%s' at position %d: expected NUMBER or '('", Tokens[current].Literal, current)
+	if this.match(ast.FN) {
+		return this.lambda()
+	}
+	if this.match(ast.IF) {
+		return this.ifExpression()
+	}
+	this.parseError = this.unexpectedToken("expected expression")
+	return nil
+}
+
+// interpolation parses a string literal holding expressions, from its
+// TEMPLATE_START token to its TEMPLATE_END one.
+func (this *Parser) interpolation() ast.Expression {
+	interpolation := &ast.Interpolation{Texts: []ast.Token{this.consume()}}
+	for {
+		part := this.expression()
+		if this.parseError != nil {
+			return nil
+		}
+		interpolation.Parts = append(interpolation.Parts, part)
+		if this.match(ast.TEMPLATE_END) {
+			interpolation.Texts = append(interpolation.Texts, this.consume())
+			return interpolation
+		}
+		text, ok := this.consumeExpected(ast.TEMPLATE_MIDDLE, "expected '}'")
+		if !ok {
+			return nil
+		}
+		interpolation.Texts = append(interpolation.Texts, text)
+	}
+}
+
+// recoverGroup reports the error inside the parentheses of the expression
+// starting at start and skips to the closing parenthesis. Parsing resumes
+// after it unless the statement ends first.
+func (this *Parser) recoverGroup(start int) ast.Expression {
+	this.report(this.parseError)
+	depth := 1
+	for !this.isAtEnd() && !this.isSeparator() && !this.match(ast.Close_Brace) {
+		if this.match(ast.Open_Parentheses) {
+			depth++
+		} else if this.match(ast.Close_Parentheses) {
+			depth--
+		}
+		this.consume()
+		if depth == 0 {
+			return this.badExpression(start)
+		}
+	}
+	this.parseError = this.diagnostics[len(this.diagnostics)-1]
 	return nil
 }
 
-func isAtEnd() bool {
-	return current >= len(Tokens)
+// unexpectedToken reports the current token, followed by what was expected
+// instead if anything.
+func (this *Parser) unexpectedToken(expected string) *ast.Diagnostic {
+	token := this.tokens[this.current]
+	message := fmt.Sprintf("unexpected token '%s'", token.Literal)
+	if token.Token == ast.NEWLINE {
+		message = "unexpected newline"
+	}
+	if expected != "" {
+		message += ": " + expected
+	}
+	return &ast.Diagnostic{Span: token.Span, Message: message}
+}
+
+// unexpectedEnd reports a missing token right after the last one.
+func (this *Parser) unexpectedEnd(expected string) *ast.Diagnostic {
+	span := ast.Span{}
+	if len(this.tokens) > 0 {
+		end := this.tokens[len(this.tokens)-1].Span.End
+		span = ast.Span{Start: end, End: end}
+	}
+	return &ast.Diagnostic{Span: span, Message: "unexpected end of input: " + expected}
+}
+
+// expect consumes the current token if it has the given type, and reports
+// what was expected otherwise.
+func (this *Parser) expect(tokenType ast.TokenType, expected string) bool {
+	_, ok := this.consumeExpected(tokenType, expected)
+	return ok
+}
+
+func (this *Parser) consumeExpected(tokenType ast.TokenType, expected string) (ast.Token, bool) {
+	if this.parseError != nil {
+		return ast.Token{}, false
+	}
+	if this.isAtEnd() {
+		this.parseError = this.unexpectedEnd(expected)
+		return ast.Token{}, false
+	}
+	if !this.match(tokenType) {
+		this.parseError = this.unexpectedToken(expected)
+		return ast.Token{}, false
+	}
+	return this.consume(), true
 }
 
-func match(tokenType ast.TokenType) bool {
-	if isAtEnd() {
+func (this *Parser) isAtEnd() bool {
+	return this.current >= len(this.tokens)
+}
+
+func (this *Parser) match(tokenType ast.TokenType) bool {
+	if this.isAtEnd() {
 		return false
 	}
-	return Tokens[current].Token == tokenType
+	return this.tokens[this.current].Token == tokenType
+}
+
+func (this *Parser) isSeparator() bool {
+	return this.match(ast.SEMICOLON) || this.match(ast.NEWLINE)
+}
+
+func (this *Parser) skipSeparators() {
+	for this.isSeparator() {
+		this.consume()
+	}
 }
 
-func match_next(tokenType ast.TokenType) bool {
-	if current+1 >= len(Tokens) {
+func (this *Parser) match_next(tokenType ast.TokenType) bool {
+	if this.current+1 >= len(this.tokens) {
 		return false
 	}
-	return Tokens[current+1].Token == tokenType
+	return this.tokens[this.current+1].Token == tokenType
 }
 
-func consume() ast.Token {
-	res := Tokens[current]
-	current++
+func (this *Parser) consume() ast.Token {
+	res := this.tokens[this.current]
+	this.current++
 	return res
 }
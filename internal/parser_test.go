@@ -256,6 +256,29 @@ func TestParseComplexExpression(t *testing.T) {
 	}
 }
 
+func TestParsePrecedenceIntegerAndBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 | 2 ^ 3 & 4 << 5 + 6", "(| 1 (^ 2 (& 3 (<< 4 (+ 5 6)))))"},
+		{"a & b == c", "(== (& a b) c)"},
+		{"a | b < c", "(< (| a b) c)"},
+		{"1 << 2 >> 3", "(>> (<< 1 2) 3)"},
+		{"7 // 2 % 3 * 4", "(* (% (// 7 2) 3) 4)"},
+		{"2 ** 3 ** 2", "(** 2 (** 3 2))"},
+		{"-2 ** 2", "(- (** 2 2))"},
+		{"2 ** -1", "(** 2 (- 1))"},
+		{"2 * f(x) ** 2", "(* 2 (** (call f x) 2))"},
+		{"~a & ~b", "(& (~ a) (~ b))"},
+	}
+	for _, test := range tests {
+		if output := structure(t, test.input); output != test.expected+"\n" {
+			t.Errorf("expected '%s' to parse as %s, got %s", test.input, test.expected, output)
+		}
+	}
+}
+
 func TestParseUnaryWithBinary(t *testing.T) {
 	// 5+-3 (5 + (-3))
 	exp, err := internal.Parse(tokens("5+-3"))
@@ -338,8 +361,18 @@ func TestParseTrailingOperator(t *testing.T) {
 	if err == nil {
 		t.Error("expected error for trailing operator, got nil")
 	}
-	if !strings.Contains(err.Error(), "end of input") {
-		t.Errorf("expected 'end of input' in error message, got: %v", err)
+	if !strings.Contains(err.Error(), "unexpected end of input: expected expression") {
+		t.Errorf("expected 'unexpected end of input: expected expression' in error message, got: %v", err)
+	}
+}
+
+func TestParseMissingOperand(t *testing.T) {
+	_, err := internal.Parse(tokens("1 + *"))
+	if err == nil {
+		t.Fatal("expected error for missing operand, got nil")
+	}
+	if !strings.Contains(err.Error(), "unexpected token '*': expected expression") {
+		t.Errorf("expected 'unexpected token '*': expected expression' in error message, got: %v", err)
 	}
 }
 
@@ -631,6 +664,36 @@ func TestParseAssignmentParenthesizedExpression(t *testing.T) {
 	}
 }
 
+func TestParseAssignmentExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"y = x = 3", "(= y (= x 3))"},
+		{"x += 1 * 2", "(+= x (* 1 2))"},
+		{"x -= y /= 2", "(-= x (/= y 2))"},
+		{"x *= -1", "(*= x (- 1))"},
+		{"1 + (x = 2)", "(+ 1 (= x 2))"},
+		{"f(x = 1, y)", "(call f (= x 1) y)"},
+		{"x = a ? b : c", "(= x (if a b c))"},
+		{"a ? x = 1 : x = 2", "(if a (= x 1) (= x 2))"},
+		{"fn(n) => total += n", "(lambda (n) (+= total n))"},
+	}
+	for _, test := range tests {
+		if output := structure(t, test.input); output != test.expected+"\n" {
+			t.Errorf("expected '%s' to parse as %s, got %s", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestParseAssignmentInvalidTarget(t *testing.T) {
+	for _, input := range []string{"1 + x = 2", "(x) = 1", "f() += 1", "x + = 1", "x += "} {
+		if _, err := internal.Parse(tokens(input)); err == nil {
+			t.Errorf("expected an error for '%s'", input)
+		}
+	}
+}
+
 func TestParseAssignmentMissingEqualSign(t *testing.T) {
 	_, err := internal.Parse(tokens("x 5"))
 	if err == nil {
@@ -662,3 +725,721 @@ func TestParseAssignmentZeroRHS(t *testing.T) {
 		t.Errorf("expected RHS value '0', got '%s'", numLit.TokenLiteral.Literal)
 	}
 }
+
+// Comparison and equality tests
+
+func TestParseComparison(t *testing.T) {
+	exp, err := internal.Parse(tokens("a < b"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bin, ok := exp.(*ast.BinaryExpression)
+	if !ok {
+		t.Fatalf("expected BinaryExpression, got %T", exp)
+	}
+	if bin.Operator.Token != ast.LESS {
+		t.Errorf("expected LESS operator, got %v", bin.Operator.Token)
+	}
+}
+
+func TestParsePrecedenceTermBeforeComparison(t *testing.T) {
+	// 1+2 >= 3 should parse as (1+2) >= 3
+	exp, err := internal.Parse(tokens("1+2 >= 3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bin, ok := exp.(*ast.BinaryExpression)
+	if !ok {
+		t.Fatalf("expected BinaryExpression, got %T", exp)
+	}
+	if bin.Operator.Token != ast.GREATER_EQUAL {
+		t.Fatalf("expected top-level GREATER_EQUAL, got %v", bin.Operator.Token)
+	}
+	lhs, ok := bin.Lhs.(*ast.BinaryExpression)
+	if !ok || lhs.Operator.Token != ast.Plus {
+		t.Errorf("expected LHS to be addition, got %T", bin.Lhs)
+	}
+}
+
+func TestParsePrecedenceComparisonBeforeEquality(t *testing.T) {
+	// a < b == c > d should parse as (a < b) == (c > d)
+	exp, err := internal.Parse(tokens("a < b == c > d"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bin, ok := exp.(*ast.BinaryExpression)
+	if !ok {
+		t.Fatalf("expected BinaryExpression, got %T", exp)
+	}
+	if bin.Operator.Token != ast.EQUAL_EQUAL {
+		t.Fatalf("expected top-level EQUAL_EQUAL, got %v", bin.Operator.Token)
+	}
+	if lhs, ok := bin.Lhs.(*ast.BinaryExpression); !ok || lhs.Operator.Token != ast.LESS {
+		t.Errorf("expected LHS to be LESS comparison, got %T", bin.Lhs)
+	}
+	if rhs, ok := bin.Rhs.(*ast.BinaryExpression); !ok || rhs.Operator.Token != ast.GREATER {
+		t.Errorf("expected RHS to be GREATER comparison, got %T", bin.Rhs)
+	}
+}
+
+func TestParseAssignmentOfComparison(t *testing.T) {
+	exp, err := internal.Parse(tokens("x = a != b"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assign, ok := exp.(*ast.Assignement)
+	if !ok {
+		t.Fatalf("expected Assignement, got %T", exp)
+	}
+	if rhs, ok := assign.Rhs.(*ast.BinaryExpression); !ok || rhs.Operator.Token != ast.BANG_EQUAL {
+		t.Errorf("expected RHS to be BANG_EQUAL comparison, got %T", assign.Rhs)
+	}
+}
+
+func TestParseComparisonMissingOperand(t *testing.T) {
+	_, err := internal.Parse(tokens("1 <"))
+	if err == nil {
+		t.Error("expected error for comparison without RHS, got nil")
+	}
+}
+
+// Logical operator tests
+
+func TestParseLogicalAnd(t *testing.T) {
+	exp, err := internal.Parse(tokens("a && b"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logical, ok := exp.(*ast.LogicalExpression)
+	if !ok {
+		t.Fatalf("expected LogicalExpression, got %T", exp)
+	}
+	if logical.Operator.Token != ast.AND {
+		t.Errorf("expected AND operator, got %v", logical.Operator.Token)
+	}
+}
+
+func TestParsePrecedenceAndBeforeOr(t *testing.T) {
+	// a || b && c should parse as a || (b && c)
+	exp, err := internal.Parse(tokens("a or b and c"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logical, ok := exp.(*ast.LogicalExpression)
+	if !ok {
+		t.Fatalf("expected LogicalExpression, got %T", exp)
+	}
+	if logical.Operator.Token != ast.OR {
+		t.Fatalf("expected top-level OR, got %v", logical.Operator.Token)
+	}
+	rhs, ok := logical.Rhs.(*ast.LogicalExpression)
+	if !ok || rhs.Operator.Token != ast.AND {
+		t.Errorf("expected RHS to be AND expression, got %T", logical.Rhs)
+	}
+}
+
+func TestParsePrecedenceComparisonBeforeAnd(t *testing.T) {
+	exp, err := internal.Parse(tokens("x != 0 && 10 / x > 1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logical, ok := exp.(*ast.LogicalExpression)
+	if !ok {
+		t.Fatalf("expected LogicalExpression, got %T", exp)
+	}
+	if lhs, ok := logical.Lhs.(*ast.BinaryExpression); !ok || lhs.Operator.Token != ast.BANG_EQUAL {
+		t.Errorf("expected LHS to be BANG_EQUAL comparison, got %T", logical.Lhs)
+	}
+	if rhs, ok := logical.Rhs.(*ast.BinaryExpression); !ok || rhs.Operator.Token != ast.GREATER {
+		t.Errorf("expected RHS to be GREATER comparison, got %T", logical.Rhs)
+	}
+}
+
+func TestParseNot(t *testing.T) {
+	exp, err := internal.Parse(tokens("!!true"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	outer, ok := exp.(*ast.UnaryExpression)
+	if !ok || outer.Operator.Token != ast.BANG {
+		t.Fatalf("expected BANG UnaryExpression, got %T", exp)
+	}
+	if _, ok := outer.Operand.(*ast.UnaryExpression); !ok {
+		t.Errorf("expected nested UnaryExpression, got %T", outer.Operand)
+	}
+}
+
+func TestParseLogicalMissingOperand(t *testing.T) {
+	_, err := internal.Parse(tokens("true ||"))
+	if err == nil {
+		t.Error("expected error for logical operator without RHS, got nil")
+	}
+}
+
+// Program tests
+
+func TestParseProgram(t *testing.T) {
+	exp, err := internal.Parse(tokens("var x; x = 3; x * 2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	program, ok := exp.(*ast.Program)
+	if !ok {
+		t.Fatalf("expected Program, got %T", exp)
+	}
+	if len(program.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(program.Statements))
+	}
+	if _, ok := program.Statements[0].(*ast.VarDeclaration); !ok {
+		t.Errorf("expected statement 0 to be VarDeclaration, got %T", program.Statements[0])
+	}
+	if _, ok := program.Statements[1].(*ast.Assignement); !ok {
+		t.Errorf("expected statement 1 to be Assignement, got %T", program.Statements[1])
+	}
+	if _, ok := program.Statements[2].(*ast.BinaryExpression); !ok {
+		t.Errorf("expected statement 2 to be BinaryExpression, got %T", program.Statements[2])
+	}
+}
+
+func TestParseProgramWithNewlines(t *testing.T) {
+	exp, err := internal.Parse(tokens("\nvar x\n\nx = 3;\n x * 2\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	program, ok := exp.(*ast.Program)
+	if !ok {
+		t.Fatalf("expected Program, got %T", exp)
+	}
+	if len(program.Statements) != 3 {
+		t.Errorf("expected 3 statements, got %d", len(program.Statements))
+	}
+}
+
+func TestParseSingleStatementWithSeparator(t *testing.T) {
+	exp, err := internal.Parse(tokens("1 + 2;"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := exp.(*ast.BinaryExpression); !ok {
+		t.Errorf("expected BinaryExpression, got %T", exp)
+	}
+}
+
+func TestParseOnlySeparators(t *testing.T) {
+	_, err := internal.Parse(tokens(";\n;"))
+	if err == nil {
+		t.Fatal("expected error for input without statements, got nil")
+	}
+	if !strings.Contains(err.Error(), "empty input") {
+		t.Errorf("expected 'empty input' error, got: %v", err)
+	}
+}
+
+func TestParseProgramMissingSeparator(t *testing.T) {
+	_, err := internal.Parse(tokens("x = 1 y = 2"))
+	if err == nil {
+		t.Error("expected error for statements without separator, got nil")
+	}
+}
+
+func TestParseProgramErrorInLaterStatement(t *testing.T) {
+	_, err := internal.Parse(tokens("x = 1; y = (2"))
+	if err == nil {
+		t.Error("expected error for invalid second statement, got nil")
+	}
+}
+
+// Diagnostic tests
+
+func TestParseErrorSpan(t *testing.T) {
+	_, err := internal.Parse(tokens("var x\nx = (1 + 2))"))
+	if err == nil {
+		t.Fatal("expected error for unmatched parenthesis, got nil")
+	}
+	diagnostics, ok := err.(ast.Diagnostics)
+	if !ok || len(diagnostics) != 1 {
+		t.Fatalf("expected a single diagnostic, got %T: %v", err, err)
+	}
+	diagnostic := diagnostics[0]
+	if diagnostic.Span.Start.Line != 2 || diagnostic.Span.Start.Column != 12 {
+		t.Errorf("expected error at 2:12, got %s", diagnostic.Span.Start)
+	}
+}
+
+func TestParseUnexpectedEndSpan(t *testing.T) {
+	_, err := internal.Parse(tokens("1 +"))
+	if err == nil {
+		t.Fatal("expected error for trailing operator, got nil")
+	}
+	diagnostics, ok := err.(ast.Diagnostics)
+	if !ok || len(diagnostics) != 1 {
+		t.Fatalf("expected a single diagnostic, got %T: %v", err, err)
+	}
+	diagnostic := diagnostics[0]
+	if diagnostic.Span.Start.Column != 4 {
+		t.Errorf("expected error right after the last token, got %s", diagnostic.Span.Start)
+	}
+}
+
+func TestParseExpressionSpan(t *testing.T) {
+	exp, err := internal.Parse(tokens("  -a * (b + c)"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	span := exp.Span()
+	if span.Start.Offset != 2 || span.End.Offset != 13 {
+		t.Errorf("expected span from 2 to 13, got %d to %d", span.Start.Offset, span.End.Offset)
+	}
+}
+
+func TestParseVarDeclarationSpan(t *testing.T) {
+	exp, err := internal.Parse(tokens("var x"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	span := exp.Span()
+	if span.Start.Offset != 0 || span.End.Offset != 5 {
+		t.Errorf("expected span from 0 to 5, got %d to %d", span.Start.Offset, span.End.Offset)
+	}
+}
+
+// Error recovery tests
+
+func TestParseReportsEveryStatementError(t *testing.T) {
+	exp, err := internal.Parse(tokens("x = 1 +\ny = 2\nz = * 3"))
+	diagnostics, ok := err.(ast.Diagnostics)
+	if !ok {
+		t.Fatalf("expected ast.Diagnostics, got %T: %v", err, err)
+	}
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(diagnostics), err)
+	}
+	if diagnostics[0].Span.Start.Line != 1 || diagnostics[1].Span.Start.Line != 3 {
+		t.Errorf("expected errors on lines 1 and 3, got %s and %s", diagnostics[0].Span, diagnostics[1].Span)
+	}
+
+	program, ok := exp.(*ast.Program)
+	if !ok {
+		t.Fatalf("expected partial Program, got %T", exp)
+	}
+	if len(program.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(program.Statements))
+	}
+	if _, ok := program.Statements[0].(*ast.BadExpression); !ok {
+		t.Errorf("expected statement 0 to be BadExpression, got %T", program.Statements[0])
+	}
+	if _, ok := program.Statements[1].(*ast.Assignement); !ok {
+		t.Errorf("expected statement 1 to be Assignement, got %T", program.Statements[1])
+	}
+	if _, ok := program.Statements[2].(*ast.BadExpression); !ok {
+		t.Errorf("expected statement 2 to be BadExpression, got %T", program.Statements[2])
+	}
+}
+
+func TestParseRecoversAtClosingParenthesis(t *testing.T) {
+	// Both groups are broken, the parser resumes after each ')'
+	exp, err := internal.Parse(tokens("(1 + ) * (2 3) - 4"))
+	diagnostics, ok := err.(ast.Diagnostics)
+	if !ok {
+		t.Fatalf("expected ast.Diagnostics, got %T: %v", err, err)
+	}
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(diagnostics), err)
+	}
+	if !strings.Contains(diagnostics[1].Message, "expected ')'") {
+		t.Errorf("expected missing ')' error, got: %v", diagnostics[1])
+	}
+
+	sub, ok := exp.(*ast.BinaryExpression)
+	if !ok || sub.Operator.Token != ast.Minus {
+		t.Fatalf("expected top-level subtraction, got %T", exp)
+	}
+	mul, ok := sub.Lhs.(*ast.BinaryExpression)
+	if !ok {
+		t.Fatalf("expected multiplication, got %T", sub.Lhs)
+	}
+	if _, ok := mul.Lhs.(*ast.BadExpression); !ok {
+		t.Errorf("expected first group to be BadExpression, got %T", mul.Lhs)
+	}
+	bad, ok := mul.Rhs.(*ast.BadExpression)
+	if !ok {
+		t.Fatalf("expected second group to be BadExpression, got %T", mul.Rhs)
+	}
+	if bad.From.Literal != "(" || bad.To.Literal != ")" {
+		t.Errorf("expected BadExpression to cover the group, got '%s' to '%s'", bad.From.Literal, bad.To.Literal)
+	}
+}
+
+func TestParseUnclosedGroupReportedOnce(t *testing.T) {
+	_, err := internal.Parse(tokens("(1 + 2; (3"))
+	diagnostics, ok := err.(ast.Diagnostics)
+	if !ok {
+		t.Fatalf("expected ast.Diagnostics, got %T: %v", err, err)
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("expected 2 errors, got %d: %v", len(diagnostics), err)
+	}
+}
+
+func TestParseTrailingTokensKeepStatement(t *testing.T) {
+	exp, err := internal.Parse(tokens("1 + 2 3"))
+	if err == nil {
+		t.Fatal("expected error for trailing token, got nil")
+	}
+	if _, ok := exp.(*ast.BinaryExpression); !ok {
+		t.Errorf("expected the parsed statement to be kept, got %T", exp)
+	}
+}
+
+// Function tests
+
+func TestParseFunctionDeclaration(t *testing.T) {
+	exp, err := internal.Parse(tokens("fn area(w, h) { w * h }"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decl, ok := exp.(*ast.FunctionDeclaration)
+	if !ok {
+		t.Fatalf("expected FunctionDeclaration, got %T", exp)
+	}
+	if decl.Name.TokenLiteral.Literal != "area" {
+		t.Errorf("expected name 'area', got '%s'", decl.Name.TokenLiteral.Literal)
+	}
+	if len(decl.Parameters) != 2 || decl.Parameters[1].TokenLiteral.Literal != "h" {
+		t.Errorf("expected parameters (w, h), got %v", decl.Parameters)
+	}
+	if len(decl.Body.Statements) != 1 {
+		t.Fatalf("expected 1 statement in body, got %d", len(decl.Body.Statements))
+	}
+	if _, ok := decl.Body.Statements[0].(*ast.BinaryExpression); !ok {
+		t.Errorf("expected body to be BinaryExpression, got %T", decl.Body.Statements[0])
+	}
+}
+
+func TestParseFunctionMultilineBody(t *testing.T) {
+	exp, err := internal.Parse(tokens("fn f(x) {\n  var y\n  y = x * 2\n  y + 1\n}\nf(2)"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	program, ok := exp.(*ast.Program)
+	if !ok || len(program.Statements) != 2 {
+		t.Fatalf("expected Program with 2 statements, got %T", exp)
+	}
+	decl, ok := program.Statements[0].(*ast.FunctionDeclaration)
+	if !ok {
+		t.Fatalf("expected FunctionDeclaration, got %T", program.Statements[0])
+	}
+	if len(decl.Body.Statements) != 3 {
+		t.Errorf("expected 3 statements in body, got %d", len(decl.Body.Statements))
+	}
+}
+
+func TestParseLambda(t *testing.T) {
+	exp, err := internal.Parse(tokens("fn(x) => x * 2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lambda, ok := exp.(*ast.Lambda)
+	if !ok {
+		t.Fatalf("expected Lambda, got %T", exp)
+	}
+	if len(lambda.Parameters) != 1 {
+		t.Errorf("expected 1 parameter, got %d", len(lambda.Parameters))
+	}
+	if _, ok := lambda.Body.(*ast.BinaryExpression); !ok {
+		t.Errorf("expected body to be BinaryExpression, got %T", lambda.Body)
+	}
+}
+
+func TestParseCall(t *testing.T) {
+	exp, err := internal.Parse(tokens("area(2, 3 + 1)"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	call, ok := exp.(*ast.Call)
+	if !ok {
+		t.Fatalf("expected Call, got %T", exp)
+	}
+	if callee, ok := call.Callee.(*ast.Identifier); !ok || callee.TokenLiteral.Literal != "area" {
+		t.Errorf("expected callee 'area', got %v", call.Callee)
+	}
+	if len(call.Arguments) != 2 {
+		t.Errorf("expected 2 arguments, got %d", len(call.Arguments))
+	}
+}
+
+func TestParseChainedCalls(t *testing.T) {
+	exp, err := internal.Parse(tokens("adder(1)(2) * 3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bin, ok := exp.(*ast.BinaryExpression)
+	if !ok {
+		t.Fatalf("expected BinaryExpression, got %T", exp)
+	}
+	outer, ok := bin.Lhs.(*ast.Call)
+	if !ok {
+		t.Fatalf("expected Call, got %T", bin.Lhs)
+	}
+	if _, ok := outer.Callee.(*ast.Call); !ok {
+		t.Errorf("expected nested Call, got %T", outer.Callee)
+	}
+}
+
+func TestParseCallWithoutArguments(t *testing.T) {
+	exp, err := internal.Parse(tokens("f()"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	call, ok := exp.(*ast.Call)
+	if !ok || len(call.Arguments) != 0 {
+		t.Errorf("expected Call without arguments, got %T", exp)
+	}
+}
+
+func TestParseFunctionErrors(t *testing.T) {
+	inputs := []string{
+		"fn f(x, ) { x }",
+		"fn f(x y) { x }",
+		"fn f(x) x",
+		"fn f(x) { x",
+		"fn(x) x",
+		"f(1, 2",
+		"f(1 2)",
+	}
+	for _, input := range inputs {
+		if _, err := internal.Parse(tokens(input)); err == nil {
+			t.Errorf("expected error for '%s', got nil", input)
+		}
+	}
+}
+
+func TestParseRecoversInsideBlock(t *testing.T) {
+	exp, err := internal.Parse(tokens("fn f() {\n 1 +\n 2 * \n}\n3"))
+	diagnostics, ok := err.(ast.Diagnostics)
+	if !ok {
+		t.Fatalf("expected ast.Diagnostics, got %T: %v", err, err)
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("expected 2 errors, got %d: %v", len(diagnostics), err)
+	}
+	program, ok := exp.(*ast.Program)
+	if !ok || len(program.Statements) != 2 {
+		t.Fatalf("expected Program with 2 statements, got %T", exp)
+	}
+	decl, ok := program.Statements[0].(*ast.FunctionDeclaration)
+	if !ok {
+		t.Fatalf("expected FunctionDeclaration, got %T", program.Statements[0])
+	}
+	if len(decl.Body.Statements) != 2 {
+		t.Errorf("expected 2 statements in body, got %d", len(decl.Body.Statements))
+	}
+}
+
+func TestParseStrayClosingBrace(t *testing.T) {
+	_, err := internal.Parse(tokens("1 }; 2"))
+	if err == nil {
+		t.Error("expected error for stray '}', got nil")
+	}
+}
+
+func TestParseString(t *testing.T) {
+	exp, err := internal.Parse(tokens(`greeting = 'hi' + name`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	binary, ok := exp.(*ast.Assignement).Rhs.(*ast.BinaryExpression)
+	if !ok {
+		t.Fatalf("expected BinaryExpression, got %T", exp.(*ast.Assignement).Rhs)
+	}
+	constant, ok := binary.Lhs.(*ast.CONSTANT)
+	if !ok || constant.TokenLiteral.Token != ast.STRING_LITERAL {
+		t.Errorf("expected a string constant, got %#v", binary.Lhs)
+	}
+}
+
+func TestParseInterpolation(t *testing.T) {
+	exp, err := internal.Parse(tokens(`"${a} and ${f(b) * 2}!"`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	interpolation, ok := exp.(*ast.Interpolation)
+	if !ok {
+		t.Fatalf("expected Interpolation, got %T", exp)
+	}
+	if len(interpolation.Texts) != 3 || len(interpolation.Parts) != 2 {
+		t.Fatalf("expected 3 texts and 2 parts, got %d and %d", len(interpolation.Texts), len(interpolation.Parts))
+	}
+	texts, err := interpolation.Strings()
+	if err != nil || strings.Join(texts, "|") != "| and |!" {
+		t.Errorf("expected texts '', ' and ', '!', got %q (%v)", texts, err)
+	}
+	if _, ok := interpolation.Parts[1].(*ast.BinaryExpression); !ok {
+		t.Errorf("expected the second part to be a BinaryExpression, got %T", interpolation.Parts[1])
+	}
+	if span := interpolation.Span(); span.Start.Offset != 0 || span.End.Offset != 23 {
+		t.Errorf("expected the interpolation to span the literal, got %d-%d", span.Start.Offset, span.End.Offset)
+	}
+}
+
+func TestParseInterpolationErrors(t *testing.T) {
+	for _, input := range []string{`"${}"`, `"${1 2}"`, `"${1 +}"`} {
+		if _, err := internal.Parse(tokens(input)); err == nil {
+			t.Errorf("expected error for %s, got nil", input)
+		}
+	}
+}
+
+func TestParseIfExpression(t *testing.T) {
+	exp, err := internal.Parse(tokens("total = if qty > 100 then price * 0.9 else price"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conditional, ok := exp.(*ast.Assignement).Rhs.(*ast.ConditionalExpression)
+	if !ok {
+		t.Fatalf("expected ConditionalExpression, got %T", exp.(*ast.Assignement).Rhs)
+	}
+	if conditional.IsTernary() {
+		t.Error("expected an if expression, got a ternary")
+	}
+	if _, ok := conditional.Condition.(*ast.BinaryExpression); !ok {
+		t.Errorf("expected the condition to be a comparison, got %T", conditional.Condition)
+	}
+	if _, ok := conditional.Then.(*ast.BinaryExpression); !ok {
+		t.Errorf("expected the then branch to be a product, got %T", conditional.Then)
+	}
+	if span := conditional.Span(); span.Start.Offset != 8 || span.End.Offset != 48 {
+		t.Errorf("expected the expression to span from 'if' to the end, got %d-%d", span.Start.Offset, span.End.Offset)
+	}
+}
+
+func TestParseTernary(t *testing.T) {
+	exp, err := internal.Parse(tokens("a || b ? 1 : c ? 2 : 3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conditional, ok := exp.(*ast.ConditionalExpression)
+	if !ok || !conditional.IsTernary() {
+		t.Fatalf("expected a ternary, got %#v", exp)
+	}
+	if _, ok := conditional.Condition.(*ast.LogicalExpression); !ok {
+		t.Errorf("expected a || b as the condition, got %T", conditional.Condition)
+	}
+	nested, ok := conditional.Else.(*ast.ConditionalExpression)
+	if !ok {
+		t.Fatalf("expected the ternary to chain to the right, got %T", conditional.Else)
+	}
+	if constant, ok := nested.Else.(*ast.CONSTANT); !ok || constant.TokenLiteral.Literal != "3" {
+		t.Errorf("expected 3 as the last branch, got %#v", nested.Else)
+	}
+	if span := conditional.Span(); span.Start.Offset != 0 || span.End.Offset != 22 {
+		t.Errorf("expected the ternary to span the input, got %d-%d", span.Start.Offset, span.End.Offset)
+	}
+}
+
+func TestParseConditionalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if a b else c", "1:6: unexpected token 'b': expected 'then' or '{'"},
+		{"if a then b", "1:12: unexpected end of input: expected 'else'"},
+		{"if a then b c", "1:13: unexpected token 'c': expected 'else'"},
+		{"a ? b", "1:6: unexpected end of input: expected ':'"},
+		{"a ? b c", "1:7: unexpected token 'c': expected ':'"},
+		{"a ? b : ", "1:8: unexpected end of input: expected expression"},
+	}
+	for _, test := range tests {
+		_, err := internal.Parse(tokens(test.input))
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", test.expected, test.input, err.Error())
+		}
+	}
+}
+
+func TestParseLoops(t *testing.T) {
+	exp, err := internal.Parse(tokens("while i < 10 { i = i + 1 }\nfor j in range(0, n) { if j > 2 { break }; continue }\n{ var k }"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	statements := exp.(*ast.Program).Statements
+	if len(statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(statements))
+	}
+	while, ok := statements[0].(*ast.While)
+	if !ok {
+		t.Fatalf("expected While, got %T", statements[0])
+	}
+	if _, ok := while.Condition.(*ast.BinaryExpression); !ok || len(while.Body.Statements) != 1 {
+		t.Errorf("unexpected while loop %#v", while)
+	}
+	if span := while.Span(); span.Start.Offset != 0 || span.End.Offset != 26 {
+		t.Errorf("expected the while loop to span its line, got %d-%d", span.Start.Offset, span.End.Offset)
+	}
+	loop, ok := statements[1].(*ast.For)
+	if !ok {
+		t.Fatalf("expected For, got %T", statements[1])
+	}
+	if loop.Variable.TokenLiteral.Literal != "j" || loop.End.(*ast.Identifier).TokenLiteral.Literal != "n" {
+		t.Errorf("unexpected for loop %#v", loop)
+	}
+	conditional := loop.Body.Statements[0].(*ast.ConditionalExpression)
+	if _, ok := conditional.Then.(*ast.Block).Statements[0].(*ast.Break); !ok || conditional.Else != nil {
+		t.Errorf("expected an if without else breaking the loop, got %#v", conditional)
+	}
+	if _, ok := loop.Body.Statements[1].(*ast.Continue); !ok {
+		t.Errorf("expected continue, got %T", loop.Body.Statements[1])
+	}
+	if _, ok := statements[2].(*ast.Block); !ok {
+		t.Errorf("expected a block statement, got %T", statements[2])
+	}
+}
+
+func TestParseElseIf(t *testing.T) {
+	exp, err := internal.Parse(tokens("if a { 1 } else if b { 2 } else { 3 }"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conditional := exp.(*ast.ConditionalExpression)
+	nested, ok := conditional.Else.(*ast.ConditionalExpression)
+	if !ok {
+		t.Fatalf("expected else if, got %T", conditional.Else)
+	}
+	if _, ok := nested.Else.(*ast.Block); !ok {
+		t.Errorf("expected a block as the last branch, got %T", nested.Else)
+	}
+	if span := conditional.Span(); span.Start.Offset != 0 || span.End.Offset != 37 {
+		t.Errorf("expected the expression to span the input, got %d-%d", span.Start.Offset, span.End.Offset)
+	}
+}
+
+func TestParseLoopErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break", "1:1: break outside of a loop"},
+		{"if a { continue }", "1:8: continue outside of a loop"},
+		{"while true { fn f() { break } }", "1:23: break outside of a loop"},
+		{"for i in range(0, 3) { f(fn() { continue }) }", "1:33: continue outside of a loop"},
+		{"while true", "1:11: unexpected end of input: expected '{'"},
+		{"for i range(0, 3) {}", "1:7: unexpected token 'range': expected 'in'"},
+		{"for i in span(0, 3) {}", "1:10: unexpected token 'span': expected 'range'"},
+		{"for i in range(0) {}", "1:17: unexpected token ')': expected ','"},
+		{"for 1 in range(0, 3) {}", "1:5: unexpected token '1': expected identifier"},
+	}
+	for _, test := range tests {
+		_, err := internal.Parse(tokens(test.input))
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", test.expected, test.input, err.Error())
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Helper to print input in format
+func printed(t *testing.T, input string, format ast.Format) string {
+	t.Helper()
+	exp, err := internal.Parse(tokens(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	printer := ast.CreatePrinter()
+	printer.Format = format
+	return printer.Sprint(exp)
+}
+
+func TestPrintTree(t *testing.T) {
+	expected := `Expression
+└── Program
+    ├── VarDeclaration: x
+    ├── Assignement: x
+    │   └── Boolean: true
+    ├── FunctionDeclaration: f(a, b)
+    │   └── Block
+    │       └── BinaryExpr (+)
+    │           ├── Identifier: a
+    │           └── UnaryExpr (-)
+    │               └── Identifier: b
+    └── LogicalExpr (||)
+        ├── Identifier: x
+        └── Call
+            ├── Lambda(n)
+            │   └── BinaryExpr (>)
+            │       ├── Identifier: n
+            │       └── Number: 1
+            └── Number: 2.5
+`
+	output := printed(t, "var x; x = true; fn f(a, b) { a + -b }; x || (fn(n) => n > 1)(2.5)", ast.FormatTree)
+	if output != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output)
+	}
+}
+
+func TestPrintSExpr(t *testing.T) {
+	output := printed(t, "var x; x = true; fn f(a, b) { a + -b }; x || (fn(n) => n > 1)(2.5)", ast.FormatSExpr)
+	expected := "(program (var x) (= x true) (fn f (a b) (block (+ a (- b)))) (|| x (call (lambda (n) (> n 1)) 2.5)))\n"
+	if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	output := printed(t, "f(x) && !true", ast.FormatJSON)
+	var node map[string]any
+	if err := json.Unmarshal([]byte(output), &node); err != nil {
+		t.Fatalf("expected valid JSON, got %v:\n%s", err, output)
+	}
+	if node["node"] != "LogicalExpression" || node["operator"] != "&&" || node["span"] != "1:1-1:14" {
+		t.Errorf("unexpected root %v", node)
+	}
+	call := node["lhs"].(map[string]any)
+	if call["node"] != "Call" || call["callee"].(map[string]any)["name"] != "f" || len(call["arguments"].([]any)) != 1 {
+		t.Errorf("unexpected call %v", call)
+	}
+	constant := node["rhs"].(map[string]any)["operand"].(map[string]any)
+	if constant["node"] != "Constant" || constant["type"] != "boolean" || constant["value"] != "true" {
+		t.Errorf("unexpected constant %v", constant)
+	}
+}
+
+func TestPrintStrings(t *testing.T) {
+	input := `s = 'a' + "b ${x + 1} \${c}"`
+	if output := printed(t, input, ast.FormatSExpr); output != `(= s (+ 'a' (interpolate "b " (+ x 1) " \${c}")))`+"\n" {
+		t.Errorf("unexpected S-expression %q", output)
+	}
+	expected := `Expression
+└── Assignement: s
+    └── BinaryExpr (+)
+        ├── String: 'a'
+        └── Interpolation
+            └── BinaryExpr (+)
+                ├── Identifier: x
+                └── Number: 1
+`
+	if output := printed(t, input, ast.FormatTree); output != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output)
+	}
+
+	var node map[string]any
+	if err := json.Unmarshal([]byte(printed(t, input, ast.FormatJSON)), &node); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	rhs := node["rhs"].(map[string]any)
+	if constant := rhs["lhs"].(map[string]any); constant["type"] != "string" || constant["value"] != "'a'" {
+		t.Errorf("unexpected string constant %v", constant)
+	}
+	interpolation := rhs["rhs"].(map[string]any)
+	texts := interpolation["texts"].([]any)
+	if interpolation["node"] != "Interpolation" || len(texts) != 2 || texts[1] != " ${c}" || len(interpolation["parts"].([]any)) != 1 {
+		t.Errorf("unexpected interpolation %v", interpolation)
+	}
+}
+
+func TestPrintCompoundAssignment(t *testing.T) {
+	if output := printed(t, "x += y = 1", ast.FormatSExpr); output != "(+= x (= y 1))\n" {
+		t.Errorf("unexpected s-expression %q", output)
+	}
+	if output := printed(t, "x *= 2", ast.FormatTree); !strings.Contains(output, "Assignement (*=): x\n") {
+		t.Errorf("expected the operator in the tree, got:\n%s", output)
+	}
+	var node map[string]any
+	if err := json.Unmarshal([]byte(printed(t, "x -= 1", ast.FormatJSON)), &node); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	if node["node"] != "Assignement" || node["operator"] != "-=" || node["name"] != "x" {
+		t.Errorf("unexpected assignment %v", node)
+	}
+}
+
+func TestPrintConditional(t *testing.T) {
+	input := "if a then 1 else b ? 2 : 3"
+	if output := printed(t, input, ast.FormatSExpr); output != "(if a 1 (if b 2 3))\n" {
+		t.Errorf("unexpected S-expression %q", output)
+	}
+	var node map[string]any
+	if err := json.Unmarshal([]byte(printed(t, input, ast.FormatJSON)), &node); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	if node["node"] != "ConditionalExpression" || node["condition"].(map[string]any)["name"] != "a" ||
+		node["else"].(map[string]any)["node"] != "ConditionalExpression" {
+		t.Errorf("unexpected conditional %v", node)
+	}
+}
+
+func TestPrintLoops(t *testing.T) {
+	input := "while a { break }; for i in range(0, n) { if i > 1 { continue } }"
+	if output := printed(t, input, ast.FormatSExpr); output != "(program (while a (block (break))) (for i 0 n (block (if (> i 1) (block (continue))))))\n" {
+		t.Errorf("unexpected S-expression %q", output)
+	}
+	tree := printed(t, input, ast.FormatTree)
+	for _, line := range []string{"While", "For: i", "Break", "Continue"} {
+		if !strings.Contains(tree, line) {
+			t.Errorf("expected %q in:\n%s", line, tree)
+		}
+	}
+	var node map[string]any
+	if err := json.Unmarshal([]byte(printed(t, "for i in range(0, n) {}", ast.FormatJSON)), &node); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	if node["node"] != "For" || node["name"] != "i" || node["end"].(map[string]any)["name"] != "n" || node["body"] == nil {
+		t.Errorf("unexpected loop %v", node)
+	}
+}
+
+func TestPrintDOT(t *testing.T) {
+	output := printed(t, "a * (b - 1)", ast.FormatDOT)
+	for _, line := range []string{
+		"digraph AST {",
+		`n0 [label="BinaryExpr (*)"];`,
+		`n1 [label="Identifier: a"];`,
+		`n0 -> n1 [label="lhs"];`,
+		`n2 [label="BinaryExpr (-)"];`,
+		`n0 -> n2 [label="rhs"];`,
+		`n4 [label="Number: 1"];`,
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected %q in:\n%s", line, output)
+		}
+	}
+	if !strings.HasSuffix(output, "}\n") {
+		t.Errorf("expected the digraph to be closed:\n%s", output)
+	}
+}
+
+func TestPrintBadExpression(t *testing.T) {
+	exp, _ := internal.Parse(tokens("1 +; 2"))
+	output := ast.CreatePrinter().Sprint(exp)
+	if !strings.Contains(output, "BadExpression") || !strings.Contains(output, "Number: 2") {
+		t.Errorf("expected the partial AST, got:\n%s", output)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, name := range []string{"tree", "json", "sexpr", "dot"} {
+		if format, err := ast.ParseFormat(name); err != nil || string(format) != name {
+			t.Errorf("expected format %s, got %v, %v", name, format, err)
+		}
+	}
+	if _, err := ast.ParseFormat("xml"); err == nil || !strings.Contains(err.Error(), "tree, json, sexpr, dot") {
+		t.Errorf("expected an error listing the formats, got %v", err)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -11,9 +14,57 @@ import (
 )
 var evaluator = ast.Evaluator{}
 
+var (
+	disassemble = flag.Bool("disassemble", false, "print the bytecode of every expression before evaluating it")
+	optimize    = flag.Bool("optimize", false, "optimize every expression before evaluating it")
+	dumpAST     = flag.String("dump-ast", "", "print the AST of every expression as tree, json, sexpr or dot, before and after optimization with -optimize")
+	decimal     = flag.Bool("decimal", false, "compute with exact decimals instead of binary floats")
+	scale       = flag.Int("scale", 2, "digits after the decimal point kept by division and inexact functions with -decimal")
+	rounding    = flag.String("rounding", "half-even", "how division and inexact functions round with -decimal: half-even, half-up or down")
+)
+
+var printer = ast.CreatePrinter()
+
 func main() {
-	if len(os.Args) >= 2 {
-		evaluateExpression(os.Args[1], true)
+	flags, args := splitArguments(flag.CommandLine, os.Args[1:])
+	flag.CommandLine.Parse(flags)
+	if *dumpAST != "" {
+		format, err := ast.ParseFormat(*dumpAST)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		printer.Format = format
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if (f.Name == "scale" || f.Name == "rounding") && !*decimal {
+			fmt.Fprintf(os.Stderr, "-%s requires -decimal\n", f.Name)
+			os.Exit(2)
+		}
+	})
+	if *decimal {
+		rounding, err := ast.ParseRounding(*rounding)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if *scale < 0 {
+			fmt.Fprintln(os.Stderr, "-scale must be at least 0")
+			os.Exit(2)
+		}
+		if *optimize {
+			// The optimizer folds constants as floats
+			fmt.Fprintln(os.Stderr, "-optimize cannot be used with -decimal")
+			os.Exit(2)
+		}
+		evaluator.Decimal = &ast.DecimalMode{Scale: *scale, Rounding: rounding}
+	}
+	if len(args) >= 1 && args[0] == "fmt" {
+		formatCommand(args[1:])
+		return
+	}
+	if len(args) >= 1 {
+		evaluateExpression(args[0], true)
 		return
 	}
 
@@ -40,33 +91,147 @@ func main() {
 	}
 }
 
+// splitArguments splits args into the flags of flags and the arguments that
+// follow them. Unlike flag.Parse, it stops at the first argument that is not
+// a known flag, so that an expression starting with a minus such as "-7 % 3"
+// is not taken for one. A "--" also ends the flags, and is dropped.
+func splitArguments(flags *flag.FlagSet, args []string) ([]string, []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+		name, hasValue := strings.CutPrefix(arg, "-")
+		if !hasValue || name == "" {
+			return args[:i], args[i:]
+		}
+		name = strings.TrimPrefix(name, "-")
+		name, _, hasValue = strings.Cut(name, "=")
+		if name == "h" || name == "help" {
+			continue
+		}
+		known := flags.Lookup(name)
+		if known == nil {
+			return args[:i], args[i:]
+		}
+		if boolean, ok := known.Value.(interface{ IsBoolFlag() bool }); hasValue || ok && boolean.IsBoolFlag() {
+			continue
+		}
+		// Other flags take the next argument as their value, unless it ends
+		// the flags
+		if i+1 < len(args) && args[i+1] != "--" {
+			i++
+		}
+	}
+	return args, nil
+}
+
 func evaluateExpression(expression string, exitOnError bool) {
 	tokens, err := internal.Tokenize(expression)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Lexer error: %v\n", err)
-		if exitOnError {
-			os.Exit(1)
-		}
+		reportError("Lexer error", expression, err, exitOnError)
 		return
 	}
 
 	exprAst, err := internal.Parse(tokens)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Parser error: %v\n", err)
-		if exitOnError {
-			os.Exit(1)
-		}
+		reportError("Parser error", expression, err, exitOnError)
 		return
 	}
 
-	
+	if *dumpAST != "" && *optimize {
+		fmt.Println("Before optimization:")
+	}
+	if *dumpAST != "" {
+		printer.PrintAST(exprAst)
+	}
+	if *optimize {
+		exprAst = ast.Optimize(exprAst)
+		if *dumpAST != "" {
+			fmt.Println("After optimization:")
+			printer.PrintAST(exprAst)
+		}
+	}
+
+	if *disassemble {
+		bytecode, err := ast.CompileBytecode(exprAst)
+		if err != nil {
+			reportError("Compiler error", expression, err, exitOnError)
+			return
+		}
+		fmt.Print(bytecode.Disassemble())
+	}
+
 	res, err := evaluator.Evaluate(exprAst)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error evaluating the expression: %v\n", err)
-		if exitOnError {
+		reportError("Error evaluating the expression", expression, err, exitOnError)
+		return
+	}
+	fmt.Println(res)
+}
+
+// formatCommand implements "eval fmt [-w] [file ...]", printing the files, or
+// the standard input, in canonical form.
+func formatCommand(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the files instead of printing it")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
 			os.Exit(1)
 		}
+		formatted, err := internal.Format(string(source))
+		if err != nil {
+			reportError("Syntax error", string(source), err, true)
+		}
+		fmt.Print(formatted)
 		return
 	}
-	fmt.Println(res)
+
+	failed := false
+	for _, file := range flags.Args() {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		formatted, err := internal.Format(string(source))
+		if err != nil {
+			reportError(file, string(source), err, false)
+			failed = true
+			continue
+		}
+		if !*write {
+			fmt.Print(formatted)
+		} else if formatted != string(source) {
+			if err := os.WriteFile(file, []byte(formatted), 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// reportError prints err, pointing into the source when it carries a position.
+func reportError(kind string, source string, err error, exitOnError bool) {
+	var diagnostics ast.Diagnostics
+	var diagnostic *ast.Diagnostic
+	if errors.As(err, &diagnostic) {
+		diagnostics = ast.Diagnostics{diagnostic}
+	} else if !errors.As(err, &diagnostics) {
+		diagnostics = ast.Diagnostics{{Message: err.Error()}}
+	}
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintf(os.Stderr, "%s: %s", kind, diagnostic.Render(source))
+	}
+	if exitOnError {
+		os.Exit(1)
+	}
 }
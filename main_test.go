@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"testing"
+)
+
+func TestSplitArguments(t *testing.T) {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	flags.Bool("disassemble", false, "")
+	flags.String("format", "", "")
+
+	tests := []struct {
+		args  []string
+		flags []string
+		rest  []string
+	}{
+		{[]string{"-7 % 3"}, []string{}, []string{"-7 % 3"}},
+		{[]string{"--5"}, []string{}, []string{"--5"}},
+		{[]string{"-disassemble", "-x * 2"}, []string{"-disassemble"}, []string{"-x * 2"}},
+		{[]string{"--disassemble=true", "1 + 2"}, []string{"--disassemble=true"}, []string{"1 + 2"}},
+		{[]string{"-format", "json", "-1"}, []string{"-format", "json"}, []string{"-1"}},
+		{[]string{"-format=json", "-disassemble", "--", "-disassemble"}, []string{"-format=json", "-disassemble"}, []string{"-disassemble"}},
+		{[]string{"-format", "--", "1"}, []string{"-format"}, []string{"1"}},
+		{[]string{"-disassemble"}, []string{"-disassemble"}, nil},
+	}
+	for _, test := range tests {
+		flagArgs, rest := splitArguments(flags, test.args)
+		if !slices.Equal(flagArgs, test.flags) || !slices.Equal(rest, test.rest) {
+			t.Errorf("expected %q and %q for %q, got %q and %q", test.flags, test.rest, test.args, flagArgs, rest)
+		}
+	}
+}
+
+func TestSplitArgumentsLeadingMinus(t *testing.T) {
+	flagArgs, rest := splitArguments(flag.CommandLine, []string{"-disassemble", "-7 % 3"})
+	if err := flag.CommandLine.Parse(flagArgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { *disassemble = false }()
+	if !*disassemble || len(rest) != 1 || rest[0] != "-7 % 3" {
+		t.Errorf("expected -disassemble and the expression '-7 %% 3', got %v and %q", *disassemble, rest)
+	}
+}
@@ -0,0 +1,69 @@
+package eval
+
+import "github.com/jayjunior/eval/internal/ast"
+
+// The AST, as produced by Compile.
+type (
+	Expression            = ast.Expression
+	Visitor               = ast.Visitor
+	ProgramNode           = ast.Program
+	Block                 = ast.Block
+	VarDeclaration        = ast.VarDeclaration
+	Assignement           = ast.Assignement
+	FunctionDeclaration   = ast.FunctionDeclaration
+	Lambda                = ast.Lambda
+	Call                  = ast.Call
+	BinaryExpression      = ast.BinaryExpression
+	LogicalExpression     = ast.LogicalExpression
+	UnaryExpression       = ast.UnaryExpression
+	Constant              = ast.CONSTANT
+	Interpolation         = ast.Interpolation
+	ConditionalExpression = ast.ConditionalExpression
+	While                 = ast.While
+	For                   = ast.For
+	Break                 = ast.Break
+	Continue              = ast.Continue
+	Identifier            = ast.Identifier
+	BadExpression         = ast.BadExpression
+
+	Token     = ast.Token
+	TokenType = ast.TokenType
+)
+
+// Source locations and errors.
+type (
+	Position    = ast.Position
+	Span        = ast.Span
+	Diagnostic  = ast.Diagnostic
+	Diagnostics = ast.Diagnostics
+)
+
+// Runtime values.
+type (
+	Value     = ast.Value
+	ValueType = ast.ValueType
+	Number    = ast.Number
+	Integer   = ast.Integer
+	Rational  = ast.Rational
+	Boolean   = ast.Boolean
+	String    = ast.String
+	Nil       = ast.Nil
+	Function  = ast.Function
+	Builtin   = ast.Builtin
+)
+
+// Walk calls fn for exp and then, depth first, for every node below it as long
+// as fn returns true.
+func Walk(exp Expression, fn func(Expression) bool) {
+	ast.Walk(exp, fn)
+}
+
+// Builtins lists the built-in functions sorted by name.
+func Builtins() []*Builtin {
+	return ast.Builtins()
+}
+
+// Constants returns the built-in constants.
+func Constants() map[string]Value {
+	return ast.Constants()
+}
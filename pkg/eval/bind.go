@@ -0,0 +1,215 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+var (
+	contextType = reflect.TypeFor[context.Context]()
+	errorType   = reflect.TypeFor[error]()
+	valueType   = reflect.TypeFor[ast.Value]()
+)
+
+// bind wraps a Go function in a builtin, converting arguments and results
+// with reflection.
+func bind(name string, fn any) (*ast.Builtin, error) {
+	function := reflect.ValueOf(fn)
+	if function.Kind() != reflect.Func || function.IsNil() {
+		return nil, fmt.Errorf("cannot register %s: expected a function, got %T", name, fn)
+	}
+	signature := function.Type()
+
+	first := 0
+	takesContext := signature.NumIn() > 0 && signature.In(0) == contextType
+	if takesContext {
+		first = 1
+	}
+	parameters := make([]reflect.Type, 0, signature.NumIn())
+	for i := first; i < signature.NumIn(); i++ {
+		parameter := signature.In(i)
+		if signature.IsVariadic() && i == signature.NumIn()-1 {
+			parameter = parameter.Elem()
+		}
+		if !convertible(parameter) {
+			return nil, fmt.Errorf("cannot register %s: unsupported parameter type %s", name, parameter)
+		}
+		parameters = append(parameters, parameter)
+	}
+
+	returnsError := signature.NumOut() > 0 && signature.Out(signature.NumOut()-1) == errorType
+	results := signature.NumOut()
+	if returnsError {
+		results--
+	}
+	if results > 1 || (results == 1 && !convertible(signature.Out(0))) {
+		return nil, fmt.Errorf("cannot register %s: results must be a value, an error, or a value and an error, got %s", name, signature)
+	}
+
+	builtin := &ast.Builtin{Name: name, Doc: name + ": " + signature.String(), MinArity: len(parameters), MaxArity: len(parameters)}
+	if signature.IsVariadic() {
+		builtin.MinArity--
+		builtin.MaxArity = -1
+	}
+	builtin.Fn = func(ctx context.Context, args []ast.Value) (res ast.Value, err error) {
+		in := make([]reflect.Value, 0, len(args)+1)
+		if takesContext {
+			in = append(in, reflect.ValueOf(&ctx).Elem())
+		}
+		for i, arg := range args {
+			parameter := parameters[min(i, len(parameters)-1)]
+			converted, err := toGo(arg, parameter)
+			if err != nil {
+				return nil, fmt.Errorf("%s expects %s as argument %d: %v", name, parameter, i+1, err)
+			}
+			in = append(in, converted)
+		}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				res, err = nil, fmt.Errorf("panic in %s: %v", name, recovered)
+			}
+		}()
+		out := function.Call(in)
+
+		if returnsError && !out[len(out)-1].IsNil() {
+			return nil, out[len(out)-1].Interface().(error)
+		}
+		if results == 0 {
+			return ast.Nil{}, nil
+		}
+		return toValue(out[0].Interface())
+	}
+	return builtin, nil
+}
+
+func convertible(goType reflect.Type) bool {
+	switch goType.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	case reflect.Interface:
+		return goType.NumMethod() == 0 || goType == valueType
+	}
+	return false
+}
+
+// toValue converts a Go value to a value of the language.
+func toValue(value any) (ast.Value, error) {
+	if value == nil {
+		return ast.Nil{}, nil
+	}
+	if converted, ok := value.(ast.Value); ok {
+		return converted, nil
+	}
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Bool:
+		return ast.Boolean(reflected.Bool()), nil
+	case reflect.String:
+		return ast.String(reflected.String()), nil
+	case reflect.Float32, reflect.Float64:
+		return ast.Number(reflected.Float()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ast.NewInteger(reflected.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return ast.NewIntegerFromBig(new(big.Int).SetUint64(reflected.Uint())), nil
+	}
+	return nil, fmt.Errorf("unsupported Go type %T", value)
+}
+
+// toGo converts a value of the language to the Go type goType.
+func toGo(value ast.Value, goType reflect.Type) (reflect.Value, error) {
+	if goType == valueType {
+		return reflect.ValueOf(&value).Elem(), nil
+	}
+	if goType.Kind() == reflect.Interface {
+		res := reflect.New(goType).Elem()
+		if converted := fromValue(value); converted != nil {
+			res.Set(reflect.ValueOf(converted))
+		}
+		return res, nil
+	}
+
+	res := reflect.New(goType).Elem()
+	switch goType.Kind() {
+	case reflect.Bool:
+		boolean, ok := value.(ast.Boolean)
+		if !ok {
+			return res, fmt.Errorf("got %s", value.Type())
+		}
+		res.SetBool(bool(boolean))
+	case reflect.String:
+		str, ok := value.(ast.String)
+		if !ok {
+			return res, fmt.Errorf("got %s", value.Type())
+		}
+		res.SetString(string(str))
+	case reflect.Float32, reflect.Float64:
+		number, ok := ast.ToFloat(value)
+		if !ok {
+			return res, fmt.Errorf("got %s", value.Type())
+		}
+		res.SetFloat(float64(number))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer, err := toInteger(value)
+		if err != nil {
+			return res, err
+		}
+		if !integer.IsInt64() || res.OverflowInt(integer.Int64()) {
+			return res, fmt.Errorf("got %v", value)
+		}
+		res.SetInt(integer.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		integer, err := toInteger(value)
+		if err != nil {
+			return res, err
+		}
+		if !integer.IsUint64() || res.OverflowUint(integer.Uint64()) {
+			return res, fmt.Errorf("got %v", value)
+		}
+		res.SetUint(integer.Uint64())
+	default:
+		return res, fmt.Errorf("unsupported Go type %s", goType)
+	}
+	return res, nil
+}
+
+// toInteger returns value as a big.Int when it is a whole number.
+func toInteger(value ast.Value) (*big.Int, error) {
+	if integer, ok := value.(ast.Integer); ok {
+		return integer.Int(), nil
+	}
+	number, ok := ast.ToFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("got %s", value.Type())
+	}
+	if float64(number) != math.Trunc(float64(number)) || math.IsInf(float64(number), 0) {
+		return nil, fmt.Errorf("got %v", value)
+	}
+	integer, _ := big.NewFloat(float64(number)).Int(nil)
+	return integer, nil
+}
+
+// fromValue converts a value of the language to its natural Go counterpart,
+// float64 for numbers of every kind.
+func fromValue(value ast.Value) any {
+	if number, ok := ast.ToFloat(value); ok {
+		return float64(number)
+	}
+	switch v := value.(type) {
+	case ast.Boolean:
+		return bool(v)
+	case ast.String:
+		return string(v)
+	case ast.Nil:
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,28 @@
+// Package eval embeds the expression language in Go programs.
+//
+// Source is compiled once with Compile and the resulting Program can be run
+// any number of times, concurrently, against separate Envs:
+//
+//	program, err := eval.Compile("price * (1 - discount(tier))")
+//	if err != nil {
+//		return err
+//	}
+//	env := eval.NewEnv()
+//	env.Set("price", 120)
+//	env.Set("tier", "gold")
+//	env.RegisterFunc("discount", discount)
+//	total, err := program.Run(env)
+//
+// Programs evaluated many times against different inputs should be prepared.
+// A Prepared program resolves its identifiers once and is evaluated against
+// a map, a struct or reusable Bindings, without parsing nor an Env:
+//
+//	prepared := program.Prepare()
+//	total, err := prepared.Eval(map[string]any{"price": 120, "tier": "gold", "discount": discount})
+//
+// Numbers are binary floats. The exact decimal arithmetic of the eval command,
+// enabled with its -decimal flag, is not available through this package.
+//
+// The AST of a Program is available through Program.AST. Tools can walk it
+// with Walk or implement Visitor and call Expression.Accept.
+package eval
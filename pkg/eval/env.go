@@ -0,0 +1,60 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Env holds the variables and Go functions visible to expressions, along with
+// anything the expressions declare. An Env is not safe for concurrent use.
+type Env struct {
+	evaluator ast.Evaluator
+}
+
+func NewEnv() *Env {
+	return &Env{}
+}
+
+// Set binds name to a Go value, converted as described in RegisterFunc.
+func (this *Env) Set(name string, value any) error {
+	converted, err := toValue(value)
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %v", name, err)
+	}
+	this.evaluator.Define(name, converted)
+	return nil
+}
+
+// RegisterFunc makes the Go function fn callable from expressions as name.
+//
+// Parameters and results of type bool, string, any integer or floating point
+// type, or any are converted to and from the values of the language. Integer
+// parameters only accept whole numbers. fn may be variadic, may take a
+// context.Context as its first parameter to receive the context of the run
+// (see WithContext), and may return a value, an error, or a value followed by an
+// error. A non-nil error, or a panic, fails the evaluation.
+func (this *Env) RegisterFunc(name string, fn any) error {
+	builtin, err := bind(name, fn)
+	if err != nil {
+		return err
+	}
+	this.evaluator.Define(name, builtin)
+	return nil
+}
+
+// Eval compiles and runs src in the Env. Declarations and assignments stay in
+// the Env for the following calls.
+func (this *Env) Eval(src string, opts ...Option) (any, error) {
+	program, err := Compile(src, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return program.Run(this)
+}
+
+// EvalContext is Eval with WithContext(ctx).
+func (this *Env) EvalContext(ctx context.Context, src string, opts ...Option) (any, error) {
+	return this.Eval(src, append(opts, WithContext(ctx))...)
+}
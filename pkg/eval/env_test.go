@@ -0,0 +1,216 @@
+package eval_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jayjunior/eval/pkg/eval"
+)
+
+func TestRegisterFunc(t *testing.T) {
+	env := eval.NewEnv()
+	err := env.RegisterFunc("discount", func(price float64, tier string) (float64, error) {
+		switch tier {
+		case "gold":
+			return price * 0.8, nil
+		case "silver":
+			return price * 0.9, nil
+		}
+		return 0, fmt.Errorf("unknown tier %q", tier)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := env.Set("tier", "gold"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	value, err := env.Eval("discount(100, tier) + 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 81.0 {
+		t.Errorf("expected 81, got %v", value)
+	}
+
+	env.Set("tier", "bronze")
+	_, err = env.Eval("discount(100, tier)")
+	if err == nil || !strings.Contains(err.Error(), `unknown tier "bronze"`) {
+		t.Errorf("expected error from the Go function, got %v", err)
+	}
+}
+
+func TestRegisterFuncConversions(t *testing.T) {
+	env := eval.NewEnv()
+	env.RegisterFunc("is_even", func(n int) bool { return n%2 == 0 })
+	env.RegisterFunc("half", func(n uint8) float32 { return float32(n) / 2 })
+	env.RegisterFunc("greeting", func() string { return "hello" })
+	env.RegisterFunc("describe", func(value any) string { return fmt.Sprintf("%T", value) })
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"is_even(4)", true},
+		{"is_even(7) || false", false},
+		{"half(5)", 2.5},
+		{"greeting()", "hello"},
+		{"describe(1)", "float64"},
+		{"describe(true)", "bool"},
+		{"describe(greeting())", "string"},
+	}
+	for _, tc := range tests {
+		value, err := env.Eval(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", tc.input, err)
+			continue
+		}
+		if value != tc.expected {
+			t.Errorf("for '%s': expected %v, got %v", tc.input, tc.expected, value)
+		}
+	}
+}
+
+func TestRegisterFuncArgumentErrors(t *testing.T) {
+	env := eval.NewEnv()
+	env.RegisterFunc("is_even", func(n int) bool { return n%2 == 0 })
+	env.RegisterFunc("byte", func(n uint8) uint8 { return n })
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"is_even(1.5)", "is_even expects int as argument 1: got 1.5"},
+		{"is_even(true)", "is_even expects int as argument 1: got bool"},
+		{"is_even()", "is_even expects 1 argument, got 0"},
+		{"byte(300)", "byte expects uint8 as argument 1: got 300"},
+		{"byte(-1)", "byte expects uint8 as argument 1: got -1"},
+	}
+	for _, tc := range tests {
+		_, err := env.Eval(tc.input)
+		if err == nil {
+			t.Errorf("expected error for '%s', got nil", tc.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("for '%s': expected error containing '%s', got '%v'", tc.input, tc.expected, err)
+		}
+	}
+}
+
+func TestRegisterVariadicFunc(t *testing.T) {
+	env := eval.NewEnv()
+	env.RegisterFunc("sum", func(first float64, rest ...float64) float64 {
+		for _, number := range rest {
+			first += number
+		}
+		return first
+	})
+
+	value, err := env.Eval("sum(1, 2, 3, 4)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 10.0 {
+		t.Errorf("expected 10, got %v", value)
+	}
+	if value, err := env.Eval("sum(5)"); err != nil || value != 5.0 {
+		t.Errorf("expected 5, got %v (%v)", value, err)
+	}
+	if _, err := env.Eval("sum()"); err == nil || !strings.Contains(err.Error(), "at least 1 argument") {
+		t.Errorf("expected arity error, got %v", err)
+	}
+}
+
+type contextKey struct{}
+
+func TestRegisterContextFunc(t *testing.T) {
+	env := eval.NewEnv()
+	env.RegisterFunc("rate", func(ctx context.Context, currency string) (float64, error) {
+		rates, _ := ctx.Value(contextKey{}).(map[string]float64)
+		rate, ok := rates[currency]
+		if !ok {
+			return 0, errors.New("no rate for " + currency)
+		}
+		return rate, nil
+	})
+	env.Set("currency", "EUR")
+
+	ctx := context.WithValue(context.Background(), contextKey{}, map[string]float64{"EUR": 2})
+	value, err := env.EvalContext(ctx, "10 * rate(currency)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 20.0 {
+		t.Errorf("expected 20, got %v", value)
+	}
+
+	if _, err := env.Eval("rate(currency)"); err == nil {
+		t.Error("expected error without rates in the context, got nil")
+	}
+}
+
+func TestEvalCancelledContext(t *testing.T) {
+	env := eval.NewEnv()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := env.EvalContext(ctx, "fn f() { 1 }; f()")
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("expected cancellation error, got %v", err)
+	}
+}
+
+func TestRegisterFuncPanics(t *testing.T) {
+	env := eval.NewEnv()
+	env.RegisterFunc("boom", func() float64 { panic("kaboom") })
+	_, err := env.Eval("boom()")
+	if err == nil || !strings.Contains(err.Error(), "panic in boom: kaboom") {
+		t.Errorf("expected panic to be reported, got %v", err)
+	}
+}
+
+func TestRegisterFuncInvalid(t *testing.T) {
+	env := eval.NewEnv()
+	invalid := map[string]any{
+		"not a function": 42,
+		"nil function":   (func())(nil),
+		"map parameter":  func(map[string]int) {},
+		"two results":    func() (int, int) { return 1, 2 },
+		"slice result":   func() []int { return nil },
+	}
+	for name, fn := range invalid {
+		if err := env.RegisterFunc("f", fn); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestRegisterFuncWithoutResult(t *testing.T) {
+	env := eval.NewEnv()
+	calls := 0
+	env.RegisterFunc("track", func(n float64) { calls++ })
+	value, err := env.Eval("track(1); track(2)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != nil || calls != 2 {
+		t.Errorf("expected nil after 2 calls, got %v after %d", value, calls)
+	}
+}
+
+func TestEnvKeepsDeclarations(t *testing.T) {
+	env := eval.NewEnv()
+	if _, err := env.Eval("fn square(x) { x * x }"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	value, err := env.Eval("square(9)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 81.0 {
+		t.Errorf("expected 81, got %v", value)
+	}
+}
@@ -0,0 +1,34 @@
+package eval
+
+import "context"
+
+type options struct {
+	context context.Context
+	osEnv   bool
+}
+
+func defaultOptions() options {
+	return options{context: context.Background(), osEnv: true}
+}
+
+// Option configures how a Program is compiled and run. Options given to
+// Compile apply to every run of the Program, those given to Run override them
+// for that run only.
+type Option func(*options)
+
+// WithContext sets the context handed to context-aware Go functions. The run
+// fails once the context is done.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.context = ctx
+	}
+}
+
+// WithOSEnv controls whether identifiers that are not declared anywhere are
+// looked up in the environment variables of the process. It is enabled by
+// default.
+func WithOSEnv(enabled bool) Option {
+	return func(o *options) {
+		o.osEnv = enabled
+	}
+}
@@ -0,0 +1,205 @@
+package eval
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Prepared is a Program compiled for repeated evaluation: every identifier is
+// resolved to a slot once, so that evaluating it again neither parses the
+// source nor looks names up by string. It is safe for concurrent use.
+//
+// A Prepared program does not keep its declarations between evaluations;
+// each evaluation starts from the bindings it is given.
+type Prepared struct {
+	program  *Program
+	compiled *ast.CompiledProgram
+	// Slot of every field of the struct types used as bindings, indexed by
+	// field. -1 for fields the program does not use.
+	fields sync.Map
+}
+
+// Prepare compiles the program for repeated evaluation. The result is cached,
+// calling Prepare again is cheap.
+func (this *Program) Prepare() *Prepared {
+	this.prepareOnce.Do(func() {
+		this.prepared = &Prepared{program: this, compiled: ast.Compile(this.root)}
+	})
+	return this.prepared
+}
+
+func (this *Prepared) Program() *Program {
+	return this.program
+}
+
+// Globals returns the names the program expects to be bound, along with its
+// top level declarations.
+func (this *Prepared) Globals() []string {
+	return append([]string(nil), this.compiled.Globals()...)
+}
+
+// Eval evaluates the program against bindings and returns the value of its
+// last statement converted as in Program.Run. bindings is one of:
+//   - nil, for no bindings
+//   - a map[string]any or map[string]Value
+//   - a struct or pointer to struct, whose exported fields are bound by name,
+//     or by the name given in an `eval:"name"` tag. The tag "-" skips a field
+//   - *Bindings, the cheapest for repeated evaluations
+//
+// Values are converted as in Env.Set, Go functions as in Env.RegisterFunc.
+// Identifiers left unbound fall back to the built-in constants and functions
+// and, unless disabled with WithOSEnv, to the environment variables.
+func (this *Prepared) Eval(bindings any, opts ...Option) (any, error) {
+	value, err := this.EvalValue(bindings, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return fromValue(value), nil
+}
+
+// EvalValue is Eval without the conversion of the result to a Go value.
+func (this *Prepared) EvalValue(bindings any, opts ...Option) (Value, error) {
+	globals, err := this.globals(bindings)
+	if err != nil {
+		return nil, err
+	}
+	options := defaultOptions()
+	for _, option := range this.program.options {
+		option(&options)
+	}
+	for _, option := range opts {
+		option(&options)
+	}
+	return this.compiled.Run(options.context, globals, options.osEnv)
+}
+
+func (this *Prepared) globals(bindings any) ([]Value, error) {
+	switch b := bindings.(type) {
+	case nil:
+		return nil, nil
+	case *Bindings:
+		if b.prepared != this {
+			return nil, fmt.Errorf("bindings created by another program")
+		}
+		return b.values, nil
+	case map[string]Value:
+		globals := make([]Value, len(this.compiled.Globals()))
+		for slot, name := range this.compiled.Globals() {
+			globals[slot] = b[name]
+		}
+		return globals, nil
+	case map[string]any:
+		globals := make([]Value, len(this.compiled.Globals()))
+		for slot, name := range this.compiled.Globals() {
+			if value, exist := b[name]; exist {
+				converted, err := bindingValue(name, value)
+				if err != nil {
+					return nil, err
+				}
+				globals[slot] = converted
+			}
+		}
+		return globals, nil
+	}
+
+	reflected := reflect.ValueOf(bindings)
+	if reflected.Kind() == reflect.Pointer && !reflected.IsNil() {
+		reflected = reflected.Elem()
+	}
+	if reflected.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unsupported bindings type %T", bindings)
+	}
+	globals := make([]Value, len(this.compiled.Globals()))
+	for field, slot := range this.fieldSlots(reflected.Type()) {
+		if slot < 0 {
+			continue
+		}
+		name := this.compiled.Globals()[slot]
+		converted, err := bindingValue(name, reflected.Field(field).Interface())
+		if err != nil {
+			return nil, err
+		}
+		globals[slot] = converted
+	}
+	return globals, nil
+}
+
+func (this *Prepared) fieldSlots(structType reflect.Type) []int {
+	if slots, ok := this.fields.Load(structType); ok {
+		return slots.([]int)
+	}
+	slots := make([]int, structType.NumField())
+	for i := range slots {
+		slots[i] = -1
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("eval"); ok {
+			name, _, _ = strings.Cut(tag, ",")
+		}
+		if slot, exist := this.compiled.Slot(name); exist && name != "-" {
+			slots[i] = slot
+		}
+	}
+	this.fields.Store(structType, slots)
+	return slots
+}
+
+// Bindings are values bound to the identifiers of a Prepared program, kept
+// converted between evaluations. Bindings are not safe for concurrent use.
+type Bindings struct {
+	prepared *Prepared
+	values   []Value
+}
+
+func (this *Prepared) NewBindings() *Bindings {
+	return &Bindings{prepared: this, values: make([]Value, len(this.compiled.Globals()))}
+}
+
+// Set binds name to value, converted as in Env.Set. Names the program does not
+// use are ignored.
+func (this *Bindings) Set(name string, value any) error {
+	slot, exist := this.prepared.compiled.Slot(name)
+	if !exist {
+		return nil
+	}
+	converted, err := bindingValue(name, value)
+	if err != nil {
+		return err
+	}
+	this.values[slot] = converted
+	return nil
+}
+
+// SetValue binds name to value without any conversion.
+func (this *Bindings) SetValue(name string, value Value) {
+	if slot, exist := this.prepared.compiled.Slot(name); exist {
+		this.values[slot] = value
+	}
+}
+
+// Unset removes the binding of name.
+func (this *Bindings) Unset(name string) {
+	if slot, exist := this.prepared.compiled.Slot(name); exist {
+		this.values[slot] = nil
+	}
+}
+
+// bindingValue converts value to a value of the language, binding Go
+// functions as callables.
+func bindingValue(name string, value any) (ast.Value, error) {
+	if value != nil && reflect.TypeOf(value).Kind() == reflect.Func {
+		return bind(name, value)
+	}
+	converted, err := toValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot bind %s: %v", name, err)
+	}
+	return converted, nil
+}
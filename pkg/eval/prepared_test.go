@@ -0,0 +1,225 @@
+package eval_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+	"github.com/jayjunior/eval/pkg/eval"
+)
+
+const pricing = "var total; total = price * quantity * (1 - discount); total > 100 && total < limit"
+
+func TestPreparedEvalMap(t *testing.T) {
+	prepared := eval.MustCompile(pricing).Prepare()
+	value, err := prepared.Eval(map[string]any{"price": 30, "quantity": 4, "discount": 0.1, "limit": 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != true {
+		t.Errorf("expected true, got %v", value)
+	}
+}
+
+func TestPreparedEvalStruct(t *testing.T) {
+	type order struct {
+		Price    float64 `eval:"price"`
+		Quantity int     `eval:"quantity"`
+		Discount float64 `eval:"discount"`
+		Limit    float64 `eval:"limit"`
+		Ignored  string  `eval:"-"`
+	}
+	prepared := eval.MustCompile(pricing).Prepare()
+	for _, bindings := range []any{order{30, 4, 0.5, 1000, ""}, &order{30, 4, 0.5, 1000, ""}} {
+		value, err := prepared.Eval(bindings)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if value != false {
+			t.Errorf("expected false, got %v", value)
+		}
+	}
+}
+
+func TestPreparedEvalStructFieldNames(t *testing.T) {
+	value, err := eval.MustCompile("Width * Height").Prepare().Eval(struct{ Width, Height float64 }{3, 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 12.0 {
+		t.Errorf("expected 12, got %v", value)
+	}
+}
+
+func TestPreparedBindings(t *testing.T) {
+	prepared := eval.MustCompile("x * 2 + offset").Prepare()
+	bindings := prepared.NewBindings()
+	bindings.Set("offset", 1)
+	bindings.Set("unused", 1)
+	for i := range 3 {
+		bindings.Set("x", i)
+		value, err := prepared.Eval(bindings)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if value != float64(i*2+1) {
+			t.Errorf("expected %d, got %v", i*2+1, value)
+		}
+	}
+
+	bindings.Unset("x")
+	if _, err := prepared.Eval(bindings, eval.WithOSEnv(false)); err == nil || !strings.Contains(err.Error(), "undeclared identifier x") {
+		t.Errorf("expected undeclared identifier error, got %v", err)
+	}
+	if _, err := eval.MustCompile("x").Prepare().Eval(bindings); err == nil {
+		t.Error("expected an error for bindings of another program")
+	}
+}
+
+func TestPreparedGoFunction(t *testing.T) {
+	prepared := eval.MustCompile("double(x)").Prepare()
+	value, err := prepared.Eval(map[string]any{"x": 21, "double": func(x float64) float64 { return x * 2 }})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 42.0 {
+		t.Errorf("expected 42, got %v", value)
+	}
+}
+
+func TestPreparedDoesNotKeepDeclarations(t *testing.T) {
+	prepared := eval.MustCompile("var x; x = x + n").Prepare()
+	for range 2 {
+		value, err := prepared.Eval(map[string]any{"n": 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if value != 2.0 {
+			t.Errorf("expected 2, got %v", value)
+		}
+	}
+}
+
+func TestPreparedBuiltinsAndShadowing(t *testing.T) {
+	prepared := eval.MustCompile("var pi; pi = 3; pi + sqrt(16)").Prepare()
+	value, err := prepared.Eval(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 7.0 {
+		t.Errorf("expected 7, got %v", value)
+	}
+}
+
+func TestPreparedFunctionsAndClosures(t *testing.T) {
+	prepared := eval.MustCompile(`
+fn counter() {
+	var n
+	fn() { n = n + step; n }
+}
+var next
+next = counter()
+next()
+next()
+`).Prepare()
+	value, err := prepared.Eval(map[string]any{"step": 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 10.0 {
+		t.Errorf("expected 10, got %v", value)
+	}
+}
+
+func TestPreparedErrorPosition(t *testing.T) {
+	_, err := eval.MustCompile("var x\nx + flag").Prepare().Eval(map[string]any{"flag": true})
+	if err == nil || err.Error() != "2:1: cannot add number and bool" {
+		t.Errorf("expected a positioned type error, got %v", err)
+	}
+}
+
+func TestPreparedUnsupportedBindings(t *testing.T) {
+	if _, err := eval.MustCompile("1").Prepare().Eval(42); err == nil {
+		t.Error("expected an error for unsupported bindings")
+	}
+	if _, err := eval.MustCompile("x").Prepare().Eval(map[string]any{"x": []int{}}); err == nil {
+		t.Error("expected an error for an unsupported value")
+	}
+}
+
+func TestPreparedConcurrentEval(t *testing.T) {
+	prepared := eval.MustCompile("fn square(v) { v * v }; square(x) + 1").Prepare()
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := prepared.Eval(map[string]any{"x": i})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if value != float64(i*i+1) {
+				t.Errorf("expected %d, got %v", i*i+1, value)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkTokenizeParseEvaluate(b *testing.B) {
+	for b.Loop() {
+		tokens, _ := internal.Tokenize(pricing)
+		root, _ := internal.Parse(tokens)
+		evaluator := ast.Evaluator{DisableOSEnv: true}
+		evaluator.Define("price", ast.Number(30))
+		evaluator.Define("quantity", ast.Number(4))
+		evaluator.Define("discount", ast.Number(0.1))
+		evaluator.Define("limit", ast.Number(1000))
+		if _, err := evaluator.Evaluate(root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPreparedMap(b *testing.B) {
+	prepared := eval.MustCompile(pricing, eval.WithOSEnv(false)).Prepare()
+	bindings := map[string]any{"price": 30, "quantity": 4, "discount": 0.1, "limit": 1000}
+	for b.Loop() {
+		if _, err := prepared.Eval(bindings); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPreparedStruct(b *testing.B) {
+	type order struct {
+		Price    float64 `eval:"price"`
+		Quantity float64 `eval:"quantity"`
+		Discount float64 `eval:"discount"`
+		Limit    float64 `eval:"limit"`
+	}
+	prepared := eval.MustCompile(pricing, eval.WithOSEnv(false)).Prepare()
+	bindings := &order{30, 4, 0.1, 1000}
+	for b.Loop() {
+		if _, err := prepared.Eval(bindings); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPreparedBindings(b *testing.B) {
+	prepared := eval.MustCompile(pricing, eval.WithOSEnv(false)).Prepare()
+	bindings := prepared.NewBindings()
+	bindings.Set("price", 30)
+	bindings.Set("quantity", 4)
+	bindings.Set("discount", 0.1)
+	bindings.Set("limit", 1000)
+	for b.Loop() {
+		if _, err := prepared.Eval(bindings); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package eval
+
+import (
+	"sync"
+
+	"github.com/jayjunior/eval/internal"
+	"github.com/jayjunior/eval/internal/ast"
+)
+
+// Program is compiled source. It is immutable and safe to run concurrently
+// against different Envs.
+type Program struct {
+	source  string
+	root    ast.Expression
+	options []Option
+
+	prepareOnce sync.Once
+	prepared    *Prepared
+}
+
+// Compile parses src. Syntax errors are returned as a *Diagnostic for lexical
+// errors or as Diagnostics listing every error of the parser.
+func Compile(src string, opts ...Option) (*Program, error) {
+	tokens, err := internal.Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	root, err := internal.Parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{source: src, root: root, options: opts}, nil
+}
+
+// MustCompile is Compile for sources known to be valid. It panics on error.
+func MustCompile(src string, opts ...Option) *Program {
+	program, err := Compile(src, opts...)
+	if err != nil {
+		panic("eval: Compile(" + src + "): " + err.Error())
+	}
+	return program
+}
+
+func (this *Program) Source() string {
+	return this.source
+}
+
+// AST returns the root of the program. It must not be modified.
+func (this *Program) AST() Expression {
+	return this.root
+}
+
+// Run evaluates the program in env, or in an empty Env when env is nil, and
+// returns the value of its last statement as a Go value: float64, bool,
+// string, nil, or the Value itself for functions. Numbers of every kind are
+// converted to the closest float64; RunValue keeps exact integers and
+// rationals.
+func (this *Program) Run(env *Env, opts ...Option) (any, error) {
+	value, err := this.RunValue(env, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return fromValue(value), nil
+}
+
+// RunValue is Run without the conversion of the result to a Go value.
+func (this *Program) RunValue(env *Env, opts ...Option) (Value, error) {
+	if env == nil {
+		env = NewEnv()
+	}
+	options := defaultOptions()
+	for _, option := range this.options {
+		option(&options)
+	}
+	for _, option := range opts {
+		option(&options)
+	}
+	env.evaluator.DisableOSEnv = !options.osEnv
+	return env.evaluator.EvaluateContext(options.context, this.root)
+}
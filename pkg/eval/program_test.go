@@ -0,0 +1,218 @@
+package eval_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jayjunior/eval/pkg/eval"
+)
+
+func TestCompileAndRun(t *testing.T) {
+	program, err := eval.Compile("var total; total = price * quantity; total > 100")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	env := eval.NewEnv()
+	env.Set("price", 30)
+	env.Set("quantity", 4)
+	value, err := program.Run(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != true {
+		t.Errorf("expected true, got %v", value)
+	}
+}
+
+func TestRunWithoutEnv(t *testing.T) {
+	value, err := eval.MustCompile("sqrt(2 * 8)").Run(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 4.0 {
+		t.Errorf("expected 4, got %v", value)
+	}
+}
+
+func TestRunValue(t *testing.T) {
+	value, err := eval.MustCompile("fn(x) => x").RunValue(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := value.(*eval.Function); !ok {
+		t.Errorf("expected *eval.Function, got %T", value)
+	}
+}
+
+func TestRunExactNumbers(t *testing.T) {
+	program := eval.MustCompile("1 / 4 + 100000000000000000000")
+	value, err := program.Run(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 1e20 {
+		t.Errorf("expected Run to convert to float64, got %v (%T)", value, value)
+	}
+	exact, err := program.RunValue(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rational, ok := exact.(eval.Rational); !ok || rational.String() != "400000000000000000001/4" {
+		t.Errorf("expected RunValue to keep the rational, got %v (%T)", exact, exact)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := eval.Compile("1 +\n(2 3)")
+	var diagnostics eval.Diagnostics
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("expected eval.Diagnostics, got %T: %v", err, err)
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("expected 2 errors, got %d", len(diagnostics))
+	}
+
+	_, err = eval.Compile("1 @ 2")
+	var diagnostic *eval.Diagnostic
+	if !errors.As(err, &diagnostic) {
+		t.Fatalf("expected *eval.Diagnostic, got %T: %v", err, err)
+	}
+	if diagnostic.Span.Start.Column != 3 {
+		t.Errorf("expected error at column 3, got %s", diagnostic.Span)
+	}
+}
+
+func TestWithOSEnv(t *testing.T) {
+	t.Setenv("EVAL_PKG_TEST_LIMIT", "5")
+	program := eval.MustCompile("number(EVAL_PKG_TEST_LIMIT) * 2")
+
+	value, err := program.Run(nil)
+	if err != nil || value != 10.0 {
+		t.Errorf("expected 10, got %v (%v)", value, err)
+	}
+	if _, err := program.Run(nil, eval.WithOSEnv(false)); err == nil {
+		t.Error("expected error with the process environment disabled, got nil")
+	}
+
+	sandboxed := eval.MustCompile("EVAL_PKG_TEST_LIMIT", eval.WithOSEnv(false))
+	if _, err := sandboxed.Run(nil); err == nil {
+		t.Error("expected error with the process environment disabled, got nil")
+	}
+	if value, err := sandboxed.Run(nil, eval.WithOSEnv(true)); err != nil || value != "5" {
+		t.Errorf("expected run options to override compile options, got %v (%v)", value, err)
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	program := eval.MustCompile("fn f(x) { x * factor }; f(input)")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				env := eval.NewEnv()
+				env.Set("factor", g)
+				env.Set("input", i)
+				value, err := program.Run(env)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if value != float64(g*i) {
+					errs <- fmt.Errorf("expected %d, got %v", g*i, value)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// identifierCollector is a visitor written outside of the module.
+type identifierCollector struct {
+	names []string
+}
+
+func (this *identifierCollector) Visit(exp eval.Expression) (eval.Value, error) {
+	switch e := exp.(type) {
+	case *eval.Identifier:
+		this.names = append(this.names, e.TokenLiteral.Literal)
+	case *eval.BinaryExpression:
+		e.Lhs.Accept(this)
+		e.Rhs.Accept(this)
+	case *eval.Call:
+		e.Callee.Accept(this)
+		for _, argument := range e.Arguments {
+			argument.Accept(this)
+		}
+	}
+	return eval.Nil{}, nil
+}
+
+func TestCustomVisitor(t *testing.T) {
+	program := eval.MustCompile("price * max(rate, 2)")
+	collector := &identifierCollector{}
+	if _, err := program.AST().Accept(collector); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"price", "max", "rate"}
+	if fmt.Sprint(collector.names) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, collector.names)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	program := eval.MustCompile("fn f(a) { a + 1 }; f(2) * 3")
+	calls := 0
+	constants := 0
+	eval.Walk(program.AST(), func(exp eval.Expression) bool {
+		switch exp.(type) {
+		case *eval.Call:
+			calls++
+		case *eval.Constant:
+			constants++
+		}
+		return true
+	})
+	if calls != 1 || constants != 3 {
+		t.Errorf("expected 1 call and 3 constants, got %d and %d", calls, constants)
+	}
+}
+
+func TestBuiltinsAreListed(t *testing.T) {
+	found := false
+	for _, builtin := range eval.Builtins() {
+		if builtin.Name == "hypot" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected 'hypot' in eval.Builtins()")
+	}
+	if eval.Constants()["pi"] == nil {
+		t.Error("expected 'pi' in eval.Constants()")
+	}
+}
+
+func TestRunStrings(t *testing.T) {
+	env := eval.NewEnv()
+	env.Set("name", "Ada")
+	env.Set("count", 3)
+	value, err := eval.MustCompile(`"${upper(name)} has ${count} items"`).Run(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "ADA has 3 items" {
+		t.Errorf("expected 'ADA has 3 items', got %v", value)
+	}
+}